@@ -74,6 +74,9 @@ func createNetworkFromCNIConfigList(conf *libcni.NetworkConfigList, confPath str
 		if bridge.Vlan != 0 {
 			network.Options[types.VLANOption] = strconv.Itoa(bridge.Vlan)
 		}
+		if len(bridge.VlanTrunk) > 0 {
+			network.Options[types.VLANTrunkOption] = internalutil.FormatVlanTrunk(vlanTrunkToRanges(bridge.VlanTrunk))
+		}
 
 		err = convertIPAMConfToNetwork(&network, &bridge.IPAM, confPath)
 		if err != nil {
@@ -302,8 +305,8 @@ func (n *cniNetwork) createCNIConfigListFromNetwork(network *types.Network, writ
 
 	switch network.Driver {
 	case types.BridgeNetworkDriver:
-		bridge := newHostLocalBridge(network.NetworkInterface, isGateway, ipMasq, opts.mtu, opts.vlan, ipamConf)
-		plugins = append(plugins, bridge, newPortMapPlugin(), newFirewallPlugin(opts.isolate), newTuningPlugin())
+		bridge := newHostLocalBridge(network.NetworkInterface, isGateway, ipMasq, opts.mtu, opts.vlan, opts.vlanTrunk, ipamConf)
+		plugins = append(plugins, bridge, newPortMapPlugin(), newFirewallPlugin(opts.isolate), newTuningPlugin(), newBandwidthPlugin())
 		// if we find the dnsname plugin we add configuration for it
 		if hasDNSNamePlugin(n.cniPluginDirs) && network.DNSEnabled {
 			// Note: in the future we might like to allow for dynamic domain names
@@ -395,6 +398,7 @@ func removeMachinePlugin(conf *libcni.NetworkConfigList) *libcni.NetworkConfigLi
 
 type options struct {
 	vlan           int
+	vlanTrunk      []internalutil.VlanRange
 	mtu            int
 	vlanPluginMode string
 	isolate        bool
@@ -417,6 +421,26 @@ func parseOptions(networkOptions map[string]string, networkDriver string) (*opti
 				return nil, err
 			}
 
+		case types.VLANTrunkOption:
+			if networkDriver != types.BridgeNetworkDriver {
+				return nil, errors.New("vlan_trunk option is only supported with the bridge driver")
+			}
+			opt.vlanTrunk, err = internalutil.ParseVlanTrunk(v)
+			if err != nil {
+				return nil, err
+			}
+
+		case types.VLANFilteringOption:
+			// the bridge plugin only enables vlan filtering together with a
+			// vlan or trunk, so it cannot be set on its own
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, err
+			}
+			if enabled && networkOptions[types.VLANOption] == "" && networkOptions[types.VLANTrunkOption] == "" {
+				return nil, fmt.Errorf("vlan_filtering without vlan or vlan_trunk option cannot be configured for backend CNI: %w", types.ErrInvalidArg)
+			}
+
 		case types.ModeOption:
 			switch networkDriver {
 			case types.MacVLANNetworkDriver:
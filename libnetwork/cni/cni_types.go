@@ -6,6 +6,7 @@ import (
 	"net"
 	"path/filepath"
 
+	"github.com/containers/common/libnetwork/internal/util"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/storage/pkg/fileutils"
 )
@@ -39,6 +40,15 @@ type cniPortMapEntry struct {
 	HostIP        string `json:"hostIP,omitempty"`
 }
 
+// cniBandwidthEntry struct is used by the bandwidth plugin
+// https://github.com/containernetworking/plugins/tree/main/plugins/meta/bandwidth#network-configuration-reference
+type cniBandwidthEntry struct {
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	EgressRate   uint64 `json:"egressRate,omitempty"`
+	EgressBurst  uint64 `json:"egressBurst,omitempty"`
+}
+
 // hostLocalBridge describes a configuration for a bridge plugin
 // https://github.com/containernetworking/plugins/tree/master/plugins/main/bridge#network-configuration-reference
 type hostLocalBridge struct {
@@ -52,10 +62,19 @@ type hostLocalBridge struct {
 	HairpinMode  bool            `json:"hairpinMode,omitempty"`
 	PromiscMode  bool            `json:"promiscMode,omitempty"`
 	Vlan         int             `json:"vlan,omitempty"`
+	VlanTrunk    []*vlanTrunk    `json:"vlanTrunk,omitempty"`
 	IPAM         ipamConfig      `json:"ipam"`
 	Capabilities map[string]bool `json:"capabilities,omitempty"`
 }
 
+// vlanTrunk describes a vlan ID or range allowed on the bridge port
+// https://github.com/containernetworking/plugins/tree/main/plugins/main/bridge#example-l2-only-vlan-configuration
+type vlanTrunk struct {
+	ID    *int `json:"id,omitempty"`
+	MinID *int `json:"minID,omitempty"`
+	MaxID *int `json:"maxID,omitempty"`
+}
+
 // ipamConfig describes an IPAM configuration
 // https://github.com/containernetworking/plugins/tree/master/plugins/ipam/host-local#network-configuration-reference
 type ipamConfig struct {
@@ -77,6 +96,7 @@ type ipamLocalHostRangeConf struct {
 // ipamRoute describes a route in an ipam config
 type ipamRoute struct {
 	Dest string `json:"dst"`
+	GW   string `json:"gw,omitempty"`
 }
 
 // portMapConfig describes the default portmapping config
@@ -95,6 +115,13 @@ type VLANConfig struct {
 	Capabilities map[string]bool `json:"capabilities,omitempty"`
 }
 
+// bandwidthConfig describes the bandwidth plugin, the limits are passed
+// per container in the runtime config
+type bandwidthConfig struct {
+	PluginType   string          `json:"type"`
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
 // firewallConfig describes the firewall plugin
 type firewallConfig struct {
 	PluginType    string `json:"type"`
@@ -137,7 +164,7 @@ func newNcList(name, version string, labels, options map[string]string) ncList {
 }
 
 // newHostLocalBridge creates a new LocalBridge for host-local
-func newHostLocalBridge(name string, isGateWay, ipMasq bool, mtu, vlan int, ipamConf *ipamConfig) *hostLocalBridge {
+func newHostLocalBridge(name string, isGateWay, ipMasq bool, mtu, vlan int, trunk []util.VlanRange, ipamConf *ipamConfig) *hostLocalBridge {
 	bridge := hostLocalBridge{
 		PluginType:  "bridge",
 		BrName:      name,
@@ -146,6 +173,7 @@ func newHostLocalBridge(name string, isGateWay, ipMasq bool, mtu, vlan int, ipam
 		MTU:         mtu,
 		HairpinMode: true,
 		Vlan:        vlan,
+		VlanTrunk:   newVlanTrunk(trunk),
 	}
 	if ipamConf != nil {
 		bridge.IPAM = *ipamConf
@@ -157,6 +185,36 @@ func newHostLocalBridge(name string, isGateWay, ipMasq bool, mtu, vlan int, ipam
 	return &bridge
 }
 
+// newVlanTrunk converts the vlan ranges to the trunk of the bridge plugin
+func newVlanTrunk(ranges []util.VlanRange) []*vlanTrunk {
+	if len(ranges) == 0 {
+		return nil
+	}
+	trunk := make([]*vlanTrunk, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Min == r.Max {
+			trunk = append(trunk, &vlanTrunk{ID: &r.Min})
+		} else {
+			trunk = append(trunk, &vlanTrunk{MinID: &r.Min, MaxID: &r.Max})
+		}
+	}
+	return trunk
+}
+
+// vlanTrunkToRanges converts the trunk of the bridge plugin to vlan ranges
+func vlanTrunkToRanges(trunk []*vlanTrunk) []util.VlanRange {
+	ranges := make([]util.VlanRange, 0, len(trunk))
+	for _, t := range trunk {
+		switch {
+		case t.ID != nil:
+			ranges = append(ranges, util.VlanRange{Min: *t.ID, Max: *t.ID})
+		case t.MinID != nil && t.MaxID != nil:
+			ranges = append(ranges, util.VlanRange{Min: *t.MinID, Max: *t.MaxID})
+		}
+	}
+	return ranges
+}
+
 // newIPAMHostLocalConf creates a new IPAMHostLocal configuration
 func newIPAMHostLocalConf(routes []ipamRoute, ipamRanges [][]ipamLocalHostRangeConf) ipamConfig {
 	ipamConf := ipamConfig{
@@ -219,6 +277,15 @@ func newPortMapPlugin() portMapConfig {
 	}
 }
 
+// newBandwidthPlugin creates the bandwidth plugin config, it does nothing
+// unless bandwidth limits are set for the container
+func newBandwidthPlugin() bandwidthConfig {
+	return bandwidthConfig{
+		PluginType:   "bandwidth",
+		Capabilities: map[string]bool{"bandwidth": true},
+	}
+}
+
 // newFirewallPlugin creates a generic firewall plugin
 func newFirewallPlugin(isolate bool) firewallConfig {
 	fw := firewallConfig{
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"slices"
 
 	internalutil "github.com/containers/common/libnetwork/internal/util"
@@ -14,8 +16,101 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func (n *cniNetwork) NetworkUpdate(_ string, _ types.NetworkUpdateOptions) error {
-	return fmt.Errorf("NetworkUpdate is not supported for backend CNI: %w", types.ErrInvalidArg)
+// NetworkUpdate updates the labels, options, lease ranges and subnets of the
+// network. The changes are used for new connections. DNS servers and routes
+// are not supported with CNI.
+func (n *cniNetwork) NetworkUpdate(name string, options types.NetworkUpdateOptions) error {
+	if len(options.AddDNSServers) > 0 || len(options.RemoveDNSServers) > 0 {
+		return fmt.Errorf("NetworkDNSServers cannot be configured for backend CNI: %w", types.ErrInvalidArg)
+	}
+	if len(options.AddRoutes) > 0 || len(options.RemoveRoutes) > 0 {
+		return fmt.Errorf("routes cannot be configured for backend CNI: %w", types.ErrInvalidArg)
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return err
+	}
+	oldNetwork, err := n.getNetwork(name)
+	if err != nil {
+		return err
+	}
+
+	var allocatedIPs []net.IP
+	if len(options.LeaseRanges) > 0 {
+		allocatedIPs, err = n.getAllocatedIPs(oldNetwork.libpodNet.Name)
+		if err != nil {
+			return err
+		}
+	}
+	newNetwork, err := internalutil.UpdateNetwork(n, oldNetwork.libpodNet, &options, allocatedIPs)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(oldNetwork.libpodNet, newNetwork) {
+		return nil
+	}
+
+	cniConf, path, err := n.createCNIConfigListFromNetwork(newNetwork, true)
+	if err != nil {
+		return err
+	}
+	// the config was loaded from a file with another name, remove it so
+	// the network is not defined twice
+	if oldNetwork.filename != "" && oldNetwork.filename != path {
+		if err := os.Remove(oldNetwork.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	n.networks[newNetwork.Name] = &network{cniNet: cniConf, libpodNet: newNetwork, filename: path}
+	return nil
+}
+
+func (n *cniNetwork) IPAMLeases(_ string) ([]types.IPAMLease, error) {
+	return nil, fmt.Errorf("IPAMLeases is not supported for backend CNI: %w", types.ErrInvalidArg)
+}
+
+func (n *cniNetwork) IPAMReserve(_ string, _ types.LeaseRange) error {
+	return fmt.Errorf("IPAMReserve is not supported for backend CNI: %w", types.ErrInvalidArg)
+}
+
+func (n *cniNetwork) IPAMUnreserve(_ string, _ types.LeaseRange) error {
+	return fmt.Errorf("IPAMUnreserve is not supported for backend CNI: %w", types.ErrInvalidArg)
+}
+
+func (n *cniNetwork) IPAMReclaim(_ types.IPAMReclaimOptions) ([]types.IPAMLease, error) {
+	return nil, fmt.Errorf("IPAMReclaim is not supported for backend CNI: %w", types.ErrInvalidArg)
+}
+
+// hostLocalDir returns the data dir of the host-local ipam plugin, it
+// contains one directory per network.
+func (n *cniNetwork) hostLocalDir() string {
+	if n.rootlessNetns != nil {
+		return filepath.Join(n.rootlessNetns.CNIVarDir(), "networks")
+	}
+	return filepath.Join(cniVarDir, "networks")
+}
+
+// getAllocatedIPs returns the ips leased by the host-local ipam plugin on
+// the network. The plugin stores one file per ip in its data dir.
+func (n *cniNetwork) getAllocatedIPs(name string) ([]net.IP, error) {
+	entries, err := os.ReadDir(filepath.Join(n.hostLocalDir(), name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(entries))
+	for _, entry := range entries {
+		// skip the lock and last_reserved_ip files
+		if ip := net.ParseIP(entry.Name()); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
 }
 
 // NetworkCreate will take a partial filled Network and fill the
@@ -86,7 +181,7 @@ func (n *cniNetwork) networkCreate(newNetwork *types.Network, defaultNet bool) (
 	switch newNetwork.Driver {
 	case types.BridgeNetworkDriver:
 		internalutil.MapDockerBridgeDriverOptions(newNetwork)
-		err = internalutil.CreateBridge(n, newNetwork, usedNetworks, n.defaultsubnetPools, true)
+		err = internalutil.CreateBridge(n, newNetwork, usedNetworks, n.defaultsubnetPools, n.defaultIPv6SubnetPools, true)
 		if err != nil {
 			return nil, err
 		}
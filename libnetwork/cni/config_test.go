@@ -10,8 +10,12 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/containers/common/internal/attributedstring"
+	"github.com/containers/common/libnetwork/cni"
+	"github.com/containers/common/libnetwork/netavark"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/libnetwork/util"
+	"github.com/containers/common/pkg/config"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	gomegaTypes "github.com/onsi/gomega/types"
@@ -1062,6 +1066,252 @@ var _ = Describe("Config", func() {
 			Expect(network1.Labels).To(ContainElement("value"))
 		})
 
+		It("reconcile network definitions", func() {
+			defDir := GinkgoT().TempDir()
+			writeDefinition := func(content string) {
+				err := os.WriteFile(filepath.Join(defDir, "web.json"), []byte(content), 0o644)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			newInterface := func(update bool) types.ContainerNetwork {
+				libpodNet, err := cni.NewCNINetworkInterface(&cni.InitConfig{
+					CNIConfigDir: cniConfDir,
+					Config: &config.Config{
+						Network: config.NetworkConfig{
+							CNIPluginDirs:            attributedstring.NewSlice(cniPluginDirs),
+							NetworkDefinitionsDir:    defDir,
+							NetworkDefinitionsUpdate: update,
+						},
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				return libpodNet
+			}
+
+			writeDefinition(`{"subnets": [{"subnet": "10.99.0.0/24"}], "labels": {"app": "web"}}`)
+			libpodNet := newInterface(false)
+			network, err := libpodNet.NetworkInspect("web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network.Subnets).To(HaveLen(1))
+			Expect(network.Subnets[0].Subnet.String()).To(Equal("10.99.0.0/24"))
+			Expect(network.Labels).To(Equal(map[string]string{"app": "web"}))
+
+			writeDefinition(`{"subnets": [{"subnet": "10.99.0.0/24"}], "labels": {"app": "db"}}`)
+			logBuffer.Reset()
+			libpodNet = newInterface(false)
+			Expect(logBuffer.String()).To(ContainSubstring("Network web differs from definition"))
+			network, err = libpodNet.NetworkInspect("web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network.Labels).To(Equal(map[string]string{"app": "web"}))
+
+			// dns servers cannot be updated with CNI, the other fields are
+			writeDefinition(`{"subnets": [{"subnet": "10.99.0.0/24"}], "labels": {"app": "db"}, "network_dns_servers": ["10.99.0.53"]}`)
+			logBuffer.Reset()
+			libpodNet = newInterface(true)
+			Expect(logBuffer.String()).To(ContainSubstring("in network_dns_servers which cannot be updated"))
+			network, err = libpodNet.NetworkInspect("web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network.Labels).To(Equal(map[string]string{"app": "db"}))
+
+			logBuffer.Reset()
+			newInterface(true)
+			Expect(logBuffer.String()).ToNot(ContainSubstring("network_dns_servers"))
+		})
+
+		It("export networks and import them into netavark", func() {
+			subnet, _ := types.ParseCIDR("10.99.0.0/24")
+			network1, err := libpodNet.NetworkCreate(types.Network{
+				Name:    "net1",
+				Subnets: []types.Subnet{{Subnet: subnet}},
+				Labels:  map[string]string{"app": "web"},
+			}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			export, err := libpodNet.NetworkExport()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(export.Backend).To(Equal(types.CNI))
+			Expect(export.Networks).To(HaveLen(1))
+			Expect(export.Networks[0].Network).To(Equal(network1))
+
+			netavarkDir := GinkgoT().TempDir()
+			netavarkNet, err := netavark.NewNetworkInterface(&netavark.InitConfig{
+				Config:           &config.Config{},
+				NetworkConfigDir: netavarkDir,
+				NetworkRunDir:    netavarkDir,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			networks, err := netavarkNet.NetworkImport(export, types.NetworkImportOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(HaveLen(1))
+			Expect(networks[0].Name).To(Equal("net1"))
+			Expect(networks[0].NetworkInterface).To(Equal(network1.NetworkInterface))
+			Expect(networks[0].Subnets).To(Equal(network1.Subnets))
+			Expect(networks[0].Labels).To(Equal(network1.Labels))
+
+			// import back into another cni config dir
+			otherNet, err := getNetworkInterface(GinkgoT().TempDir())
+			Expect(err).ToNot(HaveOccurred())
+			networks, err = otherNet.NetworkImport(export, types.NetworkImportOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(HaveLen(1))
+			Expect(networks[0].ID).To(Equal(network1.ID))
+
+			export.Networks[0].Name = "net2"
+			export.Networks[0].Reservations = []types.LeaseRange{{StartIP: net.ParseIP("10.99.0.10")}}
+			_, err = otherNet.NetworkImport(export, types.NetworkImportOptions{})
+			Expect(err).To(MatchError(types.ErrInvalidArg))
+		})
+
+		It("bridge network supports bandwidth limits", func() {
+			network1, err := libpodNet.NetworkCreate(types.Network{}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			path := filepath.Join(cniConfDir, network1.Name+".conflist")
+			Expect(path).To(BeARegularFile())
+			grepInFile(path, `"type": "bandwidth"`)
+
+			network2, err := libpodNet.NetworkCreate(types.Network{Driver: types.MacVLANNetworkDriver}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(cniConfDir, network2.Name+".conflist")
+			Expect(path).To(BeARegularFile())
+			Expect(os.ReadFile(path)).ToNot(ContainSubstring(`"type": "bandwidth"`))
+
+			setupOpts := types.SetupOptions{
+				NetworkOptions: types.NetworkOptions{
+					ContainerID: "someID",
+					Networks: map[string]types.PerNetworkOptions{
+						network2.Name: {
+							InterfaceName: "eth0",
+							Bandwidth:     &types.BandwidthOptions{EgressRate: 1000, EgressBurst: 1000},
+						},
+					},
+				},
+			}
+			_, err = libpodNet.Setup("/run/netns/invalid", setupOpts)
+			Expect(err).To(MatchError(ContainSubstring("bandwidth limits are not supported for driver macvlan")))
+		})
+
+		It("create bridge with vlan trunk", func() {
+			network := types.Network{
+				Options: map[string]string{
+					types.VLANOption:          "5",
+					types.VLANTrunkOption:     "10,20-30",
+					types.VLANFilteringOption: "true",
+				},
+			}
+			network1, err := libpodNet.NetworkCreate(network, nil)
+			Expect(err).ToNot(HaveOccurred())
+			path := filepath.Join(cniConfDir, network1.Name+".conflist")
+			Expect(path).To(BeARegularFile())
+			grepInFile(path, `"vlan": 5`)
+			grepInFile(path, `"vlanTrunk"`)
+			grepInFile(path, `"minID": 20`)
+
+			network2, err := libpodNet.NetworkInspect(network1.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2.Options).To(HaveKeyWithValue(types.VLANTrunkOption, "10,20-30"))
+
+			// the bridge plugin cannot enable vlan filtering on its own
+			_, err = libpodNet.NetworkCreate(types.Network{
+				Options: map[string]string{types.VLANFilteringOption: "true"},
+			}, nil)
+			Expect(err).To(MatchError(ContainSubstring("vlan_filtering without vlan or vlan_trunk option cannot be configured for backend CNI")))
+
+			_, err = libpodNet.NetworkCreate(types.Network{
+				Driver:  "macvlan",
+				Options: map[string]string{types.VLANTrunkOption: "10"},
+			}, nil)
+			Expect(err).To(MatchError(ContainSubstring("vlan_trunk option is only supported with the bridge driver")))
+		})
+
+		It("setup with container vlan options", func() {
+			network1, err := libpodNet.NetworkCreate(types.Network{}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+				NetworkOptions: types.NetworkOptions{
+					ContainerID: "someID",
+					Networks: map[string]types.PerNetworkOptions{
+						network1.Name: {
+							InterfaceName: "eth0",
+							Options:       map[string]string{types.VLANOption: "10"},
+						},
+					},
+				},
+			})
+			Expect(err).To(MatchError(ContainSubstring("vlan options require a bridge network with vlan filtering")))
+		})
+
+		It("setup with unsupported route options", func() {
+			network1, err := libpodNet.NetworkCreate(types.Network{}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			dest, _ := types.ParseCIDR("10.5.0.0/16")
+			metric := uint32(50)
+			for _, tt := range []struct {
+				opts types.PerNetworkOptions
+				msg  string
+			}{
+				{
+					opts: types.PerNetworkOptions{DefaultRouteMetric: &metric},
+					msg:  "DefaultRouteMetric is not supported for backend CNI",
+				},
+				{
+					opts: types.PerNetworkOptions{Routes: []types.Route{{Destination: dest, Gateway: net.ParseIP("10.88.0.5"), Metric: &metric}}},
+					msg:  "route metrics are not supported for backend CNI",
+				},
+			} {
+				opts := tt.opts
+				opts.InterfaceName = "eth0"
+				_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+					NetworkOptions: types.NetworkOptions{
+						ContainerID: "someID",
+						Networks:    map[string]types.PerNetworkOptions{network1.Name: opts},
+					},
+				})
+				Expect(err).To(MatchError(ContainSubstring(tt.msg)))
+			}
+		})
+
+		It("update network labels, options and subnets", func() {
+			network := types.Network{
+				Labels: map[string]string{"a": "1"},
+			}
+			network1, err := libpodNet.NetworkCreate(network, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			subnet, _ := types.ParseCIDR("fd00:2::/64")
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				AddLabels:    map[string]string{"b": "2"},
+				RemoveLabels: []string{"a"},
+				SetOptions:   map[string]string{types.MTUOption: "1400"},
+				AddSubnets:   []types.Subnet{{Subnet: subnet}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			path := filepath.Join(cniConfDir, network1.Name+".conflist")
+			grepInFile(path, `"mtu": 1400,`)
+			grepInFile(path, `"subnet": "fd00:2::/64"`)
+
+			// reload the config from disk
+			libpodNet, err = getNetworkInterface(cniConfDir)
+			Expect(err).ToNot(HaveOccurred())
+			network2, err := libpodNet.NetworkInspect(network1.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2.Labels).To(Equal(map[string]string{"b": "2"}))
+			Expect(network2.Options).To(HaveKeyWithValue(types.MTUOption, "1400"))
+			Expect(network2.Subnets).To(HaveLen(2))
+			Expect(network2.IPv6Enabled).To(BeTrue())
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				AddDNSServers: []string{"8.8.8.8"},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("NetworkDNSServers cannot be configured for backend CNI"))
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				SetOptions: map[string]string{types.MetricOption: "100"},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unsupported network option metric"))
+		})
+
 		It("create network with mtu option", func() {
 			network := types.Network{
 				Options: map[string]string{
@@ -0,0 +1,96 @@
+//go:build (linux || freebsd) && cni
+
+package cni
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	internalutil "github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NetworkExport returns a portable document with the networks with the
+// given names or IDs. The networks are converted from the cni config lists,
+// the CNI backend has no IPAM reservations.
+func (n *cniNetwork) NetworkExport(namesOrIDs ...string) (*types.NetworkExport, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := internalutil.NetworksToExport(n, n.defaultNetwork, namesOrIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &types.NetworkExport{
+		Version:  types.NetworkExportVersion,
+		Backend:  types.CNI,
+		Networks: make([]types.ExportedNetwork, 0, len(networks)),
+	}
+	for _, network := range networks {
+		export.Networks = append(export.Networks, types.ExportedNetwork{Network: network})
+	}
+	return export, nil
+}
+
+// NetworkImport creates cni config lists for the networks in the export
+// document. The import is all or nothing, when one network cannot be created
+// the networks created before are removed again.
+func (n *cniNetwork) NetworkImport(export *types.NetworkExport, options types.NetworkImportOptions) ([]types.Network, error) {
+	if err := internalutil.ValidateNetworkExport(export); err != nil {
+		return nil, err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	toImport := make([]*types.ExportedNetwork, 0, len(export.Networks))
+	for i := range export.Networks {
+		exported := &export.Networks[i]
+		if _, ok := n.networks[exported.Name]; ok {
+			if options.IgnoreIfExists {
+				continue
+			}
+			return nil, fmt.Errorf("network name %s already used: %w", exported.Name, types.ErrNetworkExists)
+		}
+		if len(exported.Reservations) > 0 {
+			return nil, fmt.Errorf("network %s: IPAM reservations are not supported for backend CNI: %w", exported.Name, types.ErrInvalidArg)
+		}
+		toImport = append(toImport, exported)
+	}
+
+	created := make([]*network, 0, len(toImport))
+	for _, exported := range toImport {
+		newNetwork := internalutil.NetworkFromExport(exported)
+		network, err := n.networkCreate(&newNetwork, false)
+		if err != nil {
+			n.removeImportedNetworks(created)
+			return nil, fmt.Errorf("import network %s: %w", exported.Name, err)
+		}
+		n.networks[network.libpodNet.Name] = network
+		created = append(created, network)
+	}
+
+	networks := make([]types.Network, 0, len(created))
+	for _, network := range created {
+		networks = append(networks, *network.libpodNet)
+	}
+	return networks, nil
+}
+
+func (n *cniNetwork) removeImportedNetworks(networks []*network) {
+	for _, network := range networks {
+		if err := os.Remove(network.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Errorf("Failed to remove network %s: %v", network.libpodNet.Name, err)
+		}
+		delete(n.networks, network.libpodNet.Name)
+	}
+}
@@ -16,6 +16,8 @@ import (
 
 	"github.com/containernetworking/cni/libcni"
 	"github.com/containers/common/libnetwork/internal/rootlessnetns"
+	internalutil "github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/portregistry"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/pkg/config"
 	"github.com/containers/common/pkg/version"
@@ -25,7 +27,11 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-const defaultRootLockPath = "/run/lock/podman-cni.lock"
+const (
+	defaultRootLockPath = "/run/lock/podman-cni.lock"
+	// cniVarDir is the directory where the cni plugins store their state
+	cniVarDir = "/var/lib/cni"
+)
 
 type cniNetwork struct {
 	// cniConfigDir is directory where the cni config files are stored.
@@ -42,6 +48,9 @@ type cniNetwork struct {
 
 	// defaultsubnetPools contains the subnets which must be used to allocate a free subnet by network create
 	defaultsubnetPools []config.SubnetPool
+	// defaultIPv6SubnetPools contains the subnets which are used to allocate a free ipv6
+	// subnet by network create, a random subnet is used when it is empty
+	defaultIPv6SubnetPools []config.SubnetPool
 
 	// isMachine describes whenever podman runs in a podman machine environment.
 	isMachine bool
@@ -57,6 +66,10 @@ type cniNetwork struct {
 
 	// rootlessNetns is used for the rootless network setup/teardown
 	rootlessNetns *rootlessnetns.Netns
+
+	// portRegistry tracks the host ports of all containers to detect
+	// conflicts, it is nil when no RunDir is set
+	portRegistry *portregistry.Registry
 }
 
 type network struct {
@@ -126,19 +139,37 @@ func NewCNINetworkInterface(conf *InitConfig) (types.ContainerNetwork, error) {
 		defaultSubnetPools = config.DefaultSubnetPools
 	}
 
+	var portRegistry *portregistry.Registry
+	if conf.RunDir != "" {
+		portRegistry, err = portregistry.New(filepath.Join(conf.RunDir, "ports"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	cni := libcni.NewCNIConfig(conf.Config.Network.CNIPluginDirs.Values, &cniExec{})
 	n := &cniNetwork{
-		cniConfigDir:       conf.CNIConfigDir,
-		cniPluginDirs:      conf.Config.Network.CNIPluginDirs.Get(),
-		cniConf:            cni,
-		defaultNetwork:     defaultNetworkName,
-		defaultSubnet:      defaultNet,
-		defaultsubnetPools: defaultSubnetPools,
-		isMachine:          conf.IsMachine,
-		lock:               lock,
-		rootlessNetns:      netns,
+		cniConfigDir:           conf.CNIConfigDir,
+		cniPluginDirs:          conf.Config.Network.CNIPluginDirs.Get(),
+		cniConf:                cni,
+		defaultNetwork:         defaultNetworkName,
+		defaultSubnet:          defaultNet,
+		defaultsubnetPools:     defaultSubnetPools,
+		defaultIPv6SubnetPools: conf.Config.Network.DefaultIPv6SubnetPools,
+		isMachine:              conf.IsMachine,
+		lock:                   lock,
+		rootlessNetns:          netns,
+		portRegistry:           portRegistry,
 	}
 
+	internalutil.ReconcileNetworkDefinitions(n, &internalutil.NetworkDefinitionsOptions{
+		Dir:       conf.Config.Network.NetworkDefinitionsDir,
+		Update:    conf.Config.Network.NetworkDefinitionsUpdate,
+		StampFile: filepath.Join(n.cniConfigDir, internalutil.DefinitionsStampFile),
+		// NetworkUpdate of the CNI backend rejects them
+		ReadonlyFields: []string{"routes", "network_dns_servers"},
+	})
+
 	return n, nil
 }
 
@@ -0,0 +1,181 @@
+//go:build (linux || freebsd) && cni
+
+package cni
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	internalutil "github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/libnetwork/util"
+)
+
+// NetworkReconcile runs the teardown for the cached attachments of
+// containers which are not running, this also removes their firewall rules
+// and port mappings. It then removes the remaining host-local leases and the
+// port reservations of these containers, the leases of removed networks and the veths attached to the
+// bridges of networks without a running container. The bridges are kept,
+// the CNI bridge plugin never removes them. Unmanaged networks and bridges
+// which were not created by the bridge plugin are not touched.
+func (n *cniNetwork) NetworkReconcile(options types.NetworkReconcileOptions) (*types.NetworkReconcileReport, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	running := internalutil.RunningContainerSet(&options)
+	report := &types.NetworkReconcileReport{}
+	var errs []error
+
+	// the cache is only readable in the rootless netns which must not be
+	// created here, the host-local leases are still reconciled
+	if n.rootlessNetns == nil {
+		attachments, err := n.cniConf.GetCachedAttachments("")
+		if err != nil {
+			return nil, fmt.Errorf("get cached attachments: %w", err)
+		}
+		for _, attachment := range attachments {
+			if running[attachment.ContainerID] {
+				continue
+			}
+			report.Attachments = append(report.Attachments, types.OrphanedAttachment{
+				ContainerID:   attachment.ContainerID,
+				Network:       attachment.Network,
+				InterfaceName: attachment.IfName,
+			})
+			if options.DryRun {
+				continue
+			}
+			err := n.teardown("", types.TeardownOptions{NetworkOptions: types.NetworkOptions{
+				ContainerID: attachment.ContainerID,
+				Networks:    map[string]types.PerNetworkOptions{attachment.Network: {InterfaceName: attachment.IfName}},
+			}})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("teardown container ID %s on network %s: %w", attachment.ContainerID, attachment.Network, err))
+			}
+		}
+	}
+
+	leases, err := n.reconcileLeases(report, running, options.DryRun)
+	if err != nil {
+		return report, errors.Join(append(errs, err)...)
+	}
+
+	report.Ports, err = internalutil.ReconcilePorts(n.portRegistry, running, options.DryRun)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if n.rootlessNetns == nil {
+		var bridgeNetworks []*types.Network
+		for _, network := range n.networks {
+			if network.libpodNet.IPAMOptions[types.Driver] == types.HostLocalIPAMDriver {
+				bridgeNetworks = append(bridgeNetworks, network.libpodNet)
+			}
+		}
+		report.Interfaces, err = internalutil.OrphanedInterfaces(internalutil.UnusedBridgeNetworks(bridgeNetworks, leases, running))
+		if err != nil {
+			errs = append(errs, err)
+		} else if !options.DryRun {
+			errs = append(errs, internalutil.RemoveInterfaces(report.Interfaces))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// reconcileLeases adds the host-local leases of containers which are not
+// running and the data dirs of removed networks to the report and removes
+// them unless dryRun is set. It returns all leases.
+func (n *cniNetwork) reconcileLeases(report *types.NetworkReconcileReport, running map[string]bool, dryRun bool) ([]types.IPAMLease, error) {
+	dir := n.hostLocalDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var all []types.IPAMLease
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		netDir := filepath.Join(dir, entry.Name())
+		network, ok := n.networks[entry.Name()]
+		if !ok {
+			report.IPAMNetworks = append(report.IPAMNetworks, entry.Name())
+			if !dryRun {
+				if err := os.RemoveAll(netDir); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		leases, err := readHostLocalLeases(netDir, network.libpodNet)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, leases...)
+		for _, lease := range leases {
+			if running[lease.ContainerID] {
+				continue
+			}
+			report.Leases = append(report.Leases, lease)
+			if dryRun {
+				continue
+			}
+			if err := os.Remove(filepath.Join(netDir, lease.IP.String())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	return all, nil
+}
+
+// readHostLocalLeases reads the lease files of the host-local plugin, the
+// file name is the ip and the first line contains the container ID.
+func readHostLocalLeases(dir string, network *types.Network) ([]types.IPAMLease, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var leases []types.IPAMLease
+	for _, entry := range entries {
+		// skip the lock and last_reserved_ip files
+		ip := net.ParseIP(entry.Name())
+		if ip == nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		id, _, _ := strings.Cut(string(content), "\n")
+		lease := types.IPAMLease{
+			Network:     network.Name,
+			IP:          ip,
+			ContainerID: strings.TrimSpace(id),
+		}
+		util.NormalizeIP(&lease.IP)
+		for _, subnet := range network.Subnets {
+			if subnet.Subnet.Contains(ip) {
+				lease.Subnet = subnet.Subnet
+				break
+			}
+		}
+		if info, err := entry.Info(); err == nil {
+			lease.AllocatedAt = info.ModTime()
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
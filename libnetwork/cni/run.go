@@ -4,9 +4,12 @@ package cni
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"net"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/containernetworking/cni/libcni"
@@ -20,7 +23,7 @@ import (
 
 // Setup will setup the container network namespace. It returns
 // a map of StatusBlocks, the key is the network name.
-func (n *cniNetwork) Setup(namespacePath string, options types.SetupOptions) (map[string]types.StatusBlock, error) {
+func (n *cniNetwork) Setup(namespacePath string, options types.SetupOptions) (_ map[string]types.StatusBlock, retErr error) {
 	n.lock.Lock()
 	defer n.lock.Unlock()
 	err := n.loadNetworks()
@@ -32,6 +35,24 @@ func (n *cniNetwork) Setup(namespacePath string, options types.SetupOptions) (ma
 	if err != nil {
 		return nil, err
 	}
+	for name, netOpts := range options.Networks {
+		if err := validateBandwidth(n.networks[name], netOpts.Bandwidth); err != nil {
+			return nil, err
+		}
+		if err := validateRouteOptions(&netOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	err = util.ReservePorts(n.portRegistry, options.ContainerID, options.PortMappings, slices.Collect(maps.Keys(options.Networks)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			util.ReleasePorts(n.portRegistry, options.ContainerID, slices.Collect(maps.Keys(options.Networks)))
+		}
+	}()
 
 	err = setupLoopback(namespacePath)
 	if err != nil {
@@ -77,8 +98,19 @@ func (n *cniNetwork) Setup(namespacePath string, options types.SetupOptions) (ma
 				}
 			}
 
+			var cniNet *libcni.NetworkConfigList
+			cniNet, retErr = withContainerVlan(network.cniNet, netOpts.Options)
+			if retErr != nil {
+				return retErr
+			}
+			noDefaultRoute := options.DefaultRouteNetwork != "" && options.DefaultRouteNetwork != name
+			cniNet, retErr = withContainerRoutes(cniNet, netOpts.Routes, noDefaultRoute)
+			if retErr != nil {
+				return retErr
+			}
+
 			var res cnitypes.Result
-			res, retErr = n.cniConf.AddNetworkList(context.Background(), network.cniNet, rt)
+			res, retErr = n.cniConf.AddNetworkList(context.Background(), cniNet, rt)
 			// Add this network to teardown opts since it is now connected.
 			// Also add this if an errors was returned since we want to call teardown on this regardless.
 			teardownOpts.Networks[name] = netOpts
@@ -210,6 +242,16 @@ func getRuntimeConfig(netns, conName, conID, networkName string, ports []cniPort
 		rt.CapabilityArgs["portMappings"] = ports
 	}
 
+	// Set the bandwidth limits for the bandwidth plugin
+	if opts.Bandwidth != nil {
+		rt.CapabilityArgs["bandwidth"] = cniBandwidthEntry{
+			IngressRate:  opts.Bandwidth.IngressRate,
+			IngressBurst: opts.Bandwidth.IngressBurst,
+			EgressRate:   opts.Bandwidth.EgressRate,
+			EgressBurst:  opts.Bandwidth.EgressBurst,
+		}
+	}
+
 	return rt
 }
 
@@ -221,6 +263,7 @@ func (n *cniNetwork) Teardown(namespacePath string, options types.TeardownOption
 	if err != nil {
 		return err
 	}
+	util.ReleasePorts(n.portRegistry, options.ContainerID, slices.Collect(maps.Keys(options.Networks)))
 	return n.teardown(namespacePath, options)
 }
 
@@ -300,3 +343,134 @@ func (n *cniNetwork) RootlessNetnsInfo() (*types.RootlessNetnsInfo, error) {
 	}
 	return n.rootlessNetns.Info(), nil
 }
+
+func (n *cniNetwork) RootlessNetnsStatus() (*types.RootlessNetnsStatus, error) {
+	if n.rootlessNetns == nil {
+		return nil, types.ErrNotRootlessNetns
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.rootlessNetns.Status()
+}
+
+func (n *cniNetwork) RootlessNetnsRecover(options types.RootlessNetnsRecoverOptions) (*types.RootlessNetnsStatus, error) {
+	if n.rootlessNetns == nil {
+		return nil, types.ErrNotRootlessNetns
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.rootlessNetns.Recover(options.Force)
+}
+
+// validateBandwidth checks that the network config contains the bandwidth
+// plugin, only bridge networks get it and those created by older versions
+// do not have it.
+func validateBandwidth(network *network, bw *types.BandwidthOptions) error {
+	if bw == nil {
+		return nil
+	}
+	if network.libpodNet.Driver != types.BridgeNetworkDriver {
+		return fmt.Errorf("bandwidth limits are not supported for driver %s: %w", network.libpodNet.Driver, types.ErrInvalidArg)
+	}
+	plugin := findPluginByName(network.cniNet.Plugins, "bandwidth")
+	if plugin == nil || !plugin.Network.Capabilities["bandwidth"] {
+		return fmt.Errorf("network %s does not support bandwidth limits, it must be recreated: %w", network.libpodNet.Name, types.ErrInvalidArg)
+	}
+	return nil
+}
+
+// withContainerVlan returns the config with the vlan and vlan_trunk container
+// options applied to the bridge plugin. The bridge plugin cannot get the
+// vlans from the runtime config so a copy of the config is changed.
+func withContainerVlan(conf *libcni.NetworkConfigList, opts map[string]string) (*libcni.NetworkConfigList, error) {
+	vlan, hasVlan := opts[types.VLANOption]
+	trunk, hasTrunk := opts[types.VLANTrunkOption]
+	if !hasVlan && !hasTrunk {
+		return conf, nil
+	}
+	changes := map[string]any{}
+	if hasVlan {
+		id, err := util.ParseVlan(vlan)
+		if err != nil {
+			return nil, err
+		}
+		changes["vlan"] = id
+	}
+	if hasTrunk {
+		ranges, err := util.ParseVlanTrunk(trunk)
+		if err != nil {
+			return nil, err
+		}
+		changes["vlanTrunk"] = newVlanTrunk(ranges)
+	}
+
+	newConf := *conf
+	newConf.Plugins = slices.Clone(conf.Plugins)
+	for i, plugin := range newConf.Plugins {
+		if plugin.Network.Type != types.BridgeNetworkDriver {
+			continue
+		}
+		var err error
+		newConf.Plugins[i], err = libcni.InjectConf(plugin, changes)
+		if err != nil {
+			return nil, err
+		}
+		return &newConf, nil
+	}
+	return nil, fmt.Errorf("network %s has no bridge plugin for the vlan options: %w", conf.Name, types.ErrInvalidArg)
+}
+
+// validateRouteOptions rejects the route options which cannot be expressed
+// with the host-local ipam plugin.
+func validateRouteOptions(opts *types.PerNetworkOptions) error {
+	if opts.DefaultRouteMetric != nil {
+		return fmt.Errorf("DefaultRouteMetric is not supported for backend CNI: %w", types.ErrInvalidArg)
+	}
+	for _, route := range opts.Routes {
+		if route.Metric != nil {
+			return fmt.Errorf("route metrics are not supported for backend CNI: %w", types.ErrInvalidArg)
+		}
+	}
+	return nil
+}
+
+// withContainerRoutes returns the config with the routes of the container
+// added to the ipam config of the first plugin. The default routes are
+// removed when another network provides the default route.
+func withContainerRoutes(conf *libcni.NetworkConfigList, routes []types.Route, noDefaultRoute bool) (*libcni.NetworkConfigList, error) {
+	if len(routes) == 0 && !noDefaultRoute {
+		return conf, nil
+	}
+	var pluginConf struct {
+		IPAM map[string]any `json:"ipam"`
+	}
+	if err := json.Unmarshal(conf.Plugins[0].Bytes, &pluginConf); err != nil {
+		return nil, err
+	}
+	ipam := pluginConf.IPAM
+	if ipam["type"] != types.HostLocalIPAMDriver {
+		return nil, fmt.Errorf("routes of network %s can only be changed with the host-local ipam driver for backend CNI: %w", conf.Name, types.ErrInvalidArg)
+	}
+
+	oldRoutes, _ := ipam["routes"].([]any)
+	newRoutes := make([]any, 0, len(oldRoutes)+len(routes))
+	for _, route := range oldRoutes {
+		if r, ok := route.(map[string]any); ok && noDefaultRoute && (r["dst"] == defaultIPv4Route || r["dst"] == defaultIPv6Route) {
+			continue
+		}
+		newRoutes = append(newRoutes, route)
+	}
+	for _, route := range routes {
+		newRoutes = append(newRoutes, ipamRoute{Dest: route.Destination.String(), GW: route.Gateway.String()})
+	}
+	ipam["routes"] = newRoutes
+
+	plugin, err := libcni.InjectConf(conf.Plugins[0], map[string]any{"ipam": ipam})
+	if err != nil {
+		return nil, err
+	}
+	newConf := *conf
+	newConf.Plugins = slices.Clone(conf.Plugins)
+	newConf.Plugins[0] = plugin
+	return &newConf, nil
+}
@@ -1367,6 +1367,81 @@ var _ = Describe("run CNI", func() {
 			})
 		})
 	})
+
+	Context("reconcile", func() {
+		It("reconcile leases and interfaces", func() {
+			runTest(func() {
+				network, err := libpodNet.NetworkInspect(types.DefaultNetworkName)
+				Expect(err).ToNot(HaveOccurred())
+
+				// host-local leases of a running and a stopped container
+				// and of a network which no longer exists
+				netDir := filepath.Join(cniVarDir, "networks", network.Name)
+				err = os.MkdirAll(netDir, 0o700)
+				Expect(err).ToNot(HaveOccurred())
+				err = os.WriteFile(filepath.Join(netDir, "10.88.0.2"), []byte("running\r\neth0"), 0o600)
+				Expect(err).ToNot(HaveOccurred())
+				err = os.WriteFile(filepath.Join(netDir, "10.88.0.3"), []byte("stopped\r\neth0"), 0o600)
+				Expect(err).ToNot(HaveOccurred())
+				err = os.WriteFile(filepath.Join(netDir, "last_reserved_ip.0"), []byte("10.88.0.3"), 0o600)
+				Expect(err).ToNot(HaveOccurred())
+				err = os.MkdirAll(filepath.Join(cniVarDir, "networks", "removed"), 0o700)
+				Expect(err).ToNot(HaveOccurred())
+
+				options := types.NetworkReconcileOptions{RunningContainers: []string{"running"}, DryRun: true}
+				report, err := libpodNet.NetworkReconcile(options)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(report.IPAMNetworks).To(Equal([]string{"removed"}))
+				Expect(report.Leases).To(HaveLen(1))
+				Expect(report.Leases[0].ContainerID).To(Equal("stopped"))
+				Expect(report.Leases[0].IP.String()).To(Equal("10.88.0.3"))
+				Expect(report.Leases[0].Subnet.String()).To(Equal("10.88.0.0/16"))
+				Expect(report.Interfaces).To(BeEmpty())
+
+				// a veth left on the bridge of the network without a running container
+				bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: network.NetworkInterface}}
+				err = netlink.LinkAdd(bridge)
+				Expect(err).ToNot(HaveOccurred())
+				veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vethorphan", MasterIndex: bridge.Index}, PeerName: "vethpeer"}
+				err = netlink.LinkAdd(veth)
+				Expect(err).ToNot(HaveOccurred())
+
+				options.RunningContainers = nil
+				options.DryRun = false
+				report, err = libpodNet.NetworkReconcile(options)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(report.Leases).To(HaveLen(2))
+				Expect(report.Interfaces).To(Equal([]types.OrphanedInterface{{Name: "vethorphan", Network: network.Name, Type: "veth"}}))
+
+				_, err = netlink.LinkByName("vethorphan")
+				Expect(err).To(HaveOccurred())
+				_, err = netlink.LinkByName(network.NetworkInterface)
+				Expect(err).ToNot(HaveOccurred())
+				entries, err := os.ReadDir(filepath.Join(cniVarDir, "networks"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+				entries, err = os.ReadDir(netDir)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+
+				// a bridge with an address which is not the gateway was
+				// not created by the plugin
+				veth = &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vethuser", MasterIndex: bridge.Index}, PeerName: "vethuserpeer"}
+				err = netlink.LinkAdd(veth)
+				Expect(err).ToNot(HaveOccurred())
+				addr, err := netlink.ParseAddr("192.168.99.1/24")
+				Expect(err).ToNot(HaveOccurred())
+				err = netlink.AddrAdd(bridge, addr)
+				Expect(err).ToNot(HaveOccurred())
+
+				report, err = libpodNet.NetworkReconcile(options)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(report.Interfaces).To(BeEmpty())
+				_, err = netlink.LinkByName("vethuser")
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
 })
 
 func runNetListener(wg *sync.WaitGroup, protocol, ip string, port int, expectedData string) {
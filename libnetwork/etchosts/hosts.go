@@ -2,6 +2,7 @@ package etchosts
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -43,6 +44,10 @@ type Params struct {
 	HostContainersInternalIP string
 	// TargetFile where the hosts are written to.
 	TargetFile string
+	// Owner marks the ContainerIPs entries with the owner, the marked
+	// entries can be changed later with a Manager.
+	// Optional.
+	Owner string
 }
 
 // New will create a new hosts file and write this to the target file.
@@ -61,7 +66,8 @@ func New(params *Params) error {
 // Add adds the given entries to the hosts file, entries are only added if
 // they are not already present.
 // Add is not atomic because it will keep the current file inode. This is
-// required to keep bind mounts for containers working.
+// required to keep bind mounts for containers working. The file is locked
+// like by a Manager and the other lines are kept as they are.
 func Add(file string, entries HostEntries) error {
 	if err := add(file, entries); err != nil {
 		return fmt.Errorf("failed to add entries to hosts file: %w", err)
@@ -77,7 +83,8 @@ func Add(file string, entries HostEntries) error {
 // were added by users manually we first have to check if there are the
 // current expected entries in the file. Note that this will only check
 // for one match not all. It will also only check that the ip and one of
-// the hostnames match like Remove().
+// the hostnames match like Remove(). The new entries get the owner of the
+// matching entry.
 func AddIfExists(file string, existsEntries, newEntries HostEntries) error {
 	if err := addIfExists(file, existsEntries, newEntries); err != nil {
 		return fmt.Errorf("failed to add entries to hosts file: %w", err)
@@ -90,7 +97,8 @@ func AddIfExists(file string, existsEntries, newEntries HostEntries) error {
 // to match. If the given entries are not present in the file no error is
 // returned.
 // Remove is not atomic because it will keep the current file inode. This is
-// required to keep bind mounts for containers working.
+// required to keep bind mounts for containers working. The file is locked
+// like by a Manager and the other lines are kept as they are.
 func Remove(file string, entries HostEntries) error {
 	if err := remove(file, entries); err != nil {
 		return fmt.Errorf("failed to remove entries from hosts file: %w", err)
@@ -100,6 +108,11 @@ func Remove(file string, entries HostEntries) error {
 
 // new see comment on New()
 func newHost(params *Params) error {
+	if params.Owner != "" {
+		if err := validateOwner(params.Owner); err != nil {
+			return err
+		}
+	}
 	entries, err := parseExtraHosts(params.ExtraHosts, params.HostContainersInternalIP)
 	if err != nil {
 		return err
@@ -111,45 +124,26 @@ func newHost(params *Params) error {
 	entries = append(entries, entries2...)
 
 	// preallocate the slice with enough space for the 3 special entries below
-	containerIPs := make(HostEntries, 0, len(params.ContainerIPs)+3)
+	specialEntries := make(HostEntries, 0, 3)
 
 	// if localhost was not added we add it
 	// https://github.com/containers/podman/issues/11411
 	lh := []string{localhost}
 	l1 := HostEntry{IP: "127.0.0.1", Names: lh}
 	l2 := HostEntry{IP: "::1", Names: lh}
-	containerIPs = append(containerIPs, l1, l2)
+	specialEntries = append(specialEntries, l1, l2)
 	if params.HostContainersInternalIP != "" {
 		e := HostEntry{IP: params.HostContainersInternalIP, Names: []string{HostContainersInternal, hostDockerInternal}}
-		containerIPs = append(containerIPs, e)
+		specialEntries = append(specialEntries, e)
 	}
-	containerIPs = append(containerIPs, params.ContainerIPs...)
-
-	return writeHostFile(params.TargetFile, entries, containerIPs)
+	return writeHostFile(params.TargetFile, entries, specialEntries, params.ContainerIPs, params.Owner)
 }
 
 // add see comment on Add()
 func add(file string, entries HostEntries) error {
-	currentEntries, err := parseHostsFile(file)
-	if err != nil {
-		return err
-	}
-
-	names := make(map[string]struct{})
-	for _, entry := range currentEntries {
-		for _, name := range entry.Names {
-			names[name] = struct{}{}
-		}
-	}
-
-	// open file in append mode since we only add, we do not have to write existing entries again
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	return addEntriesIfNotExists(f, entries, names)
+	return modifyFile(file, func(lines []hostsLine) []hostsLine {
+		return addUnowned(lines, entries)
+	})
 }
 
 // addIfExists see comment on AddIfExists()
@@ -161,57 +155,77 @@ func addIfExists(file string, existsEntries, newEntries HostEntries) error {
 		return add(file, newEntries)
 	}
 
-	currentEntries, err := parseHostsFile(file)
-	if err != nil {
-		return err
-	}
-
-	for _, entry := range currentEntries {
-		if !checkIfEntryExists(entry, existsEntries) {
-			// keep looking for existing entries
-			continue
-		}
-		// if we have a matching existing entry add the new entries
-		// open file in append mode since we only add, we do not have to write existing entries again
-		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0o644)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-
-		for _, e := range newEntries {
-			if _, err = f.WriteString(formatLine(e.IP, e.Names)); err != nil {
-				return err
+	return modifyFile(file, func(lines []hostsLine) []hostsLine {
+		for _, line := range lines {
+			if !checkIfEntryExists(line.entry, existsEntries) {
+				// keep looking for existing entries
+				continue
 			}
+			// if we have a matching existing entry add the new entries,
+			// they belong to the same owner as the matching entry
+			for _, e := range newEntries {
+				raw := strings.TrimSuffix(formatLine(e.IP, e.Names), "\n")
+				if line.owner != "" {
+					raw = formatManagedLine(e.IP, e.Names, line.owner)
+				}
+				lines = append(lines, hostsLine{raw: raw, owner: line.owner, entry: e})
+			}
+			return lines
 		}
-		return nil
-	}
-	// no match found is no error
-	return nil
+		// no match found is no error
+		return lines
+	})
 }
 
 // remove see comment on Remove()
 func remove(file string, entries HostEntries) error {
-	currentEntries, err := parseHostsFile(file)
-	if err != nil {
-		return err
-	}
+	return modifyFile(file, func(lines []hostsLine) []hostsLine {
+		return slices.DeleteFunc(lines, func(line hostsLine) bool {
+			return checkIfEntryExists(line.entry, entries)
+		})
+	})
+}
 
-	f, err := os.Create(file)
+// modifyFile locks the file and rewrites it in place with the changed
+// lines. Lines which are not changed are written exactly as they were, this
+// keeps comments and the owner markers of a Manager.
+func modifyFile(path string, change func([]hostsLine) []hostsLine) error {
+	file, err := openHostsFile(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer file.f.Close()
+	content := formatHostsLines(change(file.lines))
+	if bytes.Equal(content, file.original) {
+		return nil
+	}
+	return writeInPlace(file.f, content)
+}
 
-	for _, entry := range currentEntries {
-		if checkIfEntryExists(entry, entries) {
-			continue
+// addUnowned appends the entries without owner, only names which are not
+// already in the file are added.
+func addUnowned(lines []hostsLine, entries HostEntries) []hostsLine {
+	names := make(map[string]struct{})
+	for _, line := range lines {
+		for _, name := range line.entry.Names {
+			names[name] = struct{}{}
 		}
-		if _, err = f.WriteString(formatLine(entry.IP, entry.Names)); err != nil {
-			return err
+	}
+	for _, entry := range entries {
+		freeNames := make([]string, 0, len(entry.Names))
+		for _, name := range entry.Names {
+			if _, ok := names[name]; !ok {
+				freeNames = append(freeNames, name)
+			}
+		}
+		if len(freeNames) > 0 {
+			lines = append(lines, hostsLine{
+				raw:   strings.TrimSuffix(formatLine(entry.IP, freeNames), "\n"),
+				entry: HostEntry{IP: entry.IP, Names: freeNames},
+			})
 		}
 	}
-	return nil
+	return lines
 }
 
 func checkIfEntryExists(current HostEntry, entries HostEntries) bool {
@@ -300,8 +314,9 @@ func parseHostsFile(file string) (HostEntries, error) {
 	return entries, scanner.Err()
 }
 
-// writeHostFile write the entries to the given file
-func writeHostFile(file string, userEntries, containerIPs HostEntries) error {
+// writeHostFile write the entries to the given file, the containerIPs are
+// marked with the owner when it is set.
+func writeHostFile(file string, userEntries, specialEntries, containerIPs HostEntries, owner string) error {
 	f, err := os.Create(file)
 	if err != nil {
 		return err
@@ -318,12 +333,16 @@ func writeHostFile(file string, userEntries, containerIPs HostEntries) error {
 		}
 	}
 
-	return addEntriesIfNotExists(f, containerIPs, names)
+	if err := addEntriesIfNotExists(f, specialEntries, names, ""); err != nil {
+		return err
+	}
+	return addEntriesIfNotExists(f, containerIPs, names, owner)
 }
 
 // addEntriesIfNotExists only adds the entries for names that are not already
-// in the hosts file, otherwise we start overwriting user entries
-func addEntriesIfNotExists(f io.StringWriter, containerIPs HostEntries, names map[string]struct{}) error {
+// in the hosts file, otherwise we start overwriting user entries. When owner
+// is set the entries are marked as managed by the owner.
+func addEntriesIfNotExists(f io.StringWriter, containerIPs HostEntries, names map[string]struct{}, owner string) error {
 	for _, entry := range containerIPs {
 		freeNames := make([]string, 0, len(entry.Names))
 		for _, name := range entry.Names {
@@ -332,7 +351,11 @@ func addEntriesIfNotExists(f io.StringWriter, containerIPs HostEntries, names ma
 			}
 		}
 		if len(freeNames) > 0 {
-			if _, err := f.WriteString(formatLine(entry.IP, freeNames)); err != nil {
+			line := formatLine(entry.IP, freeNames)
+			if owner != "" {
+				line = formatManagedLine(entry.IP, freeNames, owner) + "\n"
+			}
+			if _, err := f.WriteString(line); err != nil {
 				return err
 			}
 		}
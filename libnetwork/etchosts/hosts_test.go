@@ -497,10 +497,11 @@ func TestRemove(t *testing.T) {
 		expectedTargetFileContent string
 	}{
 		{
-			name:                      "remove entry which does not exists",
-			baseFileContent:           baseFileContent1Spaces,
-			entries:                   HostEntries{{IP: "1.1.1.1", Names: []string{"name1", "name2"}}},
-			expectedTargetFileContent: targetFileContent1,
+			name:            "remove entry which does not exists",
+			baseFileContent: baseFileContent1Spaces,
+			entries:         HostEntries{{IP: "1.1.1.1", Names: []string{"name1", "name2"}}},
+			// the lines are kept as they are
+			expectedTargetFileContent: baseFileContent1Spaces,
 		},
 		{
 			name:                      "do not remove entry when only ip matches",
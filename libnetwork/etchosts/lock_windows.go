@@ -0,0 +1,10 @@
+package etchosts
+
+import (
+	"errors"
+	"os"
+)
+
+func lockFile(_ *os.File) error {
+	return errors.New("locking hosts files is not supported on windows")
+}
@@ -0,0 +1,248 @@
+package etchosts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// managedMarker is the comment added to every entry written by the
+// Manager, it is followed by the owner of the entry. Lines without the
+// marker belong to the user or the base image and are never changed.
+const managedMarker = "# managed by container "
+
+// Manager changes the entries of a container in several hosts files, e.g.
+// the files of all containers in a pod or on a shared network. Entries are
+// marked with their owner so the entries of other containers, the base
+// image and edits made by the user inside the container are preserved.
+//
+// Every operation locks all files, the change is applied to all files or to
+// none of them. The files are rewritten in place to keep the inode for the
+// bind mounts. Add, AddIfExists and Remove take the same lock and keep the
+// markers, they can be used on the same files.
+type Manager struct {
+	files []string
+}
+
+// NewManager returns a Manager for the given hosts files.
+func NewManager(files ...string) *Manager {
+	files = slices.Clone(files)
+	// always lock in the same order to avoid deadlocks
+	slices.Sort(files)
+	return &Manager{files: slices.Compact(files)}
+}
+
+// hostsLine is a line of a hosts file, the raw line is kept to write
+// unmanaged lines exactly as they were.
+type hostsLine struct {
+	raw   string
+	owner string
+	entry HostEntry
+}
+
+type hostsFile struct {
+	f        *os.File
+	original []byte
+	lines    []hostsLine
+}
+
+// Add adds the entries for the owner, entries already owned by the owner
+// are kept. Names which are used by an entry of the user or another owner
+// are not added so user entries are never shadowed.
+func (m *Manager) Add(owner string, entries HostEntries) error {
+	return m.modify(owner, func(lines []hostsLine) []hostsLine {
+		return addOwned(lines, owner, entries)
+	})
+}
+
+// Update replaces all entries of the owner with the given entries.
+func (m *Manager) Update(owner string, entries HostEntries) error {
+	return m.modify(owner, func(lines []hostsLine) []hostsLine {
+		return addOwned(removeOwned(lines, owner), owner, entries)
+	})
+}
+
+// Remove removes all entries of the owner.
+func (m *Manager) Remove(owner string) error {
+	return m.modify(owner, func(lines []hostsLine) []hostsLine {
+		return removeOwned(lines, owner)
+	})
+}
+
+// Entries returns the entries of the owner in the given hosts file.
+func Entries(file, owner string) (HostEntries, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries HostEntries
+	for _, line := range parseHostsLines(content) {
+		if line.owner == owner {
+			entries = append(entries, line.entry)
+		}
+	}
+	return entries, nil
+}
+
+func validateOwner(owner string) error {
+	if owner == "" || strings.ContainsFunc(owner, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '#'
+	}) {
+		return fmt.Errorf("invalid hosts entry owner %q", owner)
+	}
+	return nil
+}
+
+func (m *Manager) modify(owner string, change func([]hostsLine) []hostsLine) (retErr error) {
+	if err := validateOwner(owner); err != nil {
+		return err
+	}
+	files := make([]*hostsFile, 0, len(m.files))
+	defer func() {
+		for _, file := range files {
+			file.f.Close()
+		}
+	}()
+	for _, path := range m.files {
+		file, err := openHostsFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+
+	written := make([]*hostsFile, 0, len(files))
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		// restore the files which were already changed
+		for _, file := range written {
+			if err := writeInPlace(file.f, file.original); err != nil {
+				retErr = errors.Join(retErr, fmt.Errorf("restore %s: %w", file.f.Name(), err))
+			}
+		}
+	}()
+	for _, file := range files {
+		content := formatHostsLines(change(file.lines))
+		if bytes.Equal(content, file.original) {
+			continue
+		}
+		written = append(written, file)
+		if err := writeInPlace(file.f, content); err != nil {
+			return fmt.Errorf("write hosts file %s: %w", file.f.Name(), err)
+		}
+	}
+	return nil
+}
+
+// openHostsFile opens and locks the file, the lock is released on close.
+func openHostsFile(path string) (*hostsFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock hosts file %s: %w", path, err)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &hostsFile{f: f, original: content, lines: parseHostsLines(content)}, nil
+}
+
+func writeInPlace(f *os.File, content []byte) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(content, 0)
+	return err
+}
+
+func parseHostsLines(content []byte) []hostsLine {
+	var lines []hostsLine
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := hostsLine{raw: scanner.Text()}
+		data := line.raw
+		if c := strings.Index(data, managedMarker); c != -1 {
+			line.owner = strings.TrimSpace(data[c+len(managedMarker):])
+			data = data[:c]
+		}
+		if c := strings.IndexByte(data, '#'); c != -1 {
+			data = data[:c]
+		}
+		if fields := strings.Fields(data); len(fields) >= 2 {
+			line.entry = HostEntry{IP: fields[0], Names: fields[1:]}
+		} else {
+			// a managed line without names was edited by the user
+			line.owner = ""
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func formatHostsLines(lines []hostsLine) []byte {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line.raw)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func removeOwned(lines []hostsLine, owner string) []hostsLine {
+	return slices.DeleteFunc(lines, func(line hostsLine) bool {
+		return line.owner == owner
+	})
+}
+
+func addOwned(lines []hostsLine, owner string, entries HostEntries) []hostsLine {
+	// names of the owner may be added again for another ip
+	used := make(map[string]struct{})
+	existing := make(map[string]struct{})
+	for _, line := range lines {
+		for _, name := range line.entry.Names {
+			if line.owner == owner {
+				existing[line.entry.IP+" "+name] = struct{}{}
+			} else {
+				used[name] = struct{}{}
+			}
+		}
+	}
+	for _, entry := range entries {
+		names := make([]string, 0, len(entry.Names))
+		for _, name := range entry.Names {
+			_, isUsed := used[name]
+			_, exists := existing[entry.IP+" "+name]
+			if !isUsed && !exists {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		lines = append(lines, hostsLine{
+			raw:   formatManagedLine(entry.IP, names, owner),
+			owner: owner,
+			entry: HostEntry{IP: entry.IP, Names: names},
+		})
+	}
+	return lines
+}
+
+// formatManagedLine returns the hosts line with the owner marker without the
+// trailing newline.
+func formatManagedLine(ip string, names []string, owner string) string {
+	return strings.TrimSuffix(formatLine(ip, names), "\n") + " " + managedMarker + owner
+}
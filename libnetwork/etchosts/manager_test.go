@@ -0,0 +1,164 @@
+package etchosts
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestHostsFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestManager(t *testing.T) {
+	base := "# base image\n127.0.0.1\tlocalhost\n10.0.0.1 db  # added by the user\n"
+	file1 := writeTestHostsFile(t, base)
+	file2 := writeTestHostsFile(t, base)
+	m := NewManager(file1, file2, file1)
+
+	err := m.Add("ctr1", HostEntries{
+		{IP: "10.88.0.2", Names: []string{"web", "db"}},
+		{IP: "fd00::2", Names: []string{"web"}},
+	})
+	require.NoError(t, err)
+	// adding the same entries again is a no-op
+	require.NoError(t, m.Add("ctr1", HostEntries{{IP: "10.88.0.2", Names: []string{"web"}}}))
+	require.NoError(t, m.Add("ctr2", HostEntries{{IP: "10.88.0.3", Names: []string{"api", "web"}}}))
+
+	want := base +
+		"10.88.0.2\tweb # managed by container ctr1\n" +
+		"fd00::2\tweb # managed by container ctr1\n" +
+		"10.88.0.3\tapi # managed by container ctr2\n"
+	for _, file := range []string{file1, file2} {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(content))
+	}
+
+	// user edit inside the container, the marker was removed so the line
+	// belongs to the user now
+	content, err := os.ReadFile(file1)
+	require.NoError(t, err)
+	edited := string(content) + "192.168.1.5 printer\n"
+	edited = replaceLine(t, edited, "fd00::2\tweb # managed by container ctr1", "fd00::2\tweb")
+	require.NoError(t, os.WriteFile(file1, []byte(edited), 0o644))
+
+	require.NoError(t, m.Update("ctr1", HostEntries{{IP: "10.88.0.4", Names: []string{"web", "printer"}}}))
+	content, err = os.ReadFile(file1)
+	require.NoError(t, err)
+	assert.Equal(t, base+
+		"fd00::2\tweb\n"+
+		"10.88.0.3\tapi # managed by container ctr2\n"+
+		"192.168.1.5 printer\n", string(content))
+	entries, err := Entries(file2, "ctr1")
+	require.NoError(t, err)
+	assert.Equal(t, HostEntries{{IP: "10.88.0.4", Names: []string{"web", "printer"}}}, entries)
+
+	require.NoError(t, m.Remove("ctr2"))
+	require.NoError(t, m.Remove("ctr1"))
+	content, err = os.ReadFile(file2)
+	require.NoError(t, err)
+	assert.Equal(t, base, string(content))
+
+	require.Error(t, m.Remove("ctr 1"))
+	require.Error(t, NewManager(file1, filepath.Join(t.TempDir(), "missing")).Remove("ctr1"))
+}
+
+func replaceLine(t *testing.T, content, old, replacement string) string {
+	lines := parseHostsLines([]byte(content))
+	for i := range lines {
+		if lines[i].raw == old {
+			lines[i].raw = replacement
+			return string(formatHostsLines(lines))
+		}
+	}
+	t.Fatalf("line %q not found", old)
+	return ""
+}
+
+func TestManagerConcurrent(t *testing.T) {
+	file := writeTestHostsFile(t, "127.0.0.1\tlocalhost\n")
+	m := NewManager(file)
+	var wg sync.WaitGroup
+	for _, owner := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, NewManager(file).Add(owner, HostEntries{{IP: "10.0.0.1", Names: []string{owner}}}))
+		}()
+	}
+	wg.Wait()
+	for _, owner := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		entries, err := Entries(file, owner)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, owner)
+		require.NoError(t, m.Remove(owner))
+	}
+}
+
+func TestNewWithOwner(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "hosts")
+	err := New(&Params{
+		TargetFile:               target,
+		ContainerIPs:             HostEntries{{IP: "10.88.0.2", Names: []string{"web"}}},
+		HostContainersInternalIP: "10.88.0.1",
+		Owner:                    "ctr1",
+	})
+	require.NoError(t, err)
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1\tlocalhost\n::1\tlocalhost\n10.88.0.1\thost.containers.internal host.docker.internal\n"+
+		"10.88.0.2\tweb # managed by container ctr1\n", string(content))
+
+	require.NoError(t, NewManager(target).Update("ctr1", HostEntries{{IP: "10.88.0.3", Names: []string{"web"}}}))
+	entries, err := Entries(target, "ctr1")
+	require.NoError(t, err)
+	assert.Equal(t, HostEntries{{IP: "10.88.0.3", Names: []string{"web"}}}, entries)
+}
+
+func TestLegacyFunctionsKeepMarkers(t *testing.T) {
+	base := "# base image\n127.0.0.1\tlocalhost\n"
+	file := writeTestHostsFile(t, base)
+	m := NewManager(file)
+	require.NoError(t, m.Add("ctr1", HostEntries{{IP: "10.88.0.2", Names: []string{"web"}}}))
+
+	// network connect adds the ip of the new network with the same owner
+	err := AddIfExists(file, HostEntries{{IP: "10.88.0.2", Names: []string{"web"}}}, HostEntries{{IP: "10.89.0.2", Names: []string{"web"}}})
+	require.NoError(t, err)
+	require.NoError(t, Add(file, HostEntries{{IP: "10.0.0.1", Names: []string{"db"}}}))
+	require.NoError(t, Remove(file, HostEntries{{IP: "10.0.0.1", Names: []string{"db"}}}))
+
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, base+
+		"10.88.0.2\tweb # managed by container ctr1\n"+
+		"10.89.0.2\tweb # managed by container ctr1\n", string(content))
+
+	require.NoError(t, m.Remove("ctr1"))
+	content, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, base, string(content))
+}
+
+func TestAddConcurrent(t *testing.T) {
+	file := writeTestHostsFile(t, "127.0.0.1\tlocalhost\n")
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, Add(file, HostEntries{{IP: "10.0.0.1", Names: []string{name}}}))
+		}()
+	}
+	wg.Wait()
+	entries, err := parseHostsFile(file)
+	require.NoError(t, err)
+	assert.Len(t, entries, len(names)+1)
+}
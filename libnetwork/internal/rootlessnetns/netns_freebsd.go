@@ -28,6 +28,18 @@ func (n *Netns) Run(lock *lockfile.LockFile, toRun func() error) error {
 	return ErrNotSupported
 }
 
+func (n *Netns) CNIVarDir() string {
+	return ""
+}
+
 func (n *Netns) Info() *types.RootlessNetnsInfo {
 	return &types.RootlessNetnsInfo{}
 }
+
+func (n *Netns) Status() (*types.RootlessNetnsStatus, error) {
+	return nil, ErrNotSupported
+}
+
+func (n *Netns) Recover(force bool) (*types.RootlessNetnsStatus, error) {
+	return nil, ErrNotSupported
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containers/common/libnetwork/pasta"
@@ -111,27 +112,21 @@ func (n *Netns) getOrCreateNetns() (ns.NetNS, bool, error) {
 	nsPath := n.getPath(rootlessNetnsDir)
 	nsRef, err := ns.GetNS(nsPath)
 	if err == nil {
-		pidPath := n.getPath(rootlessNetNsConnPidFile)
-		pid, err := readPidFile(pidPath)
-		if err == nil {
-			// quick check if pasta/slirp4netns are still running
-			err := unix.Kill(pid, 0)
-			if err == nil {
-				if err := n.deserializeInfo(); err != nil {
-					return nil, false, wrapError("deserialize info", err)
-				}
-				// All good, return the netns.
-				return nsRef, false, nil
+		// quick check if pasta/slirp4netns are still running
+		_, running, err := n.programPid()
+		if err == nil && running {
+			if err := n.deserializeInfo(); err != nil {
+				return nil, false, wrapError("deserialize info", err)
 			}
-			// Print warnings in case things went wrong, we might be able to recover
-			// but maybe not so make sure to leave some hints so we can figure out what went wrong.
-			if errors.Is(err, unix.ESRCH) {
-				logrus.Warn("rootless netns program no longer running, trying to start it again")
-			} else {
-				logrus.Warnf("failed to check if rootless netns program is running: %v, trying to start it again", err)
-			}
-		} else {
+			// All good, return the netns.
+			return nsRef, false, nil
+		}
+		// Print warnings in case things went wrong, we might be able to recover
+		// but maybe not so make sure to leave some hints so we can figure out what went wrong.
+		if err != nil {
 			logrus.Warnf("failed to read rootless netns program pid: %v", err)
+		} else {
+			logrus.Warn("rootless netns program no longer running, trying to start it again")
 		}
 		// In case of errors continue and setup the network cmd again.
 	} else {
@@ -155,14 +150,7 @@ func (n *Netns) getOrCreateNetns() (ns.NetNS, bool, error) {
 			return nil, false, wrapError("create netns", err)
 		}
 	}
-	switch strings.ToLower(n.config.Network.DefaultRootlessNetworkCmd) {
-	case "", slirp4netns.BinaryName:
-		err = n.setupSlirp4netns(nsPath)
-	case pasta.BinaryName:
-		err = n.setupPasta(nsPath)
-	default:
-		err = fmt.Errorf("invalid rootless network command %q", n.config.Network.DefaultRootlessNetworkCmd)
-	}
+	err = n.startProgram(nsPath)
 	// If pasta or slirp4netns fail here we need to get rid of the netns again to not leak it,
 	// otherwise the next command thinks the netns was successfully setup.
 	if err != nil {
@@ -176,6 +164,50 @@ func (n *Netns) getOrCreateNetns() (ns.NetNS, bool, error) {
 	return nsRef, true, nil
 }
 
+// programPid returns the pid of pasta or slirp4netns from the pid file and
+// whether the program is still running. The pid file survives a crash of
+// the program, so the pid may have been reused by another process. It only
+// counts as running when the executable of the process is pasta or
+// slirp4netns.
+func (n *Netns) programPid() (int, bool, error) {
+	pid, err := readPidFile(n.getPath(rootlessNetNsConnPidFile))
+	if err != nil {
+		return 0, false, err
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		// the process is gone or is not ours
+		logrus.Debugf("Cannot read executable of rootless netns program pid %d: %v", pid, err)
+		return pid, false, nil
+	}
+	// pasta may be installed as pasta.avx2 and an upgraded binary has a
+	// " (deleted)" suffix
+	name := filepath.Base(exe)
+	running := strings.HasPrefix(name, pasta.BinaryName) || strings.HasPrefix(name, slirp4netns.BinaryName)
+	return pid, running, nil
+}
+
+// program returns the name of the program used for the netns.
+func (n *Netns) program() string {
+	program := strings.ToLower(n.config.Network.DefaultRootlessNetworkCmd)
+	if program == "" {
+		return slirp4netns.BinaryName
+	}
+	return program
+}
+
+// startProgram starts pasta or slirp4netns for the netns.
+func (n *Netns) startProgram(nsPath string) error {
+	switch n.program() {
+	case slirp4netns.BinaryName:
+		return n.setupSlirp4netns(nsPath)
+	case pasta.BinaryName:
+		return n.setupPasta(nsPath)
+	default:
+		return fmt.Errorf("invalid rootless network command %q", n.config.Network.DefaultRootlessNetworkCmd)
+	}
+}
+
 func (n *Netns) cleanup() error {
 	if err := fileutils.Exists(n.dir); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -530,6 +562,12 @@ func (n *Netns) setupMounts() error {
 	return nil
 }
 
+// CNIVarDir returns the directory which is mounted on /var/lib/cni in the
+// rootless netns, it contains the host-local ipam leases.
+func (n *Netns) CNIVarDir() string {
+	return n.getPath(persistentCNIDir)
+}
+
 func (n *Netns) mountCNIVarDir() error {
 	varDir := ""
 	varTarget := persistentCNIDir
@@ -662,9 +700,113 @@ func (n *Netns) Info() *types.RootlessNetnsInfo {
 	return n.info
 }
 
-func refCount(dir string, inc int) (int, error) {
-	file := filepath.Join(dir, refCountFile)
-	content, err := os.ReadFile(file)
+// Status returns diagnostics about the rootless netns. The caller must hold
+// the network lock.
+func (n *Netns) Status() (*types.RootlessNetnsStatus, error) {
+	nsPath := n.getPath(rootlessNetnsDir)
+	status := &types.RootlessNetnsStatus{
+		Path:       nsPath,
+		Program:    n.program(),
+		ResolvConf: n.getPath(resolvConfName),
+	}
+	nsRef, err := ns.GetNS(nsPath)
+	if err != nil {
+		logrus.Debugf("Rootless netns %s does not exist: %v", nsPath, err)
+		return status, nil
+	}
+	defer nsRef.Close()
+	status.Exists = true
+
+	status.RefCount, err = readRefCount(n.dir)
+	if err != nil {
+		return nil, err
+	}
+	if pid, running, err := n.programPid(); err == nil {
+		status.Pid = pid
+		status.Running = running
+	}
+	if err := n.deserializeInfo(); err != nil {
+		return nil, wrapError("deserialize info", err)
+	}
+	if n.info != nil {
+		status.IPAddresses = n.info.IPAddresses
+		status.DNSForwardIPs = n.info.DnsForwardIps
+		status.MapGuestIPs = n.info.MapGuestIps
+	}
+
+	if err := n.checkMounts(); err != nil {
+		status.MountError = err.Error()
+	}
+	return status, nil
+}
+
+// checkMounts checks that the sources of the mounts which are set up for
+// every use of the netns exist. Unlike setupMounts it does not change
+// anything.
+func (n *Netns) checkMounts() error {
+	xdgRuntimeDir, err := homedir.GetRuntimeDir()
+	if err != nil {
+		return fmt.Errorf("could not get runtime directory: %w", err)
+	}
+	sources := []string{xdgRuntimeDir, n.getPath(resolvConfName)}
+	if n.backend == CNI {
+		sources = append(sources, n.getPath(persistentCNIDir))
+	}
+	for _, source := range sources {
+		if err := fileutils.Exists(source); err != nil {
+			return fmt.Errorf("mount source: %w", err)
+		}
+	}
+	return nil
+}
+
+// Recover starts pasta or slirp4netns again when it is no longer running or
+// force is set, the netns is kept so the containers stay connected. The
+// program is started the same way as by Setup. The caller must hold the
+// network lock.
+func (n *Netns) Recover(force bool) (*types.RootlessNetnsStatus, error) {
+	status, err := n.Status()
+	if err != nil {
+		return nil, err
+	}
+	if !status.Exists {
+		return nil, wrapError("recover", errors.New("netns does not exist, it is created by the next container setup"))
+	}
+	if status.Running && !force {
+		return status, nil
+	}
+	// only kill the pid when it is still pasta or slirp4netns
+	if status.Running {
+		if err := unix.Kill(status.Pid, unix.SIGTERM); err != nil && !errors.Is(err, unix.ESRCH) {
+			return nil, wrapError("kill network process", err)
+		}
+		// the interface of the old process must be gone before the new
+		// process can configure the netns
+		if err := waitForExit(status.Pid, 5*time.Second); err != nil {
+			return nil, wrapError("kill network process", err)
+		}
+	}
+	logrus.Infof("Restarting %s for the rootless netns", status.Program)
+	nsRef, _, err := n.getOrCreateNetns()
+	if err != nil {
+		return nil, err
+	}
+	_ = nsRef.Close()
+	return n.Status()
+}
+
+// waitForExit waits until the process with the pid is gone.
+func waitForExit(pid int, timeout time.Duration) error {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(10 * time.Millisecond) {
+		if err := unix.Kill(pid, 0); errors.Is(err, unix.ESRCH) {
+			return nil
+		}
+	}
+	return fmt.Errorf("process %d did not exit within %s", pid, timeout)
+}
+
+func readRefCount(dir string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(dir, refCountFile))
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return -1, wrapError("read ref counter", err)
 	}
@@ -676,6 +818,15 @@ func refCount(dir string, inc int) (int, error) {
 			return -1, wrapError("parse ref counter", err)
 		}
 	}
+	return currentCount, nil
+}
+
+func refCount(dir string, inc int) (int, error) {
+	file := filepath.Join(dir, refCountFile)
+	currentCount, err := readRefCount(dir)
+	if err != nil {
+		return -1, err
+	}
 
 	currentCount += inc
 	if currentCount < 0 {
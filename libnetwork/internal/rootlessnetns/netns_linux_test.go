@@ -1,12 +1,15 @@
 package rootlessnetns
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
 	"testing"
 
+	"github.com/containers/common/pkg/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_refCount(t *testing.T) {
@@ -75,3 +78,36 @@ func Test_refCount(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusWithoutNetns(t *testing.T) {
+	conf := &config.Config{}
+	conf.Network.DefaultRootlessNetworkCmd = "Pasta"
+	n, err := New(t.TempDir(), Netavark, conf)
+	require.NoError(t, err)
+
+	status, err := n.Status()
+	require.NoError(t, err)
+	assert.False(t, status.Exists)
+	assert.False(t, status.Running)
+	assert.Equal(t, "pasta", status.Program)
+	assert.Equal(t, filepath.Join(n.dir, rootlessNetnsDir), status.Path)
+
+	_, err = n.Recover(true)
+	assert.ErrorContains(t, err, "netns does not exist")
+}
+
+func TestProgramPidStale(t *testing.T) {
+	n, err := New(t.TempDir(), Netavark, &config.Config{})
+	require.NoError(t, err)
+
+	_, _, err = n.programPid()
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// the pid was reused by a process which is not pasta or slirp4netns
+	pidFile := filepath.Join(n.dir, rootlessNetNsConnPidFile)
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o600))
+	pid, running, err := n.programPid()
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+	assert.False(t, running)
+}
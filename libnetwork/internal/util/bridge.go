@@ -10,7 +10,7 @@ import (
 	"github.com/containers/common/pkg/config"
 )
 
-func CreateBridge(n NetUtil, network *types.Network, usedNetworks []*net.IPNet, subnetPools []config.SubnetPool, checkBridgeConflict bool) error {
+func CreateBridge(n NetUtil, network *types.Network, usedNetworks []*net.IPNet, subnetPools, ipv6SubnetPools []config.SubnetPool, checkBridgeConflict bool) error {
 	if network.NetworkInterface != "" {
 		if checkBridgeConflict {
 			bridges := GetBridgeInterfaceNames(n)
@@ -60,7 +60,7 @@ func CreateBridge(n NetUtil, network *types.Network, usedNetworks []*net.IPNet,
 				network.Subnets = append(network.Subnets, *freeSubnet)
 			}
 			if !ipv6 {
-				freeSubnet, err := GetFreeIPv6NetworkSubnet(usedNetworks)
+				freeSubnet, err := GetFreeIPv6NetworkSubnet(usedNetworks, ipv6SubnetPools)
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,338 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/sirupsen/logrus"
+)
+
+// DefinitionsStampFile is the name of the file in the network config
+// directory which stores the checksum of the reconciled definitions.
+const DefinitionsStampFile = ".network-definitions.stamp"
+
+// NetworkDefinitionsOptions configures ReconcileNetworkDefinitions.
+type NetworkDefinitionsOptions struct {
+	// Dir contains the definitions, one network per *.json file.
+	Dir string
+	// Update applies the differences with NetworkUpdate where possible
+	// instead of only logging them.
+	Update bool
+	// StampFile stores a checksum of the definitions once they were all
+	// reconciled without error, the drift check is skipped while they do
+	// not change.
+	StampFile string
+	// ReadonlyFields lists the fields the backend cannot update, e.g.
+	// "routes", their differences are only logged.
+	ReadonlyFields []string
+}
+
+// ReconcileNetworkDefinitions reads the declarative network definitions from
+// the *.json files in the definitions directory and creates all networks which
+// do not exist yet. Differences between a definition and an existing network
+// are logged, when Update is set they are applied with NetworkUpdate where
+// possible. Invalid definitions are logged and skipped so a single broken file
+// does not make the network backend unusable.
+// This is called for every new network interface, so the drift check is
+// skipped when the definitions did not change since the last clean run.
+// Missing networks are always created, a failed create is retried and a
+// declared network removed by the user comes back.
+func ReconcileNetworkDefinitions(n types.ContainerNetwork, options *NetworkDefinitionsOptions) {
+	if options.Dir == "" {
+		return
+	}
+	entries, err := os.ReadDir(options.Dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Failed to read network definitions directory %s: %v", options.Dir, err)
+		}
+		return
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		files = append(files, filepath.Join(options.Dir, entry.Name()))
+	}
+
+	stamp, err := definitionsStamp(files, options)
+	if err != nil {
+		logrus.Warnf("Failed to read network definitions: %v", err)
+		return
+	}
+	checkDrift := true
+	if options.StampFile != "" {
+		if old, err := os.ReadFile(options.StampFile); err == nil && string(old) == stamp {
+			checkDrift = false
+		}
+	}
+
+	clean := true
+	for _, path := range files {
+		if err := reconcileNetworkDefinition(n, path, options, checkDrift); err != nil {
+			logrus.Warnf("Network definition %s: %v", path, err)
+			clean = false
+		}
+	}
+	if options.StampFile != "" && checkDrift && clean {
+		if err := ioutils.AtomicWriteFile(options.StampFile, []byte(stamp), 0o644); err != nil {
+			logrus.Warnf("Failed to write network definitions stamp: %v", err)
+		}
+	}
+}
+
+// definitionsStamp returns a checksum of the definition files and the options
+// which change the outcome of a reconcile.
+func definitionsStamp(files []string, options *NetworkDefinitionsOptions) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "update=%t\n", options.Update)
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", filepath.Base(path), len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reconcileNetworkDefinition creates the network of the definition when it
+// does not exist, the differences to an existing network are only handled
+// when checkDrift is set.
+func reconcileNetworkDefinition(n types.ContainerNetwork, path string, options *NetworkDefinitionsOptions, checkDrift bool) error {
+	def, err := readNetworkDefinition(path)
+	if err != nil {
+		return err
+	}
+
+	network, err := n.NetworkInspect(def.Name)
+	if err != nil {
+		if !errors.Is(err, types.ErrNoSuchNetwork) {
+			return err
+		}
+		if _, err := n.NetworkCreate(*def, nil); err != nil {
+			return fmt.Errorf("create network %s: %w", def.Name, err)
+		}
+		logrus.Infof("Created network %s from definition %s", def.Name, path)
+		return nil
+	}
+	if !checkDrift {
+		return nil
+	}
+
+	fields, update, fixed := diffNetworkDefinition(def, &network, options.ReadonlyFields)
+	if len(fields) == 0 {
+		return nil
+	}
+	if options.Update && len(fixed) > 0 {
+		if err := n.NetworkUpdate(network.Name, *update); err != nil {
+			return fmt.Errorf("update network %s: %w", network.Name, err)
+		}
+		logrus.Infof("Updated %s of network %s from definition %s", strings.Join(fixed, ", "), network.Name, path)
+		fields = slices.DeleteFunc(fields, func(f string) bool {
+			return slices.Contains(fixed, f)
+		})
+		if len(fields) > 0 {
+			logrus.Warnf("Network %s differs from definition %s in %s which cannot be updated, the network must be recreated",
+				network.Name, path, strings.Join(fields, ", "))
+		}
+		return nil
+	}
+	logrus.Warnf("Network %s differs from definition %s in %s", network.Name, path, strings.Join(fields, ", "))
+	return nil
+}
+
+// readNetworkDefinition parses a network definition file, the file name
+// without extension is used when the definition has no name.
+func readNetworkDefinition(path string) (*types.Network, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	def := new(types.Network)
+	if err := json.Unmarshal(data, def); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal network definition: %w", err)
+	}
+	if def.Name == "" {
+		def.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	// the ID and creation time are always generated by the backend
+	def.ID = ""
+	def.Created = time.Time{}
+	if def.Driver == "" {
+		def.Driver = types.BridgeNetworkDriver
+	}
+	return def, nil
+}
+
+// diffNetworkDefinition compares the definition with the existing network.
+// It returns the names of all fields which differ, the update options to
+// converge the network and the subset of fields the options fix. Fields
+// which are not set in the definition and filled by the backend on create,
+// like the interface name or the subnets, are only compared when set.
+// Fields listed in readonly are never part of the update options.
+func diffNetworkDefinition(def, network *types.Network, readonly []string) ([]string, *types.NetworkUpdateOptions, []string) {
+	var fields, fixed []string
+	options := &types.NetworkUpdateOptions{}
+	// drift records the field and returns true if it can be updated
+	drift := func(field string, canUpdate bool) bool {
+		fields = append(fields, field)
+		canUpdate = canUpdate && !slices.Contains(readonly, field)
+		if canUpdate {
+			fixed = append(fixed, field)
+		}
+		return canUpdate
+	}
+
+	if def.Driver != network.Driver {
+		drift("driver", false)
+	}
+	if def.NetworkInterface != "" && def.NetworkInterface != network.NetworkInterface {
+		drift("network_interface", false)
+	}
+	if def.Internal != network.Internal {
+		drift("internal", false)
+	}
+	if def.DNSEnabled != network.DNSEnabled {
+		drift("dns_enabled", false)
+	}
+	if def.IPv6Enabled && !network.IPv6Enabled {
+		drift("ipv6_enabled", false)
+	}
+	if driver := def.IPAMOptions[types.Driver]; driver != "" && driver != network.IPAMOptions[types.Driver] {
+		drift("ipam_options", false)
+	}
+
+	if len(def.Subnets) > 0 {
+		subnets := &types.NetworkUpdateOptions{}
+		subnetsFixed := diffSubnets(def.Subnets, network.Subnets, subnets)
+		if len(subnets.AddSubnets) > 0 || len(subnets.LeaseRanges) > 0 || !subnetsFixed {
+			if drift("subnets", subnetsFixed) {
+				options.AddSubnets = subnets.AddSubnets
+				options.LeaseRanges = subnets.LeaseRanges
+			}
+		}
+	}
+
+	if !slices.EqualFunc(def.Routes, network.Routes, func(a, b types.Route) bool {
+		return routeString(a) == routeString(b)
+	}) && drift("routes", true) {
+		for _, route := range network.Routes {
+			options.RemoveRoutes = append(options.RemoveRoutes, route.Destination)
+		}
+		options.AddRoutes = def.Routes
+	}
+
+	if !slices.Equal(def.NetworkDNSServers, network.NetworkDNSServers) && drift("network_dns_servers", true) {
+		// remove all servers first to keep the declared order
+		options.RemoveDNSServers = network.NetworkDNSServers
+		options.AddDNSServers = def.NetworkDNSServers
+	}
+
+	if !maps.Equal(def.Labels, network.Labels) && drift("labels", true) {
+		options.AddLabels = def.Labels
+		for key := range network.Labels {
+			if _, ok := def.Labels[key]; !ok {
+				options.RemoveLabels = append(options.RemoveLabels, key)
+			}
+		}
+	}
+
+	if !maps.Equal(def.Options, network.Options) {
+		var (
+			set       map[string]string
+			remove    []string
+			canUpdate = true
+		)
+		for key, value := range def.Options {
+			if network.Options[key] != value {
+				if set == nil {
+					set = map[string]string{}
+				}
+				set[key] = value
+				canUpdate = canUpdate && isUpdatableOption(key)
+			}
+		}
+		for key := range network.Options {
+			if _, ok := def.Options[key]; !ok {
+				remove = append(remove, key)
+				canUpdate = canUpdate && isUpdatableOption(key)
+			}
+		}
+		if drift("options", canUpdate) {
+			options.SetOptions = set
+			options.RemoveOptions = remove
+		}
+	}
+	return fields, options, fixed
+}
+
+// diffSubnets adds the declared subnets which are missing and the changed
+// lease ranges to the options. It returns false when the subnets differ in a
+// way NetworkUpdate cannot fix, i.e. a changed gateway or an extra subnet.
+func diffSubnets(declared, actual []types.Subnet, options *types.NetworkUpdateOptions) bool {
+	canUpdate := true
+	for _, subnet := range declared {
+		idx := slices.IndexFunc(actual, func(s types.Subnet) bool {
+			return s.Subnet.String() == subnet.Subnet.String()
+		})
+		if idx < 0 {
+			options.AddSubnets = append(options.AddSubnets, subnet)
+			continue
+		}
+		current := actual[idx]
+		if subnet.Gateway != nil && !subnet.Gateway.Equal(current.Gateway) {
+			canUpdate = false
+		}
+		if leaseRangeString(subnet.LeaseRange) != leaseRangeString(current.LeaseRange) {
+			if options.LeaseRanges == nil {
+				options.LeaseRanges = map[string]*types.LeaseRange{}
+			}
+			options.LeaseRanges[subnet.Subnet.String()] = subnet.LeaseRange
+		}
+	}
+	for _, subnet := range actual {
+		if !slices.ContainsFunc(declared, func(s types.Subnet) bool {
+			return s.Subnet.String() == subnet.Subnet.String()
+		}) {
+			canUpdate = false
+		}
+	}
+	return canUpdate
+}
+
+func isUpdatableOption(key string) bool {
+	switch key {
+	case types.MTUOption, types.MetricOption, types.IsolateOption:
+		return true
+	}
+	return false
+}
+
+func routeString(route types.Route) string {
+	metric := ""
+	if route.Metric != nil {
+		metric = fmt.Sprint(*route.Metric)
+	}
+	return route.Destination.String() + " " + route.Gateway.String() + " " + metric
+}
+
+func leaseRangeString(leaseRange *types.LeaseRange) string {
+	if leaseRange == nil {
+		return ""
+	}
+	return leaseRange.StartIP.String() + "-" + leaseRange.EndIP.String()
+}
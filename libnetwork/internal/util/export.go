@@ -0,0 +1,82 @@
+package util
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/containers/common/libnetwork/types"
+)
+
+// NetworksToExport returns copies of the networks with the given names or IDs
+// sorted by name. When no network is given all networks except the default
+// network are returned.
+func NetworksToExport(n NetUtil, defaultNetwork string, namesOrIDs []string) ([]types.Network, error) {
+	var networks []types.Network
+	if len(namesOrIDs) == 0 {
+		n.ForEach(func(network types.Network) {
+			if network.Name != defaultNetwork {
+				networks = append(networks, network)
+			}
+		})
+	} else {
+		for _, nameOrID := range namesOrIDs {
+			network, err := n.Network(nameOrID)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.ContainsFunc(networks, func(net types.Network) bool { return net.Name == network.Name }) {
+				networks = append(networks, *network)
+			}
+		}
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+	return networks, nil
+}
+
+// ValidateNetworkExport checks that the export document can be imported.
+func ValidateNetworkExport(export *types.NetworkExport) error {
+	if export == nil {
+		return fmt.Errorf("network export is empty: %w", types.ErrInvalidArg)
+	}
+	if export.Version < 1 || export.Version > types.NetworkExportVersion {
+		return fmt.Errorf("unsupported network export version %d: %w", export.Version, types.ErrInvalidArg)
+	}
+	names := make(map[string]struct{}, len(export.Networks))
+	for _, network := range export.Networks {
+		if network.Name == "" {
+			return fmt.Errorf("exported network without name: %w", types.ErrInvalidArg)
+		}
+		if _, ok := names[network.Name]; ok {
+			return fmt.Errorf("network %s is exported more than once: %w", network.Name, types.ErrInvalidArg)
+		}
+		names[network.Name] = struct{}{}
+	}
+	return nil
+}
+
+// NetworkFromExport returns a deep copy of the exported network which can be
+// passed to the network create function of the backend. The ID and creation
+// time are reset as they are generated by the backend.
+func NetworkFromExport(exported *types.ExportedNetwork) types.Network {
+	network := exported.Network
+	network.ID = ""
+	network.Created = time.Time{}
+	network.Subnets = slices.Clone(network.Subnets)
+	for i, s := range network.Subnets {
+		if s.LeaseRange != nil {
+			lr := *s.LeaseRange
+			network.Subnets[i].LeaseRange = &lr
+		}
+	}
+	network.Routes = slices.Clone(network.Routes)
+	network.NetworkDNSServers = slices.Clone(network.NetworkDNSServers)
+	network.Labels = maps.Clone(network.Labels)
+	network.Options = maps.Clone(network.Options)
+	network.IPAMOptions = maps.Clone(network.IPAMOptions)
+	return network
+}
@@ -3,6 +3,9 @@ package util
 import (
 	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/containers/common/libnetwork/types"
 )
 
 // ParseMTU parses the mtu option
@@ -35,6 +38,78 @@ func ParseVlan(vlan string) (int, error) {
 	return v, nil
 }
 
+// VlanRange is a range of vlan IDs, Min and Max are equal for a single ID.
+type VlanRange struct {
+	Min int
+	Max int
+}
+
+// ParseVlanTrunk parses the vlan_trunk option, a comma separated list of
+// vlan IDs and ranges, e.g. "10,20-30".
+func ParseVlanTrunk(trunk string) ([]VlanRange, error) {
+	if trunk == "" {
+		return nil, nil // default
+	}
+	var ranges []VlanRange
+	for _, part := range strings.Split(trunk, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		r := VlanRange{}
+		var err error
+		if r.Min, err = parseTrunkID(first); err != nil {
+			return nil, err
+		}
+		r.Max = r.Min
+		if isRange {
+			if r.Max, err = parseTrunkID(last); err != nil {
+				return nil, err
+			}
+			if r.Min > r.Max {
+				return nil, fmt.Errorf("invalid vlan range %q, the first ID must not be greater than the last", part)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseTrunkID(id string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(id))
+	if err != nil {
+		return 0, err
+	}
+	// vlan 0 means untagged, it cannot be allowed on a trunk
+	if v < 1 || v > 4094 {
+		return 0, fmt.Errorf("trunk vlan ID %d must be between 1 and 4094", v)
+	}
+	return v, nil
+}
+
+// FormatVlanTrunk returns the ranges in the format of the vlan_trunk option.
+func FormatVlanTrunk(ranges []VlanRange) string {
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Min == r.Max {
+			parts = append(parts, strconv.Itoa(r.Min))
+		} else {
+			parts = append(parts, strconv.Itoa(r.Min)+"-"+strconv.Itoa(r.Max))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// VlanFilteringEnabled returns true when the bridge of the network filters
+// vlans, only then containers can be put into vlans.
+func VlanFilteringEnabled(network *types.Network) bool {
+	if network.Driver != types.BridgeNetworkDriver {
+		return false
+	}
+	if enabled, _ := strconv.ParseBool(network.Options[types.VLANFilteringOption]); enabled {
+		return true
+	}
+	vlan, _ := ParseVlan(network.Options[types.VLANOption])
+	return vlan != 0 || network.Options[types.VLANTrunkOption] != ""
+}
+
 // ParseIsolate parses the isolate option
 func ParseIsolate(isolate string) (string, error) {
 	switch isolate {
@@ -212,3 +212,70 @@ func TestParseIsolate(t *testing.T) {
 		})
 	}
 }
+
+func TestParseVlanTrunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		trunk   string
+		want    []VlanRange
+		format  string
+		wantErr bool
+	}{
+		{
+			name:  "trunk default",
+			trunk: "",
+		},
+		{
+			name:   "single vlan",
+			trunk:  "10",
+			want:   []VlanRange{{Min: 10, Max: 10}},
+			format: "10",
+		},
+		{
+			name:   "vlans and ranges",
+			trunk:  "10, 20-30,4094",
+			want:   []VlanRange{{Min: 10, Max: 10}, {Min: 20, Max: 30}, {Min: 4094, Max: 4094}},
+			format: "10,20-30,4094",
+		},
+		{
+			name:    "vlan 0",
+			trunk:   "0",
+			wantErr: true,
+		},
+		{
+			name:    "vlan greater than 4094",
+			trunk:   "10-4095",
+			wantErr: true,
+		},
+		{
+			name:    "reversed range",
+			trunk:   "30-20",
+			wantErr: true,
+		},
+		{
+			name:    "empty entry",
+			trunk:   "10,,20",
+			wantErr: true,
+		},
+		{
+			name:    "vlan string",
+			trunk:   "ten",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVlanTrunk(tt.trunk)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseVlanTrunk() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseVlanTrunk() = %v, want %v", got, tt.want)
+			}
+			if format := FormatVlanTrunk(got); format != tt.format {
+				t.Errorf("FormatVlanTrunk() = %q, want %q", format, tt.format)
+			}
+		})
+	}
+}
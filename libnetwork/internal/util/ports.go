@@ -0,0 +1,43 @@
+package util
+
+import (
+	"slices"
+
+	"github.com/containers/common/libnetwork/portregistry"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ReservePorts checks the port mappings of the container for conflicts with
+// the mappings of other containers and reserves them for the networks.
+// Mappings without a host port are not reserved, the host port is not known
+// before the backend binds it. Callers which want the registry to allocate
+// the host port must call portregistry.Registry.Reserve before the setup.
+// It does nothing when registry is nil.
+func ReservePorts(registry *portregistry.Registry, containerID string, mappings []types.PortMapping, networks []string) error {
+	if registry == nil {
+		return nil
+	}
+	mappings = slices.DeleteFunc(slices.Clone(mappings), func(m types.PortMapping) bool {
+		return m.HostPort == 0
+	})
+	if len(mappings) == 0 {
+		return nil
+	}
+	_, err := registry.Reserve(containerID, mappings, &portregistry.ReserveOptions{
+		Networks: networks,
+	})
+	return err
+}
+
+// ReleasePorts releases the ports reserved by the container for the networks,
+// all ports are released when no network is given. Errors are only logged,
+// they must not stop a teardown.
+func ReleasePorts(registry *portregistry.Registry, containerID string, networks []string) {
+	if registry == nil {
+		return
+	}
+	if err := registry.ReleaseNetworks(containerID, networks); err != nil {
+		logrus.Errorf("Failed to release the ports of container %s: %v", containerID, err)
+	}
+}
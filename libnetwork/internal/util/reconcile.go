@@ -0,0 +1,80 @@
+package util
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/containers/common/libnetwork/portregistry"
+	"github.com/containers/common/libnetwork/types"
+)
+
+// RunningContainerSet returns the running containers of the options as set.
+func RunningContainerSet(options *types.NetworkReconcileOptions) map[string]bool {
+	running := make(map[string]bool, len(options.RunningContainers))
+	for _, id := range options.RunningContainers {
+		running[id] = true
+	}
+	return running
+}
+
+// ReconcilePorts returns the port reservations of the containers which are
+// not running and releases them unless dryRun is set. A container killed
+// during setup would otherwise block its host ports. It does nothing when
+// registry is nil.
+func ReconcilePorts(registry *portregistry.Registry, running map[string]bool, dryRun bool) ([]types.OrphanedPorts, error) {
+	if registry == nil {
+		return nil, nil
+	}
+	mappings, err := registry.Mappings()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(mappings))
+	for id := range mappings {
+		if !running[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var orphans []types.OrphanedPorts
+	for _, id := range ids {
+		orphans = append(orphans, types.OrphanedPorts{ContainerID: id, PortMappings: slices.Clone(mappings[id])})
+		if dryRun {
+			continue
+		}
+		if err := registry.Release(id); err != nil {
+			return orphans, err
+		}
+	}
+	return orphans, nil
+}
+
+// UnusedBridgeNetworks returns the bridge networks which have no lease of a
+// running container. The networks must all use an IPAM which stores leases,
+// otherwise the use of a network cannot be detected. Networks which share
+// their bridge with a used network and unmanaged networks, their bridge is
+// owned by the user, are not returned.
+func UnusedBridgeNetworks(networks []*types.Network, leases []types.IPAMLease, running map[string]bool) []*types.Network {
+	usedNetworks := make(map[string]bool)
+	for _, lease := range leases {
+		if !lease.Reserved && running[lease.ContainerID] {
+			usedNetworks[lease.Network] = true
+		}
+	}
+	usedBridges := make(map[string]bool)
+	for _, network := range networks {
+		if usedNetworks[network.Name] {
+			usedBridges[network.NetworkInterface] = true
+		}
+	}
+	var unused []*types.Network
+	for _, network := range networks {
+		if network.Driver != types.BridgeNetworkDriver || network.NetworkInterface == "" ||
+			network.Options[types.ModeOption] == types.BridgeModeUnmanaged ||
+			usedBridges[network.NetworkInterface] {
+			continue
+		}
+		unused = append(unused, network)
+	}
+	return unused
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// OrphanedInterfaces returns the veths attached to the bridges of the
+// networks. Bridges which were not created by the backend are skipped, a
+// bridge with other interfaces than veths or with addresses which are not
+// gateways of the network was set up by the user and the veths on it may
+// belong to something else. The bridges themselves are never returned,
+// they are removed by the teardown of the backend together with their
+// firewall rules.
+func OrphanedInterfaces(networks []*types.Network) ([]types.OrphanedInterface, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("list links: %w", err)
+	}
+	var orphans []types.OrphanedInterface
+	seen := make(map[string]bool)
+	for _, network := range networks {
+		if seen[network.NetworkInterface] {
+			continue
+		}
+		seen[network.NetworkInterface] = true
+		var bridge netlink.Link
+		for _, link := range links {
+			if link.Attrs().Name == network.NetworkInterface && link.Type() == "bridge" {
+				bridge = link
+				break
+			}
+		}
+		if bridge == nil {
+			continue
+		}
+		var veths []types.OrphanedInterface
+		foreign := false
+		for _, link := range links {
+			if link.Attrs().MasterIndex != bridge.Attrs().Index {
+				continue
+			}
+			if link.Type() != "veth" {
+				foreign = true
+				break
+			}
+			veths = append(veths, types.OrphanedInterface{Name: link.Attrs().Name, Network: network.Name, Type: "veth"})
+		}
+		if !foreign {
+			foreign, err = hasForeignAddress(bridge, network)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if foreign {
+			continue
+		}
+		orphans = append(orphans, veths...)
+	}
+	return orphans, nil
+}
+
+// hasForeignAddress returns true when the bridge has a global address
+// which is not a gateway of the network.
+func hasForeignAddress(bridge netlink.Link, network *types.Network) (bool, error) {
+	addrs, err := netlink.AddrList(bridge, netlink.FAMILY_ALL)
+	if err != nil {
+		return false, fmt.Errorf("list addresses of interface %s: %w", bridge.Attrs().Name, err)
+	}
+	for _, addr := range addrs {
+		if !addr.IP.IsGlobalUnicast() {
+			continue
+		}
+		gateway := false
+		for _, subnet := range network.Subnets {
+			if subnet.Gateway.Equal(addr.IP) {
+				gateway = true
+				break
+			}
+		}
+		if !gateway {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RemoveInterfaces removes the interfaces, interfaces which no longer exist
+// are ignored.
+func RemoveInterfaces(ifaces []types.OrphanedInterface) error {
+	var errs []error
+	for _, iface := range ifaces {
+		link, err := netlink.LinkByName(iface.Name)
+		if err != nil {
+			var notFound netlink.LinkNotFoundError
+			if !errors.As(err, &notFound) {
+				errs = append(errs, fmt.Errorf("get interface %s: %w", iface.Name, err))
+			}
+			continue
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			errs = append(errs, fmt.Errorf("remove interface %s: %w", iface.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
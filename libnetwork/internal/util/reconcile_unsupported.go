@@ -0,0 +1,23 @@
+//go:build !linux
+
+package util
+
+import (
+	"fmt"
+
+	"github.com/containers/common/libnetwork/types"
+)
+
+// OrphanedInterfaces is only supported on linux, the interfaces attached to
+// a bridge cannot be listed on other platforms.
+func OrphanedInterfaces(networks []*types.Network) ([]types.OrphanedInterface, error) {
+	return nil, nil
+}
+
+// RemoveInterfaces is only supported on linux.
+func RemoveInterfaces(ifaces []types.OrphanedInterface) error {
+	if len(ifaces) == 0 {
+		return nil
+	}
+	return fmt.Errorf("removing network interfaces is not supported on this platform: %w", types.ErrInvalidArg)
+}
@@ -0,0 +1,200 @@
+package util
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/libnetwork/util"
+)
+
+// UpdateNetwork returns a copy of the network with the label, option, route,
+// lease range and subnet changes from the options applied. The DNS servers
+// are not handled here as the backends treat them differently.
+// allocatedIPs contains all ips which are currently leased to containers on
+// this network, the updated network must keep them valid.
+func UpdateNetwork(n NetUtil, network *types.Network, options *types.NetworkUpdateOptions, allocatedIPs []net.IP) (*types.Network, error) {
+	newNetwork := *network
+	newNetwork.Labels = maps.Clone(network.Labels)
+	if newNetwork.Labels == nil && len(options.AddLabels) > 0 {
+		newNetwork.Labels = map[string]string{}
+	}
+	newNetwork.Options = maps.Clone(network.Options)
+	if newNetwork.Options == nil && len(options.SetOptions) > 0 {
+		newNetwork.Options = map[string]string{}
+	}
+	newNetwork.Subnets = slices.Clone(network.Subnets)
+	for i, s := range newNetwork.Subnets {
+		if s.LeaseRange != nil {
+			lr := *s.LeaseRange
+			newNetwork.Subnets[i].LeaseRange = &lr
+		}
+	}
+
+	for _, key := range options.RemoveLabels {
+		delete(newNetwork.Labels, key)
+	}
+	maps.Copy(newNetwork.Labels, options.AddLabels)
+
+	if err := updateNetworkOptions(&newNetwork, options); err != nil {
+		return nil, err
+	}
+
+	var routes []types.Route
+	for _, route := range network.Routes {
+		if !slices.ContainsFunc(options.RemoveRoutes, func(dest types.IPNet) bool {
+			return dest.String() == route.Destination.String()
+		}) {
+			routes = append(routes, route)
+		}
+	}
+	routes = append(routes, options.AddRoutes...)
+	if err := ValidateRoutes(routes); err != nil {
+		return nil, err
+	}
+	newNetwork.Routes = routes
+
+	if err := updateLeaseRanges(&newNetwork, options.LeaseRanges, allocatedIPs); err != nil {
+		return nil, err
+	}
+
+	if len(options.AddSubnets) > 0 {
+		if err := addSubnets(n, &newNetwork, options.AddSubnets); err != nil {
+			return nil, err
+		}
+	}
+	return &newNetwork, nil
+}
+
+func updateNetworkOptions(network *types.Network, options *types.NetworkUpdateOptions) error {
+	checkKey := func(key string) error {
+		switch key {
+		case types.MTUOption, types.MetricOption:
+		case types.IsolateOption:
+			if network.Driver != types.BridgeNetworkDriver {
+				return fmt.Errorf("isolate option is only supported with the bridge driver: %w", types.ErrInvalidArg)
+			}
+		default:
+			return fmt.Errorf("network option %s cannot be updated: %w", key, types.ErrInvalidArg)
+		}
+		return nil
+	}
+
+	for _, key := range options.RemoveOptions {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+		delete(network.Options, key)
+	}
+	for key, value := range options.SetOptions {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+		switch key {
+		case types.MTUOption:
+			if _, err := ParseMTU(value); err != nil {
+				return err
+			}
+		case types.MetricOption:
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return err
+			}
+		case types.IsolateOption:
+			val, err := ParseIsolate(value)
+			if err != nil {
+				return err
+			}
+			value = val
+		}
+		network.Options[key] = value
+	}
+	return nil
+}
+
+func updateLeaseRanges(network *types.Network, leaseRanges map[string]*types.LeaseRange, allocatedIPs []net.IP) error {
+	for subnet, leaseRange := range leaseRanges {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return fmt.Errorf("invalid lease range subnet: %w", err)
+		}
+		idx := slices.IndexFunc(network.Subnets, func(s types.Subnet) bool {
+			return s.Subnet.String() == ipNet.String()
+		})
+		if idx < 0 {
+			return fmt.Errorf("subnet %s is not part of network %s: %w", ipNet, network.Name, types.ErrInvalidArg)
+		}
+		s := &network.Subnets[idx]
+		s.LeaseRange = nil
+		if leaseRange != nil {
+			lr := *leaseRange
+			s.LeaseRange = &lr
+		}
+		if err := ValidateSubnet(s, false, nil); err != nil {
+			return err
+		}
+		for _, ip := range allocatedIPs {
+			if s.Subnet.Contains(ip) && !inLeaseRange(ip, s.LeaseRange) {
+				return fmt.Errorf("ip %s is allocated to a container and not part of the new lease range for subnet %s: %w", ip, &s.Subnet, types.ErrInvalidArg)
+			}
+		}
+	}
+	return nil
+}
+
+// inLeaseRange returns true when the ip is within the lease range, a nil
+// range or unset start and end ip mean the range is unbounded.
+func inLeaseRange(ip net.IP, leaseRange *types.LeaseRange) bool {
+	if leaseRange == nil {
+		return true
+	}
+	if leaseRange.StartIP != nil && util.Cmp(ip, leaseRange.StartIP) < 0 {
+		return false
+	}
+	if leaseRange.EndIP != nil && util.Cmp(ip, leaseRange.EndIP) > 0 {
+		return false
+	}
+	return true
+}
+
+func addSubnets(n NetUtil, network *types.Network, subnets []types.Subnet) error {
+	switch network.IPAMOptions[types.Driver] {
+	case "", types.HostLocalIPAMDriver:
+	default:
+		return fmt.Errorf("subnets cannot be added with ipam driver %q: %w", network.IPAMOptions[types.Driver], types.ErrInvalidArg)
+	}
+
+	var usedNetworks []*net.IPNet
+	if network.Driver == types.BridgeNetworkDriver {
+		var err error
+		usedNetworks, err = GetUsedSubnets(n)
+		if err != nil {
+			return err
+		}
+	} else {
+		// other drivers may share subnets with the host but never
+		// with another subnet of the same network
+		for i := range network.Subnets {
+			usedNetworks = append(usedNetworks, &network.Subnets[i].Subnet.IPNet)
+		}
+	}
+
+	addGateway := !network.Internal || network.DNSEnabled
+	for _, subnet := range subnets {
+		if subnet.LeaseRange != nil {
+			lr := *subnet.LeaseRange
+			subnet.LeaseRange = &lr
+		}
+		if err := ValidateSubnet(&subnet, addGateway, usedNetworks); err != nil {
+			return err
+		}
+		if util.IsIPv6(subnet.Subnet.IP) {
+			network.IPv6Enabled = true
+		}
+		network.Subnets = append(network.Subnets, subnet)
+		usedNetworks = append(usedNetworks, &network.Subnets[len(network.Subnets)-1].Subnet.IPNet)
+	}
+	return nil
+}
@@ -79,18 +79,51 @@ func GetUsedSubnets(n NetUtil) ([]*net.IPNet, error) {
 
 // GetFreeIPv4NetworkSubnet returns a unused ipv4 subnet
 func GetFreeIPv4NetworkSubnet(usedNetworks []*net.IPNet, subnetPools []config.SubnetPool) (*types.Subnet, error) {
+	return getFreeSubnetFromPools(usedNetworks, subnetPools, net.IPv4len)
+}
+
+// GetFreeIPv6NetworkSubnet returns a unused ipv6 subnet from the subnet
+// pools, if no pools are given a random subnet is used.
+func GetFreeIPv6NetworkSubnet(usedNetworks []*net.IPNet, subnetPools []config.SubnetPool) (*types.Subnet, error) {
+	if len(subnetPools) > 0 {
+		return getFreeSubnetFromPools(usedNetworks, subnetPools, net.IPv6len)
+	}
+	// FIXME: Is 10000 fine as limit? We should prevent an endless loop.
+	for range 10000 {
+		// RFC4193: Choose the ipv6 subnet random and NOT sequentially.
+		network, err := getRandomIPv6Subnet()
+		if err != nil {
+			return nil, err
+		}
+		if intersectsConfig := NetworkIntersectsWithNetworks(&network, usedNetworks); !intersectsConfig {
+			logrus.Debugf("found free ipv6 network subnet %s", network.String())
+			return &types.Subnet{
+				Subnet: types.IPNet{IPNet: network},
+			}, nil
+		}
+	}
+	return nil, errors.New("failed to get random ipv6 subnet")
+}
+
+// getFreeSubnetFromPools returns the first subnet of the pools which does
+// not intersect with the used networks, ipLen is the byte length of the ips.
+func getFreeSubnetFromPools(usedNetworks []*net.IPNet, subnetPools []config.SubnetPool, ipLen int) (*types.Subnet, error) {
 	var err error
 	for _, pool := range subnetPools {
 		// make sure to copy the netip to prevent overwriting the subnet pool
-		netIP := make(net.IP, net.IPv4len)
-		copy(netIP, pool.Base.IP)
+		netIP := make(net.IP, ipLen)
+		if ipLen == net.IPv4len {
+			copy(netIP, pool.Base.IP.To4())
+		} else {
+			copy(netIP, pool.Base.IP.To16())
+		}
 		network := &net.IPNet{
 			IP:   netIP,
-			Mask: net.CIDRMask(pool.Size, 32),
+			Mask: net.CIDRMask(pool.Size, ipLen*8),
 		}
 		for pool.Base.Contains(network.IP) {
 			if !NetworkIntersectsWithNetworks(network, usedNetworks) {
-				logrus.Debugf("found free ipv4 network subnet %s", network.String())
+				logrus.Debugf("found free network subnet %s", network.String())
 				return &types.Subnet{
 					Subnet: types.IPNet{IPNet: *network},
 				}, nil
@@ -109,25 +142,6 @@ func GetFreeIPv4NetworkSubnet(usedNetworks []*net.IPNet, subnetPools []config.Su
 	return nil, errors.New("could not find free subnet from subnet pools")
 }
 
-// GetFreeIPv6NetworkSubnet returns a unused ipv6 subnet
-func GetFreeIPv6NetworkSubnet(usedNetworks []*net.IPNet) (*types.Subnet, error) {
-	// FIXME: Is 10000 fine as limit? We should prevent an endless loop.
-	for range 10000 {
-		// RFC4193: Choose the ipv6 subnet random and NOT sequentially.
-		network, err := getRandomIPv6Subnet()
-		if err != nil {
-			return nil, err
-		}
-		if intersectsConfig := NetworkIntersectsWithNetworks(&network, usedNetworks); !intersectsConfig {
-			logrus.Debugf("found free ipv6 network subnet %s", network.String())
-			return &types.Subnet{
-				Subnet: types.IPNet{IPNet: network},
-			}, nil
-		}
-	}
-	return nil, errors.New("failed to get random ipv6 subnet")
-}
-
 // Map docker driver network options to podman network options
 func MapDockerBridgeDriverOptions(n *types.Network) {
 	// validate the given options
@@ -113,3 +113,95 @@ func TestGetFreeIPv4NetworkSubnet(t *testing.T) {
 		})
 	}
 }
+
+func TestGetFreeIPv6NetworkSubnet(t *testing.T) {
+	pools := []config.SubnetPool{
+		{Base: parseIPNet("2001:db8:1::/48"), Size: 64},
+		{Base: parseIPNet("2001:db8:2::/63"), Size: 64},
+	}
+	tests := []struct {
+		name         string
+		usedNetworks []*net.IPNet
+		want         string
+		wantErr      bool
+	}{
+		{
+			name: "first subnet",
+			want: "2001:db8:1::/64",
+		},
+		{
+			name: "skip used subnets",
+			usedNetworks: []*net.IPNet{
+				parseCIDR("2001:db8:1::/64"),
+				// address of a live interface
+				parseCIDR("2001:db8:1:1::1/128"),
+			},
+			want: "2001:db8:1:2::/64",
+		},
+		{
+			name: "next pool",
+			usedNetworks: []*net.IPNet{
+				parseCIDR("2001:db8:1::/48"),
+				parseCIDR("2001:db8:2::/64"),
+			},
+			want: "2001:db8:2:1::/64",
+		},
+		{
+			name: "no free subnet",
+			usedNetworks: []*net.IPNet{
+				parseCIDR("2001:db8::/32"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetFreeIPv6NetworkSubnet(tt.usedNetworks, pools)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetFreeIPv6NetworkSubnet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Subnet.String() != tt.want {
+				t.Errorf("GetFreeIPv6NetworkSubnet() = %v, want %v", got.Subnet.String(), tt.want)
+			}
+		})
+	}
+
+	// without pools a random ula subnet is used
+	got, err := GetFreeIPv6NetworkSubnet(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subnet.IP[0] != 0xfd {
+		t.Errorf("GetFreeIPv6NetworkSubnet() = %v, want unique local subnet", got.Subnet.String())
+	}
+}
+
+func TestUnusedBridgeNetworks(t *testing.T) {
+	networks := []*types.Network{
+		{Name: "used", Driver: types.BridgeNetworkDriver, NetworkInterface: "podman1"},
+		{Name: "unused", Driver: types.BridgeNetworkDriver, NetworkInterface: "podman2"},
+		{Name: "reserved", Driver: types.BridgeNetworkDriver, NetworkInterface: "podman3"},
+		{Name: "shared", Driver: types.BridgeNetworkDriver, NetworkInterface: "podman1"},
+		{Name: "macvlan", Driver: types.MacVLANNetworkDriver, NetworkInterface: "eth0"},
+		{
+			Name: "unmanaged", Driver: types.BridgeNetworkDriver, NetworkInterface: "br0",
+			Options: map[string]string{types.ModeOption: types.BridgeModeUnmanaged},
+		},
+	}
+	leases := []types.IPAMLease{
+		{Network: "used", ContainerID: "running"},
+		{Network: "unused", ContainerID: "stopped"},
+		{Network: "reserved", Reserved: true},
+	}
+	running := RunningContainerSet(&types.NetworkReconcileOptions{RunningContainers: []string{"running"}})
+
+	unused := UnusedBridgeNetworks(networks, leases, running)
+	names := make([]string, 0, len(unused))
+	for _, network := range unused {
+		names = append(names, network.Name)
+	}
+	if want := []string{"unused", "reserved"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("UnusedBridgeNetworks() = %v, want %v", names, want)
+	}
+}
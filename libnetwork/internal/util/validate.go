@@ -130,6 +130,18 @@ func ValidateSetupOptions(n NetUtil, namespacePath string, options types.SetupOp
 	if len(options.Networks) == 0 {
 		return errors.New("must specify at least one network")
 	}
+	if options.DefaultRouteNetwork != "" {
+		if _, ok := options.Networks[options.DefaultRouteNetwork]; !ok {
+			return fmt.Errorf("default route network %s is not connected: %w", options.DefaultRouteNetwork, types.ErrInvalidArg)
+		}
+		network, err := n.Network(options.DefaultRouteNetwork)
+		if err != nil {
+			return err
+		}
+		if network.Internal {
+			return fmt.Errorf("internal network %s cannot provide the default route: %w", network.Name, types.ErrInvalidArg)
+		}
+	}
 	for name, netOpts := range options.Networks {
 		network, err := n.Network(name)
 		if err != nil {
@@ -159,6 +171,44 @@ func validatePerNetworkOpts(network *types.Network, netOpts *types.PerNetworkOpt
 			return fmt.Errorf("requested static ip %s not in any subnet on network %s", ip.String(), network.Name)
 		}
 	}
+	if netOpts.Bandwidth != nil {
+		if err := validateBandwidth(netOpts.Bandwidth); err != nil {
+			return fmt.Errorf("invalid bandwidth options on network %s: %w", network.Name, err)
+		}
+	}
+	if err := ValidateRoutes(netOpts.Routes); err != nil {
+		return fmt.Errorf("invalid route on network %s: %w", network.Name, err)
+	}
+	return validateVlanOpts(network, netOpts.Options)
+}
+
+// validateVlanOpts checks the vlan and vlan_trunk container options, they
+// set the vlans of the bridge port of the container.
+func validateVlanOpts(network *types.Network, opts map[string]string) error {
+	vlan, hasVlan := opts[types.VLANOption]
+	trunk, hasTrunk := opts[types.VLANTrunkOption]
+	if !hasVlan && !hasTrunk {
+		return nil
+	}
+	if !VlanFilteringEnabled(network) {
+		return fmt.Errorf("vlan options require a bridge network with vlan filtering, network %s does not filter vlans: %w", network.Name, types.ErrInvalidArg)
+	}
+	if _, err := ParseVlan(vlan); err != nil {
+		return fmt.Errorf("invalid vlan option on network %s: %w", network.Name, err)
+	}
+	if _, err := ParseVlanTrunk(trunk); err != nil {
+		return fmt.Errorf("invalid vlan_trunk option on network %s: %w", network.Name, err)
+	}
+	return nil
+}
+
+func validateBandwidth(bw *types.BandwidthOptions) error {
+	if (bw.IngressRate == 0) != (bw.IngressBurst == 0) {
+		return fmt.Errorf("ingress rate and burst must be set together: %w", types.ErrInvalidArg)
+	}
+	if (bw.EgressRate == 0) != (bw.EgressBurst == 0) {
+		return fmt.Errorf("egress rate and burst must be set together: %w", types.ErrInvalidArg)
+	}
 	return nil
 }
 
@@ -48,6 +48,9 @@ func (n *netavarkNetwork) commitNetwork(network *types.Network) error {
 	return nil
 }
 
+// NetworkUpdate updates the DNS servers, labels, options, routes, lease
+// ranges and subnets of the network. Only the DNS servers are applied to
+// running containers, the other changes are used for new connections.
 func (n *netavarkNetwork) NetworkUpdate(name string, options types.NetworkUpdateOptions) error {
 	n.lock.Lock()
 	defer n.lock.Unlock()
@@ -70,6 +73,20 @@ func (n *netavarkNetwork) NetworkUpdate(name string, options types.NetworkUpdate
 			return fmt.Errorf("unable to parse ip %s specified in RemoveDNSServer: %w", dnsServer, types.ErrInvalidArg)
 		}
 	}
+
+	// the lease ranges must be validated against the ips in use
+	var allocatedIPs []net.IP
+	if len(options.LeaseRanges) > 0 {
+		allocatedIPs, err = n.getAllocatedIPs(network)
+		if err != nil {
+			return err
+		}
+	}
+	newNetwork, err := internalutil.UpdateNetwork(n, network, &options, allocatedIPs)
+	if err != nil {
+		return err
+	}
+
 	networkDNSServersBefore := network.NetworkDNSServers
 	networkDNSServersAfter := []string{}
 	for _, server := range networkDNSServersBefore {
@@ -80,16 +97,33 @@ func (n *netavarkNetwork) NetworkUpdate(name string, options types.NetworkUpdate
 	}
 	networkDNSServersAfter = append(networkDNSServersAfter, options.AddDNSServers...)
 	networkDNSServersAfter = sliceRemoveDuplicates(networkDNSServersAfter)
-	network.NetworkDNSServers = networkDNSServersAfter
-	if reflect.DeepEqual(networkDNSServersBefore, networkDNSServersAfter) {
+	dnsChanged := !slices.Equal(networkDNSServersBefore, networkDNSServersAfter)
+	if dnsChanged {
+		newNetwork.NetworkDNSServers = networkDNSServersAfter
+	}
+	if reflect.DeepEqual(network, newNetwork) {
 		return nil
 	}
-	err = n.commitNetwork(network)
+	err = n.commitNetwork(newNetwork)
 	if err != nil {
 		return err
 	}
+	n.networks[newNetwork.Name] = newNetwork
 
-	return n.execUpdate(network.Name, network.NetworkDNSServers)
+	if len(options.LeaseRanges) > 0 {
+		subnets := make([]string, 0, len(newNetwork.Subnets))
+		for _, s := range newNetwork.Subnets {
+			subnets = append(subnets, s.Subnet.String())
+		}
+		if err := n.resetLastIP(newNetwork, subnets); err != nil {
+			return err
+		}
+	}
+
+	if !dnsChanged {
+		return nil
+	}
+	return n.execUpdate(newNetwork.Name, newNetwork.NetworkDNSServers)
 }
 
 // NetworkCreate will take a partial filled Network and fill the
@@ -195,6 +229,21 @@ func (n *netavarkNetwork) networkCreate(newNetwork *types.Network, defaultNet bo
 				// https://github.com/containers/common/issues/2095
 				checkBridgeConflict = false
 
+			case types.VLANTrunkOption:
+				ranges, err := internalutil.ParseVlanTrunk(value)
+				if err != nil {
+					return nil, err
+				}
+				newNetwork.Options[types.VLANTrunkOption] = internalutil.FormatVlanTrunk(ranges)
+
+			case types.VLANFilteringOption:
+				val, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, err
+				}
+				// rust only support "true" or "false" while go can parse 1 and 0 as well so we need to change it
+				newNetwork.Options[types.VLANFilteringOption] = strconv.FormatBool(val)
+
 			case types.IsolateOption:
 				val, err := internalutil.ParseIsolate(value)
 				if err != nil {
@@ -235,7 +284,7 @@ func (n *netavarkNetwork) networkCreate(newNetwork *types.Network, defaultNet bo
 			}
 		}
 
-		err = internalutil.CreateBridge(n, newNetwork, usedNetworks, n.defaultsubnetPools, checkBridgeConflict)
+		err = internalutil.CreateBridge(n, newNetwork, usedNetworks, n.defaultsubnetPools, n.defaultIPv6SubnetPools, checkBridgeConflict)
 		if err != nil {
 			return nil, err
 		}
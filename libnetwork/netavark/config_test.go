@@ -4,6 +4,7 @@ package netavark_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"net"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/containers/common/libnetwork/netavark"
+	"github.com/containers/common/libnetwork/portregistry"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/libnetwork/util"
 	"github.com/containers/common/pkg/config"
@@ -705,6 +707,306 @@ var _ = Describe("Config", func() {
 			Expect(network1.Internal).To(BeTrue())
 		})
 
+		It("reconcile network definitions", func() {
+			defDir := GinkgoT().TempDir()
+			writeDefinition := func(name, content string) {
+				err := os.WriteFile(filepath.Join(defDir, name), []byte(content), 0o644)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			newInterface := func(update bool) types.ContainerNetwork {
+				libpodNet, err := netavark.NewNetworkInterface(&netavark.InitConfig{
+					Config: &config.Config{Network: config.NetworkConfig{
+						NetworkDefinitionsDir:    defDir,
+						NetworkDefinitionsUpdate: update,
+					}},
+					NetworkConfigDir: networkConfDir,
+					NetworkRunDir:    networkConfDir,
+					NetavarkBinary:   "true",
+				})
+				Expect(err).ToNot(HaveOccurred())
+				return libpodNet
+			}
+
+			writeDefinition("web.json", `{"subnets": [{"subnet": "10.99.0.0/24"}], "labels": {"app": "web"}, "options": {"mtu": "1400"}}`)
+			writeDefinition("broken.json", `{"name": `)
+			writeDefinition("ignored.txt", `{"name": "ignored"}`)
+			libpodNet := newInterface(false)
+			Expect(logBuffer.String()).To(ContainSubstring("broken.json"))
+
+			// the stamp is only written once all definitions are valid
+			logBuffer.Reset()
+			libpodNet = newInterface(false)
+			Expect(logBuffer.String()).To(ContainSubstring("broken.json"))
+			_, err := os.Stat(filepath.Join(networkConfDir, ".network-definitions.stamp"))
+			Expect(err).To(HaveOccurred())
+			err = os.Remove(filepath.Join(defDir, "broken.json"))
+			Expect(err).ToNot(HaveOccurred())
+			libpodNet = newInterface(false)
+			_, err = os.Stat(filepath.Join(networkConfDir, ".network-definitions.stamp"))
+			Expect(err).ToNot(HaveOccurred())
+
+			// unchanged definitions still recreate removed networks
+			err = libpodNet.NetworkRemove("web")
+			Expect(err).ToNot(HaveOccurred())
+			libpodNet = newInterface(false)
+
+			networks, err := libpodNet.NetworkList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(HaveLen(2))
+			network, err := libpodNet.NetworkInspect("web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network.Subnets).To(HaveLen(1))
+			Expect(network.Subnets[0].Subnet.String()).To(Equal("10.99.0.0/24"))
+			Expect(network.Labels).To(Equal(map[string]string{"app": "web"}))
+			Expect(network.Options).To(Equal(map[string]string{types.MTUOption: "1400"}))
+
+			// drift is only logged without update
+			writeDefinition("web.json", `{"subnets": [{"subnet": "10.99.0.0/24"}, {"subnet": "fd99::/64"}], "labels": {"tier": "front"}, "options": {"mtu": "1500"}, "internal": true}`)
+			logBuffer.Reset()
+			libpodNet = newInterface(false)
+			Expect(logBuffer.String()).To(ContainSubstring("Network web differs from definition"))
+			Expect(logBuffer.String()).To(ContainSubstring("internal, subnets, labels, options"))
+			network2, err := libpodNet.NetworkInspect("web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2).To(Equal(network))
+
+			logBuffer.Reset()
+			libpodNet = newInterface(true)
+			Expect(logBuffer.String()).To(ContainSubstring("in internal which cannot be updated"))
+			network2, err = libpodNet.NetworkInspect("web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2.ID).To(Equal(network.ID))
+			Expect(network2.Internal).To(BeFalse())
+			Expect(network2.IPv6Enabled).To(BeTrue())
+			Expect(network2.Subnets).To(HaveLen(2))
+			Expect(network2.Subnets[1].Subnet.String()).To(Equal("fd99::/64"))
+			Expect(network2.Labels).To(Equal(map[string]string{"tier": "front"}))
+			Expect(network2.Options).To(Equal(map[string]string{types.MTUOption: "1500"}))
+		})
+
+		It("export and import networks", func() {
+			network1, err := libpodNet.NetworkCreate(types.Network{
+				Name:    "net1",
+				Subnets: []types.Subnet{{Subnet: mustParseCIDR("10.99.0.0/24")}},
+				Labels:  map[string]string{"app": "web"},
+			}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = libpodNet.NetworkCreate(types.Network{Name: "net2", Internal: true}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			err = libpodNet.IPAMReserve("net1", types.LeaseRange{StartIP: net.ParseIP("10.99.0.10"), EndIP: net.ParseIP("10.99.0.12")})
+			Expect(err).ToNot(HaveOccurred())
+			err = libpodNet.IPAMReserve("net1", types.LeaseRange{StartIP: net.ParseIP("10.99.0.20")})
+			Expect(err).ToNot(HaveOccurred())
+
+			export, err := libpodNet.NetworkExport()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(export.Version).To(Equal(types.NetworkExportVersion))
+			Expect(export.Backend).To(Equal(types.Netavark))
+			Expect(export.Networks).To(HaveLen(2))
+			Expect(export.Networks[0].Name).To(Equal("net1"))
+			Expect(export.Networks[0].Reservations).To(HaveLen(2))
+			Expect(export.Networks[0].Reservations[0].StartIP.String()).To(Equal("10.99.0.10"))
+			Expect(export.Networks[0].Reservations[0].EndIP.String()).To(Equal("10.99.0.12"))
+			Expect(export.Networks[0].Reservations[1].StartIP.String()).To(Equal("10.99.0.20"))
+			Expect(export.Networks[1].Name).To(Equal("net2"))
+			Expect(export.Networks[1].Reservations).To(BeEmpty())
+
+			single, err := libpodNet.NetworkExport("net2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(single.Networks).To(HaveLen(1))
+
+			// the document must survive the round trip to another host
+			data, err := json.Marshal(export)
+			Expect(err).ToNot(HaveOccurred())
+			export = &types.NetworkExport{}
+			err = json.Unmarshal(data, export)
+			Expect(err).ToNot(HaveOccurred())
+
+			otherNet, err := getNetworkInterface(GinkgoT().TempDir())
+			Expect(err).ToNot(HaveOccurred())
+			networks, err := otherNet.NetworkImport(export, types.NetworkImportOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(HaveLen(2))
+			imported, err := otherNet.NetworkInspect("net1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(imported.Subnets).To(Equal(network1.Subnets))
+			Expect(imported.NetworkInterface).To(Equal(network1.NetworkInterface))
+			Expect(imported.Labels).To(Equal(network1.Labels))
+			imported, err = otherNet.NetworkInspect("net2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(imported.Internal).To(BeTrue())
+			leases, err := otherNet.IPAMLeases("net1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(leases).To(HaveLen(4))
+
+			_, err = otherNet.NetworkImport(export, types.NetworkImportOptions{})
+			Expect(err).To(MatchError(types.ErrNetworkExists))
+			networks, err = otherNet.NetworkImport(export, types.NetworkImportOptions{IgnoreIfExists: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(BeEmpty())
+
+			// a failed import removes the networks created before
+			export.Networks = append([]types.ExportedNetwork{{Network: types.Network{Name: "net3"}}}, export.Networks...)
+			_, err = otherNet.NetworkImport(export, types.NetworkImportOptions{})
+			Expect(err).To(MatchError(types.ErrNetworkExists))
+			_, err = otherNet.NetworkInspect("net3")
+			Expect(err).To(MatchError(types.ErrNoSuchNetwork))
+
+			export.Version = 99
+			_, err = otherNet.NetworkImport(export, types.NetworkImportOptions{IgnoreIfExists: true})
+			Expect(err).To(MatchError(types.ErrInvalidArg))
+		})
+
+		It("setup with invalid bandwidth options", func() {
+			for _, bw := range []*types.BandwidthOptions{
+				{IngressRate: 1000},
+				{EgressBurst: 1000},
+			} {
+				_, err := libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+					NetworkOptions: types.NetworkOptions{
+						ContainerID: "someID",
+						Networks: map[string]types.PerNetworkOptions{
+							"podman": {InterfaceName: "eth0", Bandwidth: bw},
+						},
+					},
+				})
+				Expect(err).To(MatchError(types.ErrInvalidArg))
+			}
+		})
+
+		It("setup with invalid route options", func() {
+			internal, err := libpodNet.NetworkCreate(types.Network{Internal: true}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			dest, _ := types.ParseCIDR("10.5.0.0/16")
+
+			setup := func(defaultRouteNetwork string, opts types.PerNetworkOptions) error {
+				opts.InterfaceName = "eth0"
+				networks := map[string]types.PerNetworkOptions{"podman": opts}
+				if defaultRouteNetwork == internal.Name {
+					networks[internal.Name] = types.PerNetworkOptions{InterfaceName: "eth1"}
+				}
+				_, err := libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+					NetworkOptions: types.NetworkOptions{
+						ContainerID:         "someID",
+						Networks:            networks,
+						DefaultRouteNetwork: defaultRouteNetwork,
+					},
+				})
+				return err
+			}
+			err = setup("other", types.PerNetworkOptions{})
+			Expect(err).To(MatchError(ContainSubstring("default route network other is not connected")))
+			err = setup(internal.Name, types.PerNetworkOptions{})
+			Expect(err).To(MatchError(ContainSubstring("cannot provide the default route")))
+			err = setup("", types.PerNetworkOptions{Routes: []types.Route{{Destination: dest}}})
+			Expect(err).To(MatchError(ContainSubstring("invalid route on network podman: route gateway nil")))
+		})
+
+		It("setup reserves host ports", func() {
+			dir := GinkgoT().TempDir()
+			binary := filepath.Join(dir, "netavark")
+			script := "#!/bin/sh\ncat > /dev/null\necho '{\"podman\": {}}'\n"
+			Expect(os.WriteFile(binary, []byte(script), 0o755)).To(Succeed())
+			libpodNet, err := netavark.NewNetworkInterface(&netavark.InitConfig{
+				Config:           &config.Config{},
+				NetworkConfigDir: networkConfDir,
+				NetworkRunDir:    networkConfDir,
+				NetavarkBinary:   binary,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			options := func(id string) types.NetworkOptions {
+				return types.NetworkOptions{
+					ContainerID:  id,
+					PortMappings: []types.PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+					Networks:     map[string]types.PerNetworkOptions{"podman": {InterfaceName: "eth0"}},
+				}
+			}
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{NetworkOptions: options("ctr1")})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{NetworkOptions: options("ctr2")})
+			Expect(err).To(MatchError(portregistry.ErrPortInUse))
+			Expect(err).To(MatchError(ContainSubstring("container ctr1")))
+
+			err = libpodNet.Teardown("/run/netns/invalid", types.TeardownOptions{NetworkOptions: options("ctr1")})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{NetworkOptions: options("ctr2")})
+			Expect(err).ToNot(HaveOccurred())
+
+			// mappings without a host port are not reserved
+			opts := options("ctr3")
+			opts.PortMappings = []types.PortMapping{{ContainerPort: 80, Protocol: "tcp"}}
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{NetworkOptions: opts})
+			Expect(err).ToNot(HaveOccurred())
+			registry, err := portregistry.New(filepath.Join(networkConfDir, "ports"))
+			Expect(err).ToNot(HaveOccurred())
+			mappings, err := registry.Mappings()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mappings).To(HaveKey("ctr2"))
+			Expect(mappings).ToNot(HaveKey("ctr3"))
+		})
+
+		It("setup passes attachment routes to netavark", func() {
+			dir := GinkgoT().TempDir()
+			input := filepath.Join(dir, "input.json")
+			binary := filepath.Join(dir, "netavark")
+			script := "#!/bin/sh\ncat > " + input + "\necho '{\"net1\": {}, \"net2\": {}}'\n"
+			Expect(os.WriteFile(binary, []byte(script), 0o755)).To(Succeed())
+			libpodNet, err := netavark.NewNetworkInterface(&netavark.InitConfig{
+				Config:           &config.Config{},
+				NetworkConfigDir: networkConfDir,
+				NetworkRunDir:    networkConfDir,
+				NetavarkBinary:   binary,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			subnet, _ := types.ParseCIDR("10.1.0.0/24")
+			_, err = libpodNet.NetworkCreate(types.Network{Name: "net1", Subnets: []types.Subnet{{Subnet: subnet}}}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = libpodNet.NetworkCreate(types.Network{Name: "net2"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			dest, _ := types.ParseCIDR("10.5.0.0/16")
+			route := types.Route{Destination: dest, Gateway: net.ParseIP("10.1.0.5")}
+			metric := uint32(50)
+			bandwidth := &types.BandwidthOptions{IngressRate: 1000, IngressBurst: 2000}
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+				NetworkOptions: types.NetworkOptions{
+					ContainerID: "someID",
+					Networks: map[string]types.PerNetworkOptions{
+						"net1": {InterfaceName: "eth0", Bandwidth: bandwidth},
+						"net2": {
+							InterfaceName:      "eth1",
+							Routes:             []types.Route{route},
+							DefaultRouteMetric: &metric,
+						},
+					},
+					DefaultRouteNetwork: "net1",
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			content, err := os.ReadFile(input)
+			Expect(err).ToNot(HaveOccurred())
+			var opts struct {
+				Networks    map[string]types.PerNetworkOptions `json:"networks"`
+				NetworkInfo map[string]types.Network           `json:"network_info"`
+			}
+			Expect(json.Unmarshal(content, &opts)).To(Succeed())
+			Expect(opts.Networks["net1"].Bandwidth).To(Equal(bandwidth))
+			Expect(opts.NetworkInfo["net1"].Routes).To(BeEmpty())
+			Expect(opts.NetworkInfo["net1"].Options).ToNot(HaveKey(types.NoDefaultRoute))
+			Expect(opts.NetworkInfo["net2"].Routes).To(Equal([]types.Route{route}))
+			Expect(opts.NetworkInfo["net2"].Options).To(HaveKeyWithValue(types.NoDefaultRoute, "true"))
+			Expect(opts.NetworkInfo["net2"].Options).To(HaveKeyWithValue(types.MetricOption, "50"))
+
+			// the stored network is not changed
+			network2, err := libpodNet.NetworkInspect("net2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2.Routes).To(BeEmpty())
+			Expect(network2.Options).To(BeEmpty())
+		})
+
 		It("update NetworkDNSServers AddDNSServers", func() {
 			libpodNet, err := netavark.NewNetworkInterface(&netavark.InitConfig{
 				Config:           &config.Config{},
@@ -784,6 +1086,105 @@ var _ = Describe("Config", func() {
 			Expect(testNetwork.NetworkDNSServers).To(Equal([]string{"8.8.8.8", "7.7.7.7"}))
 		})
 
+		It("update network labels, options and routes", func() {
+			network := types.Network{
+				Labels:  map[string]string{"a": "1", "b": "2"},
+				Options: map[string]string{types.MTUOption: "1400"},
+				Routes: []types.Route{
+					{Destination: mustParseCIDR("10.1.0.0/24"), Gateway: net.ParseIP("10.89.0.2")},
+				},
+			}
+			network1, err := libpodNet.NetworkCreate(network, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				AddLabels:     map[string]string{"b": "3", "c": "4"},
+				RemoveLabels:  []string{"a"},
+				SetOptions:    map[string]string{types.IsolateOption: "1", types.MetricOption: "200"},
+				RemoveOptions: []string{types.MTUOption},
+				AddRoutes: []types.Route{
+					{Destination: mustParseCIDR("10.2.0.0/24"), Gateway: net.ParseIP("10.89.0.3")},
+				},
+				RemoveRoutes: []types.IPNet{mustParseCIDR("10.1.0.0/24")},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			network2, err := libpodNet.NetworkInspect(network1.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2.Labels).To(Equal(map[string]string{"b": "3", "c": "4"}))
+			Expect(network2.Options).To(Equal(map[string]string{types.IsolateOption: "true", types.MetricOption: "200"}))
+			Expect(network2.Routes).To(HaveLen(1))
+			Expect(network2.Routes[0].Destination.String()).To(Equal("10.2.0.0/24"))
+
+			// make sure the change was written to disk
+			libpodNet, err = getNetworkInterface(networkConfDir)
+			Expect(err).ToNot(HaveOccurred())
+			network3, err := libpodNet.NetworkInspect(network1.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network3.Labels).To(Equal(network2.Labels))
+			Expect(network3.Options).To(Equal(network2.Options))
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				SetOptions: map[string]string{types.VLANOption: "5"},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("network option vlan cannot be updated"))
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				SetOptions: map[string]string{types.MTUOption: "abc"},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("update network add subnets and lease range", func() {
+			subnet := mustParseCIDR("10.10.0.0/24")
+			network := types.Network{
+				Subnets: []types.Subnet{{Subnet: subnet}},
+			}
+			network1, err := libpodNet.NetworkCreate(network, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network1.IPv6Enabled).To(BeFalse())
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				AddSubnets: []types.Subnet{{Subnet: mustParseCIDR("fd10:10::/64")}},
+				LeaseRanges: map[string]*types.LeaseRange{
+					"10.10.0.0/24": {StartIP: net.ParseIP("10.10.0.100")},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			network2, err := libpodNet.NetworkInspect(network1.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network2.IPv6Enabled).To(BeTrue())
+			Expect(network2.Subnets).To(HaveLen(2))
+			Expect(network2.Subnets[0].LeaseRange.StartIP.String()).To(Equal("10.10.0.100"))
+			Expect(network2.Subnets[1].Subnet.String()).To(Equal("fd10:10::/64"))
+			Expect(network2.Subnets[1].Gateway.String()).To(Equal("fd10:10::1"))
+
+			// overlapping subnets are rejected
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				AddSubnets: []types.Subnet{{Subnet: mustParseCIDR("10.10.0.128/25")}},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("is already used on the host or by another config"))
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				LeaseRanges: map[string]*types.LeaseRange{
+					"10.20.0.0/24": nil,
+				},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("subnet 10.20.0.0/24 is not part of network"))
+
+			err = libpodNet.NetworkUpdate(network1.Name, types.NetworkUpdateOptions{
+				LeaseRanges: map[string]*types.LeaseRange{
+					"10.10.0.0/24": {StartIP: net.ParseIP("10.11.0.1")},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("lease range start ip 10.11.0.1 not in subnet 10.10.0.0/24"))
+		})
+
 		It("create network with NetworDNSServers", func() {
 			network := types.Network{
 				NetworkDNSServers: []string{"8.8.8.8", "3.3.3.3"},
@@ -957,6 +1358,102 @@ var _ = Describe("Config", func() {
 			Expect(network2.Options).To(HaveKeyWithValue("vlan", "99"))
 		})
 
+		It("create network with vlan_trunk and vlan_filtering options", func() {
+			network := types.Network{
+				Options: map[string]string{
+					types.VLANTrunkOption:     "10, 20-30",
+					types.VLANFilteringOption: "1",
+				},
+			}
+			network1, err := libpodNet.NetworkCreate(network, nil)
+			Expect(err).ToNot(HaveOccurred())
+			path := filepath.Join(networkConfDir, network1.Name+".json")
+			Expect(path).To(BeARegularFile())
+			grepInFile(path, `"vlan_trunk": "10,20-30"`)
+			Expect(network1.Options).To(HaveKeyWithValue(types.VLANTrunkOption, "10,20-30"))
+			Expect(network1.Options).To(HaveKeyWithValue(types.VLANFilteringOption, "true"))
+
+			for _, trunk := range []string{"0", "30-20", "abc"} {
+				network = types.Network{Options: map[string]string{types.VLANTrunkOption: trunk}}
+				_, err = libpodNet.NetworkCreate(network, nil)
+				Expect(err).To(HaveOccurred(), trunk)
+			}
+		})
+
+		It("setup with container vlan options", func() {
+			plain, err := libpodNet.NetworkCreate(types.Network{}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			filtered, err := libpodNet.NetworkCreate(types.Network{
+				Options: map[string]string{types.VLANFilteringOption: "true"},
+			}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			setup := func(name string, opts map[string]string) error {
+				_, err := libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+					NetworkOptions: types.NetworkOptions{
+						ContainerID: "someID",
+						Networks: map[string]types.PerNetworkOptions{
+							name: {InterfaceName: "eth0", Options: opts},
+						},
+					},
+				})
+				return err
+			}
+			err = setup(plain.Name, map[string]string{types.VLANOption: "10"})
+			Expect(err).To(MatchError(types.ErrInvalidArg))
+			Expect(err).To(MatchError(ContainSubstring("vlan options require a bridge network with vlan filtering")))
+			err = setup(filtered.Name, map[string]string{types.VLANTrunkOption: "20-10"})
+			Expect(err).To(MatchError(ContainSubstring("invalid vlan_trunk option")))
+			err = setup(filtered.Name, map[string]string{types.VLANOption: "4095"})
+			Expect(err).To(MatchError(ContainSubstring("invalid vlan option")))
+		})
+
+		It("setup passes vlan options to netavark", func() {
+			dir := GinkgoT().TempDir()
+			input := filepath.Join(dir, "input.json")
+			binary := filepath.Join(dir, "netavark")
+			script := "#!/bin/sh\ncat > " + input + "\necho '{\"net1\": {}}'\n"
+			Expect(os.WriteFile(binary, []byte(script), 0o755)).To(Succeed())
+			libpodNet, err := netavark.NewNetworkInterface(&netavark.InitConfig{
+				Config:           &config.Config{},
+				NetworkConfigDir: networkConfDir,
+				NetworkRunDir:    networkConfDir,
+				NetavarkBinary:   binary,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = libpodNet.NetworkCreate(types.Network{
+				Name: "net1",
+				Options: map[string]string{
+					types.VLANTrunkOption:     "100",
+					types.VLANFilteringOption: "true",
+				},
+			}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vlanOpts := map[string]string{types.VLANOption: "10", types.VLANTrunkOption: "20-30"}
+			_, err = libpodNet.Setup("/run/netns/invalid", types.SetupOptions{
+				NetworkOptions: types.NetworkOptions{
+					ContainerID: "someID",
+					Networks: map[string]types.PerNetworkOptions{
+						"net1": {InterfaceName: "eth0", Options: vlanOpts},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			content, err := os.ReadFile(input)
+			Expect(err).ToNot(HaveOccurred())
+			var opts struct {
+				Networks    map[string]types.PerNetworkOptions `json:"networks"`
+				NetworkInfo map[string]types.Network           `json:"network_info"`
+			}
+			Expect(json.Unmarshal(content, &opts)).To(Succeed())
+			Expect(opts.Networks["net1"].Options).To(Equal(vlanOpts))
+			Expect(opts.NetworkInfo["net1"].Options).To(HaveKeyWithValue(types.VLANTrunkOption, "100"))
+			Expect(opts.NetworkInfo["net1"].Options).To(HaveKeyWithValue(types.VLANFilteringOption, "true"))
+		})
+
 		It("create two networks with vlan option and same subnet", func() {
 			subnet := "10.0.0.0/24"
 			n, _ := types.ParseCIDR(subnet)
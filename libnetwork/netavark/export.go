@@ -0,0 +1,169 @@
+//go:build linux || freebsd
+
+package netavark
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	internalutil "github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/libnetwork/util"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// NetworkExport returns a portable document with the networks with the
+// given names or IDs including their IPAM reservations. If no network is
+// given all networks except the default network are exported.
+func (n *netavarkNetwork) NetworkExport(namesOrIDs ...string) (*types.NetworkExport, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := internalutil.NetworksToExport(n, n.defaultNetwork, namesOrIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &types.NetworkExport{
+		Version:  types.NetworkExportVersion,
+		Backend:  types.Netavark,
+		Networks: make([]types.ExportedNetwork, 0, len(networks)),
+	}
+	for _, network := range networks {
+		export.Networks = append(export.Networks, types.ExportedNetwork{Network: network})
+	}
+	if !slices.ContainsFunc(networks, func(network types.Network) bool { return requiresIPAMAlloc(&network) }) {
+		return export, nil
+	}
+
+	db, err := n.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		for i := range export.Networks {
+			leases, err := networkLeases(tx, &export.Networks[i].Network)
+			if err != nil {
+				return err
+			}
+			export.Networks[i].Reservations = reservedRanges(leases)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// NetworkImport creates the networks from an export document. The import is
+// all or nothing, when one network cannot be created the networks created
+// before are removed again.
+func (n *netavarkNetwork) NetworkImport(export *types.NetworkExport, options types.NetworkImportOptions) ([]types.Network, error) {
+	if err := internalutil.ValidateNetworkExport(export); err != nil {
+		return nil, err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	created := make([]types.Network, 0, len(export.Networks))
+	for i := range export.Networks {
+		exported := &export.Networks[i]
+		if _, ok := n.networks[exported.Name]; ok {
+			if options.IgnoreIfExists {
+				continue
+			}
+			err = fmt.Errorf("network name %s already used: %w", exported.Name, types.ErrNetworkExists)
+			break
+		}
+		var network *types.Network
+		network, err = n.importNetwork(exported)
+		if network != nil {
+			created = append(created, *network)
+		}
+		if err != nil {
+			err = fmt.Errorf("import network %s: %w", exported.Name, err)
+			break
+		}
+	}
+	if err != nil {
+		n.removeImportedNetworks(created)
+		return nil, err
+	}
+	return created, nil
+}
+
+// importNetwork creates the network and its reservations. The network is
+// returned once it was created even when the reservations failed, so it can
+// be removed again.
+func (n *netavarkNetwork) importNetwork(exported *types.ExportedNetwork) (*types.Network, error) {
+	newNetwork := internalutil.NetworkFromExport(exported)
+	network, err := n.networkCreate(&newNetwork, false)
+	if err != nil {
+		return nil, err
+	}
+	n.networks[network.Name] = network
+
+	for _, ipRange := range exported.Reservations {
+		if err := n.setReservation(network, ipRange, true); err != nil {
+			return network, err
+		}
+	}
+	return network, nil
+}
+
+func (n *netavarkNetwork) removeImportedNetworks(networks []types.Network) {
+	for i := range networks {
+		network := &networks[i]
+		if err := n.removeNetworkIPAMBucket(network); err != nil {
+			logrus.Errorf("Failed to remove ipam state of network %s: %v", network.Name, err)
+		}
+		file := filepath.Join(n.networkConfigDir, network.Name+".json")
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Errorf("Failed to remove network %s: %v", network.Name, err)
+		}
+		delete(n.networks, network.Name)
+	}
+}
+
+// reservedRanges merges the consecutive reserved ips of the leases into
+// ranges.
+func reservedRanges(leases []types.IPAMLease) []types.LeaseRange {
+	var ips []types.IPAMLease
+	for _, lease := range leases {
+		if lease.Reserved {
+			ips = append(ips, lease)
+		}
+	}
+	slices.SortFunc(ips, func(a, b types.IPAMLease) int {
+		return util.Cmp(a.IP, b.IP)
+	})
+
+	var ranges []types.LeaseRange
+	// a range must not span several subnets
+	var lastSubnet string
+	for _, lease := range ips {
+		subnet := lease.Subnet.String()
+		if last := len(ranges) - 1; last >= 0 && subnet == lastSubnet &&
+			util.NextIP(ranges[last].EndIP).Equal(lease.IP) {
+			ranges[last].EndIP = lease.IP
+			continue
+		}
+		ranges = append(ranges, types.LeaseRange{StartIP: lease.IP, EndIP: lease.IP})
+		lastSubnet = subnet
+	}
+	return ranges
+}
@@ -3,13 +3,16 @@
 package netavark
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/libnetwork/util"
+	"github.com/sirupsen/logrus"
 	"go.etcd.io/bbolt"
 	boltErrors "go.etcd.io/bbolt/errors"
 )
@@ -19,18 +22,28 @@ import (
 // Inside the network bucket there is an ID bucket which maps the container ID (key)
 // to a json array of ip addresses (value).
 // The network bucket also has a bucket for each subnet, the subnet is used as key.
-// Inside the subnet bucket an ip is used as key and the container ID as value.
+// Inside the subnet bucket an ip is used as key and the container ID as value,
+// reserved ips use the reservedID as value.
+// The allocation time bucket maps the container ID (key) to the time the ips
+// were allocated (value).
 
 const (
 	idBucket = "ids"
+	// allocTimeBucket is the bucket which stores the allocation times
+	allocTimeBucket = "allocated"
 	// lastIP this is used as key to store the last allocated ip
 	// note that this string should not be 4 or 16 byte long
 	lastIP = "lastIP"
+	// reservedID is stored instead of a container ID for reserved ips,
+	// it is not a valid container ID
+	reservedID = "<reserved>"
 )
 
 var (
-	idBucketKey = []byte(idBucket)
-	lastIPKey   = []byte(lastIP)
+	idBucketKey        = []byte(idBucket)
+	allocTimeBucketKey = []byte(allocTimeBucket)
+	lastIPKey          = []byte(lastIP)
+	reservedIDValue    = []byte(reservedID)
 )
 
 type ipamError struct {
@@ -118,6 +131,9 @@ func (n *netavarkNetwork) allocIPs(opts *types.NetworkOptions) error {
 					// convert to 4 byte ipv4 if needed
 					util.NormalizeIP(&ip)
 					id := subnetBkt.Get(ip)
+					if bytes.Equal(id, reservedIDValue) {
+						return newIPAMError(nil, "requested ip address %s is reserved", ip.String())
+					}
 					if id != nil {
 						return newIPAMError(nil, "requested ip address %s is already allocated to container ID %s", ip.String(), string(id))
 					}
@@ -155,6 +171,19 @@ func (n *netavarkNetwork) allocIPs(opts *types.NetworkOptions) error {
 				return newIPAMError(err, "failed to store ips in database")
 			}
 
+			timeBucket, err := netBkt.CreateBucketIfNotExists(allocTimeBucketKey)
+			if err != nil {
+				return newIPAMError(err, "failed to create/get allocation time bucket for network %s", netName)
+			}
+			allocTime, err := time.Now().MarshalText()
+			if err != nil {
+				return newIPAMError(err, "failed to marshal allocation time")
+			}
+			err = timeBucket.Put([]byte(opts.ContainerID), allocTime)
+			if err != nil {
+				return newIPAMError(err, "failed to store allocation time in database")
+			}
+
 			netOpts.StaticIPs = requestIPs
 			opts.Networks[netName] = netOpts
 		}
@@ -268,7 +297,10 @@ func (n *netavarkNetwork) getAssignedIPs(opts *types.NetworkOptions) error {
 
 			ipJSON := idBkt.Get([]byte(opts.ContainerID))
 			if ipJSON == nil {
-				return newIPAMError(nil, "failed to get ips for container ID %s on network %s", opts.ContainerID, netName)
+				// the container may have been killed during setup before
+				// the ips were allocated, continue with the other networks
+				logrus.Warnf("No ips allocated for container ID %s on network %s", opts.ContainerID, netName)
+				continue
 			}
 
 			// assignedIPs is the list of ips which should be used for this container
@@ -319,11 +351,6 @@ func (n *netavarkNetwork) deallocIPs(opts *types.NetworkOptions) error {
 			}
 
 			for _, subnet := range network.Subnets {
-				subnetBkt := netBkt.Bucket([]byte(subnet.Subnet.String()))
-				if subnetBkt == nil {
-					return newIPAMError(nil, "failed to get subnet bucket for network %s", netName)
-				}
-
 				// search for a static ip which matches the current subnet
 				// in this case the user wants this one and we should not assign a free one
 				var ip net.IP
@@ -334,10 +361,27 @@ func (n *netavarkNetwork) deallocIPs(opts *types.NetworkOptions) error {
 					}
 				}
 				if ip == nil {
+					// The subnet was added by NetworkUpdate after the ips for
+					// this container were allocated.
+					if len(netOpts.StaticIPs) < len(network.Subnets) {
+						continue
+					}
 					return newIPAMError(nil, "failed to find ip for subnet %s on network %s", subnet.Subnet.String(), netName)
 				}
+
+				subnetBkt := netBkt.Bucket([]byte(subnet.Subnet.String()))
+				if subnetBkt == nil {
+					return newIPAMError(nil, "failed to get subnet bucket for network %s", netName)
+				}
 				util.NormalizeIP(&ip)
 
+				// after a partial setup the ip may be a requested static ip
+				// which was never allocated to this container
+				if id := subnetBkt.Get(ip); id != nil && string(id) != opts.ContainerID {
+					logrus.Warnf("Not releasing ip %s on network %s, it is not allocated to container ID %s", ip.String(), netName, opts.ContainerID)
+					continue
+				}
+
 				err = subnetBkt.Delete(ip)
 				if err != nil {
 					return newIPAMError(err, "failed to remove ip %s from subnet bucket for network %s", ip.String(), netName)
@@ -353,12 +397,50 @@ func (n *netavarkNetwork) deallocIPs(opts *types.NetworkOptions) error {
 			if err != nil {
 				return newIPAMError(err, "failed to remove allocated ips for container ID %s on network %s", opts.ContainerID, netName)
 			}
+
+			// the bucket does not exist for ips allocated by older versions
+			if timeBkt := netBkt.Bucket(allocTimeBucketKey); timeBkt != nil {
+				err = timeBkt.Delete([]byte(opts.ContainerID))
+				if err != nil {
+					return newIPAMError(err, "failed to remove allocation time for container ID %s on network %s", opts.ContainerID, netName)
+				}
+			}
 		}
 		return nil
 	})
 	return err
 }
 
+// resetLastIP removes the last allocated ip of the given subnets, the next
+// allocation then starts at the beginning of the lease range again.
+func (n *netavarkNetwork) resetLastIP(network *types.Network, subnets []string) error {
+	if !requiresIPAMAlloc(network) {
+		return nil
+	}
+	db, err := n.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		netBkt := tx.Bucket([]byte(network.Name))
+		if netBkt == nil {
+			return nil
+		}
+		for _, subnet := range subnets {
+			subnetBkt := netBkt.Bucket([]byte(subnet))
+			if subnetBkt == nil {
+				continue
+			}
+			if err := subnetBkt.Delete(lastIPKey); err != nil {
+				return newIPAMError(err, "failed to remove last ip for subnet %s on network %s", subnet, network.Name)
+			}
+		}
+		return nil
+	})
+}
+
 func (n *netavarkNetwork) removeNetworkIPAMBucket(network *types.Network) error {
 	if !requiresIPAMAlloc(network) {
 		return nil
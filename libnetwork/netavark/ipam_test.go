@@ -6,6 +6,9 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/pkg/config"
@@ -558,4 +561,271 @@ var _ = Describe("IPAM", func() {
 		Expect(opts.Networks[netName].StaticIPs).To(HaveLen(1))
 		Expect(opts.Networks[netName].StaticIPs[0]).To(Equal(net.ParseIP("10.0.0.10").To4()))
 	})
+
+	It("ipam update lease range and subnets with allocated ips", func() {
+		s, _ := types.ParseCIDR("10.0.0.0/24")
+		network, err := networkInterface.NetworkCreate(
+			types.Network{
+				Subnets: []types.Subnet{{Subnet: s}},
+			},
+			nil,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		netName := network.Name
+
+		opts := &types.NetworkOptions{
+			ContainerID: "someContainerID",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {},
+			},
+		}
+		err = networkInterface.allocIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts.Networks[netName].StaticIPs).To(Equal([]net.IP{net.ParseIP("10.0.0.2").To4()}))
+
+		// the allocated ip must stay in the lease range
+		err = networkInterface.NetworkUpdate(netName, types.NetworkUpdateOptions{
+			LeaseRanges: map[string]*types.LeaseRange{
+				"10.0.0.0/24": {StartIP: net.ParseIP("10.0.0.100")},
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("ip 10.0.0.2 is allocated to a container and not part of the new lease range for subnet 10.0.0.0/24: invalid argument"))
+
+		s6, _ := types.ParseCIDR("fd00:1::/64")
+		err = networkInterface.NetworkUpdate(netName, types.NetworkUpdateOptions{
+			LeaseRanges: map[string]*types.LeaseRange{
+				"10.0.0.0/24": {StartIP: net.ParseIP("10.0.0.2"), EndIP: net.ParseIP("10.0.0.50")},
+			},
+			AddSubnets: []types.Subnet{{Subnet: s6}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		// the next allocation starts at the beginning of the new range
+		opts2 := &types.NetworkOptions{
+			ContainerID: "otherID",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {},
+			},
+		}
+		err = networkInterface.allocIPs(opts2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts2.Networks[netName].StaticIPs).To(Equal([]net.IP{net.ParseIP("10.0.0.3").To4(), net.ParseIP("fd00:1::2")}))
+
+		// the first container was connected before the subnet was added
+		err = networkInterface.getAssignedIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts.Networks[netName].StaticIPs).To(HaveLen(1))
+		err = networkInterface.deallocIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("ipam list and reserve leases", func() {
+		netName := types.DefaultNetworkName
+		err := networkInterface.IPAMReserve(netName, types.LeaseRange{
+			StartIP: net.ParseIP("10.88.0.2"),
+			EndIP:   net.ParseIP("10.88.0.4"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		opts := &types.NetworkOptions{
+			ContainerID: "someContainerID",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {},
+			},
+		}
+		before := time.Now()
+		err = networkInterface.allocIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts.Networks[netName].StaticIPs).To(Equal([]net.IP{net.ParseIP("10.88.0.5").To4()}))
+
+		leases, err := networkInterface.IPAMLeases(netName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases).To(HaveLen(4))
+		for _, lease := range leases[:3] {
+			Expect(lease.Reserved).To(BeTrue())
+			Expect(lease.ContainerID).To(BeEmpty())
+			Expect(lease.Network).To(Equal(netName))
+			Expect(lease.Subnet.String()).To(Equal("10.88.0.0/16"))
+		}
+		Expect(leases[0].IP.String()).To(Equal("10.88.0.2"))
+		Expect(leases[3].IP.String()).To(Equal("10.88.0.5"))
+		Expect(leases[3].ContainerID).To(Equal("someContainerID"))
+		Expect(leases[3].AllocatedAt).To(BeTemporally(">=", before.Truncate(time.Second)))
+
+		// reserved ips cannot be requested
+		opts2 := &types.NetworkOptions{
+			ContainerID: "otherID",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {StaticIPs: []net.IP{net.ParseIP("10.88.0.3")}},
+			},
+		}
+		err = networkInterface.allocIPs(opts2)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("IPAM error: requested ip address 10.88.0.3 is reserved"))
+
+		// allocated ips cannot be reserved
+		err = networkInterface.IPAMReserve(netName, types.LeaseRange{StartIP: net.ParseIP("10.88.0.5")})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("IPAM error: ip address 10.88.0.5 is already allocated to container ID someContainerID"))
+
+		err = networkInterface.IPAMReserve(netName, types.LeaseRange{StartIP: net.ParseIP("10.89.0.1")})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is not part of a subnet on network podman"))
+
+		s6, _ := types.ParseCIDR("fd00:5::/64")
+		network6, err := networkInterface.NetworkCreate(types.Network{Subnets: []types.Subnet{{Subnet: s6}}}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		err = networkInterface.IPAMReserve(network6.Name, types.LeaseRange{StartIP: net.ParseIP("fd00:5::1"), EndIP: net.ParseIP("fd00:5::1:ffff")})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("contains more than 65536 ips"))
+
+		err = networkInterface.IPAMUnreserve(netName, types.LeaseRange{StartIP: net.ParseIP("10.88.0.2"), EndIP: net.ParseIP("10.88.0.10")})
+		Expect(err).ToNot(HaveOccurred())
+		leases, err = networkInterface.IPAMLeases("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases).To(HaveLen(1))
+		Expect(leases[0].IP.String()).To(Equal("10.88.0.5"))
+		Expect(leases[0].ContainerID).To(Equal("someContainerID"))
+	})
+
+	It("ipam reclaim leases", func() {
+		netName := types.DefaultNetworkName
+		for _, id := range []string{"alive", "dead1", "dead2"} {
+			opts := &types.NetworkOptions{
+				ContainerID: id,
+				Networks: map[string]types.PerNetworkOptions{
+					netName: {},
+				},
+			}
+			err := networkInterface.allocIPs(opts)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		err := networkInterface.IPAMReserve(netName, types.LeaseRange{StartIP: net.ParseIP("10.88.0.100")})
+		Expect(err).ToNot(HaveOccurred())
+
+		exists := func(id string) bool { return id == "alive" }
+		reclaimed, err := networkInterface.IPAMReclaim(types.IPAMReclaimOptions{ContainerExists: exists, DryRun: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reclaimed).To(HaveLen(2))
+		leases, err := networkInterface.IPAMLeases(netName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases).To(HaveLen(4))
+
+		reclaimed, err = networkInterface.IPAMReclaim(types.IPAMReclaimOptions{ContainerExists: exists})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reclaimed).To(HaveLen(2))
+		Expect(reclaimed[0].ContainerID).To(Equal("dead1"))
+		Expect(reclaimed[1].ContainerID).To(Equal("dead2"))
+
+		leases, err = networkInterface.IPAMLeases(netName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases).To(HaveLen(2))
+		Expect(leases[0].ContainerID).To(Equal("alive"))
+		Expect(leases[1].Reserved).To(BeTrue())
+
+		// the ips of the removed containers are free again
+		opts := &types.NetworkOptions{
+			ContainerID: "dead1",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {},
+			},
+		}
+		err = networkInterface.getAssignedIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts.Networks[netName].StaticIPs).To(BeEmpty())
+		opts.Networks[netName] = types.PerNetworkOptions{StaticIPs: []net.IP{net.ParseIP("10.88.0.3")}}
+		err = networkInterface.allocIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("ipam teardown after partial setup", func() {
+		netName := types.DefaultNetworkName
+		owner := &types.NetworkOptions{
+			ContainerID: "owner",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {StaticIPs: []net.IP{net.ParseIP("10.88.0.2")}},
+			},
+		}
+		err := networkInterface.allocIPs(owner)
+		Expect(err).ToNot(HaveOccurred())
+
+		// the setup of this container was killed before its ips were allocated
+		opts := &types.NetworkOptions{
+			ContainerID: "partial",
+			Networks: map[string]types.PerNetworkOptions{
+				netName: {StaticIPs: []net.IP{net.ParseIP("10.88.0.2")}},
+			},
+		}
+		err = networkInterface.getAssignedIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logBuffer.String()).To(ContainSubstring("No ips allocated for container ID partial on network podman"))
+		err = networkInterface.deallocIPs(opts)
+		Expect(err).ToNot(HaveOccurred())
+
+		leases, err := networkInterface.IPAMLeases(netName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases).To(HaveLen(1))
+		Expect(leases[0].ContainerID).To(Equal("owner"))
+	})
+
+	It("reconcile networks", func() {
+		network, err := networkInterface.NetworkCreate(types.Network{Name: "removed"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for _, id := range []string{"running", "dead"} {
+			opts := &types.NetworkOptions{
+				ContainerID: id,
+				Networks: map[string]types.PerNetworkOptions{
+					types.DefaultNetworkName: {},
+					network.Name:             {},
+				},
+			}
+			err := networkInterface.allocIPs(opts)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		err = networkInterface.IPAMReserve(types.DefaultNetworkName, types.LeaseRange{StartIP: net.ParseIP("10.88.0.100")})
+		Expect(err).ToNot(HaveOccurred())
+		// the network config is gone but its ipam state is left
+		err = os.Remove(filepath.Join(networkConfDir, network.Name+".json"))
+		Expect(err).ToNot(HaveOccurred())
+		for i, id := range []string{"running", "dead"} {
+			_, err := networkInterface.portRegistry.Reserve(id, []types.PortMapping{{HostPort: uint16(8080 + i), ContainerPort: 80}}, nil)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		options := types.NetworkReconcileOptions{RunningContainers: []string{"running"}, DryRun: true}
+		report, err := networkInterface.NetworkReconcile(options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.IPAMNetworks).To(Equal([]string{"removed"}))
+		Expect(report.Leases).To(HaveLen(1))
+		Expect(report.Leases[0].ContainerID).To(Equal("dead"))
+		Expect(report.Leases[0].Network).To(Equal(types.DefaultNetworkName))
+		Expect(report.Attachments).To(Equal([]types.OrphanedAttachment{{ContainerID: "dead", Network: types.DefaultNetworkName}}))
+		Expect(report.Ports).To(Equal([]types.OrphanedPorts{{
+			ContainerID:  "dead",
+			PortMappings: []types.PortMapping{{HostPort: 8081, ContainerPort: 80, Range: 1, Protocol: "tcp"}},
+		}}))
+
+		options.DryRun = false
+		report, err = networkInterface.NetworkReconcile(options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.IPAMNetworks).To(Equal([]string{"removed"}))
+		Expect(report.Leases).To(HaveLen(1))
+
+		leases, err := networkInterface.IPAMLeases("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases).To(HaveLen(2))
+		Expect(leases[0].ContainerID).To(Equal("running"))
+		Expect(leases[1].Reserved).To(BeTrue())
+		mappings, err := networkInterface.portRegistry.Mappings()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings).To(HaveKey("running"))
+		Expect(mappings).ToNot(HaveKey("dead"))
+
+		report, err = networkInterface.NetworkReconcile(options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.IPAMNetworks).To(BeEmpty())
+		Expect(report.Leases).To(BeEmpty())
+		Expect(report.Ports).To(BeEmpty())
+	})
 })
@@ -0,0 +1,381 @@
+//go:build linux || freebsd
+
+package netavark
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/libnetwork/util"
+	"go.etcd.io/bbolt"
+)
+
+// maxReserveIPs is the maximum number of ips which can be reserved at once,
+// it prevents filling the database with huge ipv6 ranges.
+const maxReserveIPs = 1 << 16
+
+// IPAMLeases returns the ips allocated by the ipam on the network with the
+// given name or ID, including reserved ips. If nameOrID is empty the leases
+// of all networks are returned.
+func (n *netavarkNetwork) IPAMLeases(nameOrID string) ([]types.IPAMLease, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := n.ipamNetworks(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := n.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	leases := []types.IPAMLease{}
+	err = db.View(func(tx *bbolt.Tx) error {
+		for _, network := range networks {
+			netLeases, err := networkLeases(tx, network)
+			if err != nil {
+				return err
+			}
+			leases = append(leases, netLeases...)
+		}
+		return nil
+	})
+	return leases, err
+}
+
+// IPAMReserve reserves all ips in the range on the network so they are never
+// allocated to a container. It fails when one of the ips is already allocated.
+func (n *netavarkNetwork) IPAMReserve(nameOrID string, ipRange types.LeaseRange) error {
+	return n.updateReservation(nameOrID, ipRange, true)
+}
+
+// IPAMUnreserve releases reserved ips in the range on the network.
+func (n *netavarkNetwork) IPAMUnreserve(nameOrID string, ipRange types.LeaseRange) error {
+	return n.updateReservation(nameOrID, ipRange, false)
+}
+
+func (n *netavarkNetwork) updateReservation(nameOrID string, ipRange types.LeaseRange, reserve bool) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return err
+	}
+	network, err := n.getNetwork(nameOrID)
+	if err != nil {
+		return err
+	}
+	return n.setReservation(network, ipRange, reserve)
+}
+
+// setReservation reserves or releases the ips in the range, the caller must
+// hold the lock.
+func (n *netavarkNetwork) setReservation(network *types.Network, ipRange types.LeaseRange, reserve bool) error {
+	subnet, ips, err := rangeIPs(network, ipRange)
+	if err != nil {
+		return err
+	}
+
+	db, err := n.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		netBkt, err := tx.CreateBucketIfNotExists([]byte(network.Name))
+		if err != nil {
+			return newIPAMError(err, "failed to create/get network bucket for network %s", network.Name)
+		}
+		subnetBkt, err := netBkt.CreateBucketIfNotExists([]byte(subnet.Subnet.String()))
+		if err != nil {
+			return newIPAMError(err, "failed to create/get subnet bucket for network %s", network.Name)
+		}
+		for _, ip := range ips {
+			id := subnetBkt.Get(ip)
+			if !reserve {
+				if bytes.Equal(id, reservedIDValue) {
+					if err := subnetBkt.Delete(ip); err != nil {
+						return newIPAMError(err, "failed to remove reserved ip %s", ip.String())
+					}
+				}
+				continue
+			}
+			if id != nil && !bytes.Equal(id, reservedIDValue) {
+				return newIPAMError(nil, "ip address %s is already allocated to container ID %s", ip.String(), string(id))
+			}
+			if err := subnetBkt.Put(ip, reservedIDValue); err != nil {
+				return newIPAMError(err, "failed to store reserved ip %s", ip.String())
+			}
+		}
+		return nil
+	})
+}
+
+// IPAMReclaim finds the leases of containers which no longer exist and
+// releases them. It returns the affected leases.
+func (n *netavarkNetwork) IPAMReclaim(options types.IPAMReclaimOptions) ([]types.IPAMLease, error) {
+	if options.ContainerExists == nil {
+		return nil, fmt.Errorf("ContainerExists must be set: %w", types.ErrInvalidArg)
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := n.ipamNetworks("")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := n.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// only ask once per container
+	exists := map[string]bool{}
+	containerExists := func(id string) bool {
+		e, ok := exists[id]
+		if !ok {
+			e = options.ContainerExists(id)
+			exists[id] = e
+		}
+		return e
+	}
+
+	run := db.Update
+	if options.DryRun {
+		run = db.View
+	}
+	var reclaimed []types.IPAMLease
+	err = run(func(tx *bbolt.Tx) error {
+		reclaimed, _, err = reclaimLeases(tx, networks, containerExists, options.DryRun)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reclaimed, nil
+}
+
+// reclaimLeases returns the leases on the networks of containers which no
+// longer exist and removes them unless dryRun is set. It also returns all
+// leases of the networks.
+func reclaimLeases(tx *bbolt.Tx, networks []*types.Network, containerExists func(string) bool, dryRun bool) ([]types.IPAMLease, []types.IPAMLease, error) {
+	reclaimed := []types.IPAMLease{}
+	var all []types.IPAMLease
+	for _, network := range networks {
+		leases, err := networkLeases(tx, network)
+		if err != nil {
+			return nil, nil, err
+		}
+		all = append(all, leases...)
+		var stale []types.IPAMLease
+		for _, lease := range leases {
+			if !lease.Reserved && !containerExists(lease.ContainerID) {
+				stale = append(stale, lease)
+			}
+		}
+		reclaimed = append(reclaimed, stale...)
+		if dryRun {
+			continue
+		}
+		if err := removeStaleLeases(tx, network.Name, stale, containerExists); err != nil {
+			return nil, nil, err
+		}
+	}
+	return reclaimed, all, nil
+}
+
+// removeStaleLeases removes the leases and all ids of containers which no
+// longer exist from the network bucket.
+func removeStaleLeases(tx *bbolt.Tx, netName string, leases []types.IPAMLease, containerExists func(string) bool) error {
+	netBkt := tx.Bucket([]byte(netName))
+	if netBkt == nil {
+		return nil
+	}
+	for _, lease := range leases {
+		subnetBkt := netBkt.Bucket([]byte(lease.Subnet.String()))
+		if subnetBkt == nil {
+			continue
+		}
+		if err := subnetBkt.Delete(lease.IP); err != nil {
+			return newIPAMError(err, "failed to remove ip %s from subnet bucket for network %s", lease.IP.String(), netName)
+		}
+	}
+
+	// the id and time buckets may also contain containers without any ip
+	for _, bkt := range [][]byte{idBucketKey, allocTimeBucketKey} {
+		b := netBkt.Bucket(bkt)
+		if b == nil {
+			continue
+		}
+		var ids [][]byte
+		err := b.ForEach(func(k, _ []byte) error {
+			if !containerExists(string(k)) {
+				ids = append(ids, slices.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return newIPAMError(err, "failed to read %s bucket for network %s", string(bkt), netName)
+		}
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return newIPAMError(err, "failed to remove container ID %s on network %s", string(id), netName)
+			}
+		}
+	}
+	return nil
+}
+
+// ipamNetworks returns the network with the given name or ID, or all
+// networks sorted by name when nameOrID is empty.
+func (n *netavarkNetwork) ipamNetworks(nameOrID string) ([]*types.Network, error) {
+	if nameOrID != "" {
+		network, err := n.getNetwork(nameOrID)
+		if err != nil {
+			return nil, err
+		}
+		return []*types.Network{network}, nil
+	}
+	networks := make([]*types.Network, 0, len(n.networks))
+	for _, network := range n.networks {
+		networks = append(networks, network)
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+	return networks, nil
+}
+
+// networkLeases reads all leases of the network from the database.
+func networkLeases(tx *bbolt.Tx, network *types.Network) ([]types.IPAMLease, error) {
+	if !requiresIPAMAlloc(network) {
+		return nil, nil
+	}
+	netBkt := tx.Bucket([]byte(network.Name))
+	if netBkt == nil {
+		return nil, nil
+	}
+
+	allocTimes := map[string]time.Time{}
+	if timeBkt := netBkt.Bucket(allocTimeBucketKey); timeBkt != nil {
+		err := timeBkt.ForEach(func(k, v []byte) error {
+			var t time.Time
+			if err := t.UnmarshalText(v); err == nil {
+				allocTimes[string(k)] = t
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, newIPAMError(err, "failed to read allocation times for network %s", network.Name)
+		}
+	}
+
+	var leases []types.IPAMLease
+	for _, subnet := range network.Subnets {
+		subnetBkt := netBkt.Bucket([]byte(subnet.Subnet.String()))
+		if subnetBkt == nil {
+			continue
+		}
+		err := subnetBkt.ForEach(func(k, v []byte) error {
+			if bytes.Equal(k, lastIPKey) {
+				return nil
+			}
+			lease := types.IPAMLease{
+				Network: network.Name,
+				Subnet:  subnet.Subnet,
+				IP:      slices.Clone(net.IP(k)),
+			}
+			if bytes.Equal(v, reservedIDValue) {
+				lease.Reserved = true
+			} else {
+				lease.ContainerID = string(v)
+				lease.AllocatedAt = allocTimes[lease.ContainerID]
+			}
+			leases = append(leases, lease)
+			return nil
+		})
+		if err != nil {
+			return nil, newIPAMError(err, "failed to read subnet bucket for network %s", network.Name)
+		}
+	}
+	return leases, nil
+}
+
+// getAllocatedIPs returns all ips which are allocated to containers on the
+// network, reserved ips are not included.
+func (n *netavarkNetwork) getAllocatedIPs(network *types.Network) ([]net.IP, error) {
+	if !requiresIPAMAlloc(network) {
+		return nil, nil
+	}
+	db, err := n.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var ips []net.IP
+	err = db.View(func(tx *bbolt.Tx) error {
+		leases, err := networkLeases(tx, network)
+		for _, lease := range leases {
+			if !lease.Reserved {
+				ips = append(ips, lease.IP)
+			}
+		}
+		return err
+	})
+	return ips, err
+}
+
+// rangeIPs returns the subnet and all ips of the range, the range must be
+// part of a single subnet on the network.
+func rangeIPs(network *types.Network, ipRange types.LeaseRange) (*types.Subnet, []net.IP, error) {
+	if !requiresIPAMAlloc(network) {
+		return nil, nil, fmt.Errorf("network %s does not use the host-local ipam driver: %w", network.Name, types.ErrInvalidArg)
+	}
+	if ipRange.StartIP == nil {
+		return nil, nil, fmt.Errorf("start ip must be set: %w", types.ErrInvalidArg)
+	}
+	start := slices.Clone(ipRange.StartIP)
+	util.NormalizeIP(&start)
+	end := start
+	if ipRange.EndIP != nil {
+		end = slices.Clone(ipRange.EndIP)
+		util.NormalizeIP(&end)
+	}
+	if util.Cmp(start, end) > 0 {
+		return nil, nil, fmt.Errorf("start ip %s is after end ip %s: %w", start, end, types.ErrInvalidArg)
+	}
+
+	idx := slices.IndexFunc(network.Subnets, func(s types.Subnet) bool {
+		return s.Subnet.Contains(start) && s.Subnet.Contains(end)
+	})
+	if idx < 0 {
+		return nil, nil, fmt.Errorf("ip range %s - %s is not part of a subnet on network %s: %w", start, end, network.Name, types.ErrInvalidArg)
+	}
+
+	var ips []net.IP
+	for ip := start; util.Cmp(ip, end) <= 0; ip = util.NextIP(ip) {
+		if len(ips) == maxReserveIPs {
+			return nil, nil, fmt.Errorf("ip range %s - %s contains more than %d ips: %w", start, end, maxReserveIPs, types.ErrInvalidArg)
+		}
+		ips = append(ips, ip)
+	}
+	return &network.Subnets[idx], ips, nil
+}
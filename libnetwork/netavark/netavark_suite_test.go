@@ -61,6 +61,12 @@ func getNetworkInterfaceWithPlugins(confDir string, pluginDirs []string) (types.
 	})
 }
 
+func mustParseCIDR(cidr string) types.IPNet {
+	n, err := types.ParseCIDR(cidr)
+	Expect(err).ToNot(HaveOccurred())
+	return n
+}
+
 // EqualSubnet is a custom GomegaMatcher to match a subnet
 // This makes sure to not use the 16 bytes ip representation.
 func EqualSubnet(subnet *net.IPNet) gomegaTypes.GomegaMatcher {
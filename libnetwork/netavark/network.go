@@ -13,6 +13,7 @@ import (
 
 	"github.com/containers/common/libnetwork/internal/rootlessnetns"
 	"github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/portregistry"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/pkg/config"
 	"github.com/containers/common/pkg/version"
@@ -46,6 +47,9 @@ type netavarkNetwork struct {
 
 	// defaultsubnetPools contains the subnets which must be used to allocate a free subnet by network create
 	defaultsubnetPools []config.SubnetPool
+	// defaultIPv6SubnetPools contains the subnets which are used to allocate a free ipv6
+	// subnet by network create, a random subnet is used when it is empty
+	defaultIPv6SubnetPools []config.SubnetPool
 
 	// dnsBindPort is set the port to pass to netavark for aardvark
 	dnsBindPort uint16
@@ -71,6 +75,9 @@ type netavarkNetwork struct {
 
 	// rootlessNetns is used for the rootless network setup/teardown
 	rootlessNetns *rootlessnetns.Netns
+
+	// portRegistry tracks the host ports of all containers to detect conflicts
+	portRegistry *portregistry.Registry
 }
 
 type InitConfig struct {
@@ -138,6 +145,10 @@ func NewNetworkInterface(conf *InitConfig) (types.ContainerNetwork, error) {
 	if err := os.MkdirAll(conf.NetworkRunDir, 0o755); err != nil {
 		return nil, err
 	}
+	portRegistry, err := portregistry.New(filepath.Join(conf.NetworkRunDir, "ports"))
+	if err != nil {
+		return nil, err
+	}
 
 	defaultSubnetPools := conf.Config.Network.DefaultSubnetPools
 	if defaultSubnetPools == nil {
@@ -145,23 +156,31 @@ func NewNetworkInterface(conf *InitConfig) (types.ContainerNetwork, error) {
 	}
 
 	n := &netavarkNetwork{
-		networkConfigDir:   conf.NetworkConfigDir,
-		networkRunDir:      conf.NetworkRunDir,
-		netavarkBinary:     conf.NetavarkBinary,
-		aardvarkBinary:     conf.AardvarkBinary,
-		networkRootless:    useRootlessNetns,
-		ipamDBPath:         filepath.Join(conf.NetworkRunDir, "ipam.db"),
-		firewallDriver:     conf.Config.Network.FirewallDriver,
-		defaultNetwork:     defaultNetworkName,
-		defaultSubnet:      defaultNet,
-		defaultsubnetPools: defaultSubnetPools,
-		dnsBindPort:        conf.Config.Network.DNSBindPort,
-		pluginDirs:         conf.Config.Network.NetavarkPluginDirs.Get(),
-		lock:               lock,
-		syslog:             conf.Syslog,
-		rootlessNetns:      netns,
+		networkConfigDir:       conf.NetworkConfigDir,
+		networkRunDir:          conf.NetworkRunDir,
+		netavarkBinary:         conf.NetavarkBinary,
+		aardvarkBinary:         conf.AardvarkBinary,
+		networkRootless:        useRootlessNetns,
+		ipamDBPath:             filepath.Join(conf.NetworkRunDir, "ipam.db"),
+		firewallDriver:         conf.Config.Network.FirewallDriver,
+		defaultNetwork:         defaultNetworkName,
+		defaultSubnet:          defaultNet,
+		defaultsubnetPools:     defaultSubnetPools,
+		defaultIPv6SubnetPools: conf.Config.Network.DefaultIPv6SubnetPools,
+		dnsBindPort:            conf.Config.Network.DNSBindPort,
+		pluginDirs:             conf.Config.Network.NetavarkPluginDirs.Get(),
+		lock:                   lock,
+		syslog:                 conf.Syslog,
+		rootlessNetns:          netns,
+		portRegistry:           portRegistry,
 	}
 
+	util.ReconcileNetworkDefinitions(n, &util.NetworkDefinitionsOptions{
+		Dir:       conf.Config.Network.NetworkDefinitionsDir,
+		Update:    conf.Config.Network.NetworkDefinitionsUpdate,
+		StampFile: filepath.Join(n.networkConfigDir, util.DefinitionsStampFile),
+	})
+
 	return n, nil
 }
 
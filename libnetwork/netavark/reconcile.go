@@ -0,0 +1,155 @@
+//go:build linux || freebsd
+
+package netavark
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// NetworkReconcile removes the IPAM leases and port reservations of
+// containers which are not running, the IPAM state of removed networks and
+// the veths of bridge networks without a running container. The attachments of the stale leases
+// are torn down by netavark in an empty netns, this removes their firewall
+// rules and the bridges netavark created once they are no longer used.
+// Unmanaged networks and bridges which were not created by netavark are
+// not touched.
+func (n *netavarkNetwork) NetworkReconcile(options types.NetworkReconcileOptions) (*types.NetworkReconcileReport, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	err := n.loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := n.ipamNetworks("")
+	if err != nil {
+		return nil, err
+	}
+	running := util.RunningContainerSet(&options)
+	containerRunning := func(id string) bool {
+		return running[id]
+	}
+
+	db, err := n.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	report := &types.NetworkReconcileReport{}
+	run := db.Update
+	if options.DryRun {
+		run = db.View
+	}
+	var leases []types.IPAMLease
+	err = run(func(tx *bbolt.Tx) error {
+		var stale [][]byte
+		err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if _, ok := n.networks[string(name)]; !ok {
+				stale = append(stale, slices.Clone(name))
+			}
+			return nil
+		})
+		if err != nil {
+			return newIPAMError(err, "failed to read network buckets")
+		}
+		for _, name := range stale {
+			report.IPAMNetworks = append(report.IPAMNetworks, string(name))
+			if options.DryRun {
+				continue
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return newIPAMError(err, "failed to remove bucket of removed network %s", string(name))
+			}
+		}
+
+		report.Leases, leases, err = reclaimLeases(tx, networks, containerRunning, options.DryRun)
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	report.Ports, err = util.ReconcilePorts(n.portRegistry, running, options.DryRun)
+	if err != nil {
+		return report, err
+	}
+
+	// the bridges of rootless networks are in the rootless netns
+	if n.rootlessNetns != nil {
+		return report, nil
+	}
+	var bridgeNetworks []*types.Network
+	for _, network := range networks {
+		// without leases it is unknown if a network is used
+		if requiresIPAMAlloc(network) {
+			bridgeNetworks = append(bridgeNetworks, network)
+		}
+	}
+	report.Interfaces, err = util.OrphanedInterfaces(util.UnusedBridgeNetworks(bridgeNetworks, leases, running))
+	if err != nil {
+		return report, err
+	}
+	report.Attachments = staleAttachments(report.Leases)
+	if options.DryRun {
+		return report, nil
+	}
+	// netavark only removes a bridge without attached interfaces
+	if err := util.RemoveInterfaces(report.Interfaces); err != nil {
+		return report, err
+	}
+	return report, n.teardownAttachments(report.Attachments, report.Leases)
+}
+
+// staleAttachments returns one attachment for each container and network
+// of the leases. netavark does not store the interface name in the
+// container, the attachments have none.
+func staleAttachments(leases []types.IPAMLease) []types.OrphanedAttachment {
+	var attachments []types.OrphanedAttachment
+	seen := make(map[types.OrphanedAttachment]bool)
+	for _, lease := range leases {
+		attachment := types.OrphanedAttachment{ContainerID: lease.ContainerID, Network: lease.Network}
+		if seen[attachment] {
+			continue
+		}
+		seen[attachment] = true
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// teardownAttachments runs the netavark teardown for the attachments in an
+// empty netns with the ips of the leases. The interfaces in the container
+// no longer exist, netavark reports errors for them while it still removes
+// the firewall rules and unused bridges, they are only logged.
+func (n *netavarkNetwork) teardownAttachments(attachments []types.OrphanedAttachment, leases []types.IPAMLease) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	return withEmptyNetns(func(nsPath string) error {
+		for _, attachment := range attachments {
+			perNetworkOpts := types.PerNetworkOptions{InterfaceName: "eth0"}
+			for _, lease := range leases {
+				if lease.ContainerID == attachment.ContainerID && lease.Network == attachment.Network {
+					perNetworkOpts.StaticIPs = append(perNetworkOpts.StaticIPs, lease.IP)
+				}
+			}
+			netavarkOpts, needPlugin, err := n.convertNetOpts(types.NetworkOptions{
+				ContainerID: attachment.ContainerID,
+				Networks:    map[string]types.PerNetworkOptions{attachment.Network: perNetworkOpts},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to convert net opts: %w", err)
+			}
+			if err := n.execNetavark([]string{"teardown", nsPath}, needPlugin, netavarkOpts, nil); err != nil {
+				logrus.Warnf("Teardown of container ID %s on network %s: %v", attachment.ContainerID, attachment.Network, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,9 @@
+package netavark
+
+import "errors"
+
+// withEmptyNetns is not supported on freebsd, the netavark teardown of stale
+// attachments needs a linux netns.
+func withEmptyNetns(func(nsPath string) error) error {
+	return errors.New("teardown of stale attachments is not supported on freebsd")
+}
@@ -0,0 +1,24 @@
+package netavark
+
+import (
+	"fmt"
+
+	"github.com/containers/common/pkg/netns"
+	"github.com/sirupsen/logrus"
+)
+
+// withEmptyNetns runs toRun with the path of a new netns which is removed
+// afterwards.
+func withEmptyNetns(toRun func(nsPath string) error) error {
+	ns, err := netns.NewNS()
+	if err != nil {
+		return fmt.Errorf("create netns: %w", err)
+	}
+	defer func() {
+		ns.Close()
+		if err := netns.UnmountNS(ns.Path()); err != nil {
+			logrus.Errorf("Failed to remove netns %s: %v", ns.Path(), err)
+		}
+	}()
+	return toRun(ns.Path())
+}
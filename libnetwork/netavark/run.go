@@ -5,6 +5,7 @@ package netavark
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"slices"
 	"strconv"
 	"strings"
@@ -39,6 +40,16 @@ func (n *netavarkNetwork) Setup(namespacePath string, options types.SetupOptions
 		return nil, err
 	}
 
+	err = util.ReservePorts(n.portRegistry, options.ContainerID, options.PortMappings, slices.Collect(maps.Keys(options.Networks)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			util.ReleasePorts(n.portRegistry, options.ContainerID, slices.Collect(maps.Keys(options.Networks)))
+		}
+	}()
+
 	// allocate IPs in the IPAM db
 	err = n.allocIPs(&options.NetworkOptions)
 	if err != nil {
@@ -111,6 +122,12 @@ func (n *netavarkNetwork) Teardown(namespacePath string, options types.TeardownO
 		return err
 	}
 
+	util.ReleasePorts(n.portRegistry, options.ContainerID, slices.Collect(maps.Keys(options.Networks)))
+
+	// the rootless netns ref count was incremented for all networks
+	numNetworks := len(options.Networks)
+	options.Networks = n.existingNetworks(options.Networks)
+
 	// get IPs from the IPAM db
 	err = n.getAssignedIPs(&options.NetworkOptions)
 	if err != nil {
@@ -130,7 +147,7 @@ func (n *netavarkNetwork) Teardown(namespacePath string, options types.TeardownO
 	}
 
 	if n.rootlessNetns != nil {
-		retErr = n.rootlessNetns.Teardown(len(options.Networks), teardown)
+		retErr = n.rootlessNetns.Teardown(numNetworks, teardown)
 	} else {
 		retErr = teardown()
 	}
@@ -149,6 +166,22 @@ func (n *netavarkNetwork) Teardown(namespacePath string, options types.TeardownO
 	return retErr
 }
 
+// existingNetworks returns a copy of the per network options without the
+// networks which no longer exist, a container killed during setup may still
+// reference a network which was removed in the meantime. The other networks
+// must still be torn down to not leak their resources.
+func (n *netavarkNetwork) existingNetworks(networks map[string]types.PerNetworkOptions) map[string]types.PerNetworkOptions {
+	existing := make(map[string]types.PerNetworkOptions, len(networks))
+	for name, opts := range networks {
+		if _, ok := n.networks[name]; !ok {
+			logrus.Warnf("Network %s no longer exists, skipping its teardown", name)
+			continue
+		}
+		existing[name] = opts
+	}
+	return existing
+}
+
 func (n *netavarkNetwork) getCommonNetavarkOptions(needPlugin bool) []string {
 	opts := []string{"--config", n.networkRunDir, "--rootless=" + strconv.FormatBool(n.networkRootless), "--aardvark-binary=" + n.aardvarkBinary}
 	// to allow better backwards compat we only add the new netavark option when really needed
@@ -169,12 +202,12 @@ func (n *netavarkNetwork) convertNetOpts(opts types.NetworkOptions) (*netavarkOp
 
 	needsPlugin := false
 
-	for network := range opts.Networks {
+	for network, perNetworkOpts := range opts.Networks {
 		net, err := n.getNetwork(network)
 		if err != nil {
 			return nil, false, err
 		}
-		netavarkOptions.Networks[network] = net
+		netavarkOptions.Networks[network] = withAttachmentRoutes(net, &perNetworkOpts, opts.DefaultRouteNetwork)
 		if !slices.Contains(builtinDrivers, net.Driver) {
 			needsPlugin = true
 		}
@@ -182,6 +215,29 @@ func (n *netavarkNetwork) convertNetOpts(opts types.NetworkOptions) (*netavarkOp
 	return &netavarkOptions, needsPlugin, nil
 }
 
+// withAttachmentRoutes returns the network with the routes of the container
+// applied, netavark reads them from the network config. The network is
+// copied when it has to be changed.
+func withAttachmentRoutes(network *types.Network, opts *types.PerNetworkOptions, defaultRouteNetwork string) *types.Network {
+	noDefaultRoute := defaultRouteNetwork != "" && defaultRouteNetwork != network.Name
+	if len(opts.Routes) == 0 && opts.DefaultRouteMetric == nil && !noDefaultRoute {
+		return network
+	}
+	newNetwork := *network
+	newNetwork.Options = maps.Clone(network.Options)
+	if newNetwork.Options == nil {
+		newNetwork.Options = map[string]string{}
+	}
+	newNetwork.Routes = append(slices.Clone(network.Routes), opts.Routes...)
+	if opts.DefaultRouteMetric != nil {
+		newNetwork.Options[types.MetricOption] = strconv.FormatUint(uint64(*opts.DefaultRouteMetric), 10)
+	}
+	if noDefaultRoute {
+		newNetwork.Options[types.NoDefaultRoute] = "true"
+	}
+	return &newNetwork
+}
+
 func (n *netavarkNetwork) RunInRootlessNetns(toRun func() error) error {
 	if n.rootlessNetns == nil {
 		return types.ErrNotRootlessNetns
@@ -195,3 +251,21 @@ func (n *netavarkNetwork) RootlessNetnsInfo() (*types.RootlessNetnsInfo, error)
 	}
 	return n.rootlessNetns.Info(), nil
 }
+
+func (n *netavarkNetwork) RootlessNetnsStatus() (*types.RootlessNetnsStatus, error) {
+	if n.rootlessNetns == nil {
+		return nil, types.ErrNotRootlessNetns
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.rootlessNetns.Status()
+}
+
+func (n *netavarkNetwork) RootlessNetnsRecover(options types.RootlessNetnsRecoverOptions) (*types.RootlessNetnsStatus, error) {
+	if n.rootlessNetns == nil {
+		return nil, types.ErrNotRootlessNetns
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.rootlessNetns.Recover(options.Force)
+}
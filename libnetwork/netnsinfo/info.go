@@ -0,0 +1,92 @@
+// Package netnsinfo reports the live network state of a network namespace,
+// i.e. what actually exists in a container netns after Setup instead of the
+// StatusBlock returned at setup time.
+package netnsinfo
+
+// Options are the options for Inspect.
+type Options struct {
+	// Firewall adds the nftables and iptables rule counters, this runs
+	// the nft and iptables-save binaries in the namespace. Missing binaries
+	// are skipped.
+	Firewall bool
+}
+
+// Info is the network state of a namespace.
+type Info struct {
+	// Links are all network interfaces with their addresses.
+	Links []Link `json:"links"`
+	// Routes contains the routes of all routing tables.
+	Routes []Route `json:"routes"`
+	// Neighbors contains the arp and ndp entries.
+	Neighbors []Neighbor `json:"neighbors"`
+	// Sockets contains the listening tcp and bound udp sockets.
+	Sockets []Socket `json:"sockets"`
+	// Firewall contains the rule counters when Options.Firewall is set.
+	Firewall []FirewallCounter `json:"firewall,omitempty"`
+}
+
+// Link is a network interface.
+type Link struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	// Type is the link type, e.g. veth, bridge or loopback.
+	Type string `json:"type"`
+	MAC  string `json:"mac,omitempty"`
+	MTU  int    `json:"mtu"`
+	// State is the operational state, e.g. up, down or unknown.
+	State string `json:"state"`
+	// Addresses in CIDR notation.
+	Addresses []string `json:"addresses,omitempty"`
+	// Statistics of the link.
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// Route is an entry of a routing table.
+type Route struct {
+	// Destination in CIDR notation, "default" for the default route.
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Table       int    `json:"table"`
+	Metric      int    `json:"metric,omitempty"`
+	// Scope of the route, e.g. universe or link.
+	Scope string `json:"scope"`
+}
+
+// Neighbor is an arp or ndp entry.
+type Neighbor struct {
+	IP        string `json:"ip"`
+	MAC       string `json:"mac,omitempty"`
+	Interface string `json:"interface"`
+	// State is the neighbor state, e.g. reachable or stale.
+	State string `json:"state"`
+}
+
+// Socket is a listening or bound socket.
+type Socket struct {
+	// Protocol is tcp, tcp6, udp or udp6.
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+	// Inode of the socket, it can be used to find the owning process.
+	Inode uint64 `json:"inode"`
+}
+
+// FirewallCounter contains the counter of a firewall rule.
+type FirewallCounter struct {
+	// Backend is nftables, iptables or ip6tables.
+	Backend string `json:"backend"`
+	// Family is only set for nftables.
+	Family  string `json:"family,omitempty"`
+	Table   string `json:"table"`
+	Chain   string `json:"chain"`
+	Rule    string `json:"rule"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
@@ -0,0 +1,387 @@
+package netnsinfo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// tcpListen is the tcp state of a listening socket in /proc/net/tcp.
+	tcpListen = "0A"
+	// udpUnconnected is the state of a bound but not connected udp socket.
+	udpUnconnected = "07"
+)
+
+// Inspect enters the network namespace at nsPath and returns its current
+// links, addresses, routes, neighbors and sockets.
+func Inspect(nsPath string, options *Options) (*Info, error) {
+	netns, err := ns.GetNS(nsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer netns.Close()
+
+	info := &Info{}
+	err = netns.Do(func(_ ns.NetNS) error {
+		links, names, err := listLinks()
+		if err != nil {
+			return err
+		}
+		info.Links = links
+		if info.Routes, err = listRoutes(names); err != nil {
+			return err
+		}
+		if info.Neighbors, err = listNeighbors(names); err != nil {
+			return err
+		}
+		if info.Sockets, err = listSockets(); err != nil {
+			return err
+		}
+		if options != nil && options.Firewall {
+			info.Firewall = firewallCounters()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("inspect netns %s: %w", nsPath, err)
+	}
+	return info, nil
+}
+
+// listLinks returns all links and a map from link index to name.
+func listLinks() ([]Link, map[int]string, error) {
+	nlLinks, err := netlink.LinkList()
+	if err != nil {
+		return nil, nil, fmt.Errorf("list links: %w", err)
+	}
+	links := make([]Link, 0, len(nlLinks))
+	names := make(map[int]string, len(nlLinks))
+	for _, nlLink := range nlLinks {
+		attrs := nlLink.Attrs()
+		link := Link{
+			Index: attrs.Index,
+			Name:  attrs.Name,
+			Type:  nlLink.Type(),
+			MTU:   attrs.MTU,
+			State: attrs.OperState.String(),
+		}
+		if len(attrs.HardwareAddr) > 0 {
+			link.MAC = attrs.HardwareAddr.String()
+		}
+		if stats := attrs.Statistics; stats != nil {
+			link.RxBytes = stats.RxBytes
+			link.TxBytes = stats.TxBytes
+			link.RxPackets = stats.RxPackets
+			link.TxPackets = stats.TxPackets
+			link.RxDropped = stats.RxDropped
+			link.TxDropped = stats.TxDropped
+		}
+		addrs, err := netlink.AddrList(nlLink, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("list addresses of %s: %w", attrs.Name, err)
+		}
+		for _, addr := range addrs {
+			link.Addresses = append(link.Addresses, addr.IPNet.String())
+		}
+		links = append(links, link)
+		names[attrs.Index] = attrs.Name
+	}
+	return links, names, nil
+}
+
+func listRoutes(names map[int]string) ([]Route, error) {
+	// RT_TABLE_UNSPEC with the table filter returns the routes of all tables
+	nlRoutes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	routes := make([]Route, 0, len(nlRoutes))
+	for _, nlRoute := range nlRoutes {
+		route := Route{
+			Destination: "default",
+			Interface:   names[nlRoute.LinkIndex],
+			Table:       nlRoute.Table,
+			Metric:      nlRoute.Priority,
+			Scope:       nlRoute.Scope.String(),
+		}
+		if nlRoute.Dst != nil {
+			route.Destination = nlRoute.Dst.String()
+		}
+		if nlRoute.Gw != nil {
+			route.Gateway = nlRoute.Gw.String()
+		}
+		if nlRoute.Src != nil {
+			route.Source = nlRoute.Src.String()
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func listNeighbors(names map[int]string) ([]Neighbor, error) {
+	nlNeighs, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("list neighbors: %w", err)
+	}
+	neighs := make([]Neighbor, 0, len(nlNeighs))
+	for _, nlNeigh := range nlNeighs {
+		neigh := Neighbor{
+			IP:        nlNeigh.IP.String(),
+			Interface: names[nlNeigh.LinkIndex],
+			State:     neighState(nlNeigh.State),
+		}
+		if len(nlNeigh.HardwareAddr) > 0 {
+			neigh.MAC = nlNeigh.HardwareAddr.String()
+		}
+		neighs = append(neighs, neigh)
+	}
+	return neighs, nil
+}
+
+func neighState(state int) string {
+	switch state {
+	case netlink.NUD_INCOMPLETE:
+		return "incomplete"
+	case netlink.NUD_REACHABLE:
+		return "reachable"
+	case netlink.NUD_STALE:
+		return "stale"
+	case netlink.NUD_DELAY:
+		return "delay"
+	case netlink.NUD_PROBE:
+		return "probe"
+	case netlink.NUD_FAILED:
+		return "failed"
+	case netlink.NUD_NOARP:
+		return "noarp"
+	case netlink.NUD_PERMANENT:
+		return "permanent"
+	}
+	return "none"
+}
+
+// listSockets reads the sockets of the current thread netns from procfs.
+func listSockets() ([]Socket, error) {
+	var sockets []Socket
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		f, err := os.Open("/proc/thread-self/net/" + proto)
+		if err != nil {
+			// ipv6 may be disabled
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		s, err := parseProcNet(f, proto)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/net/%s: %w", proto, err)
+		}
+		sockets = append(sockets, s...)
+	}
+	return sockets, nil
+}
+
+// parseProcNet parses the /proc/net/{tcp,udp}{,6} format and returns the
+// listening tcp and unconnected udp sockets.
+func parseProcNet(r io.Reader, proto string) ([]Socket, error) {
+	state := tcpListen
+	if strings.HasPrefix(proto, "udp") {
+		state = udpUnconnected
+	}
+	var sockets []Socket
+	scanner := bufio.NewScanner(r)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if fields[3] != state {
+			continue
+		}
+		addr, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid local address %q", fields[1])
+		}
+		ip, err := parseProcNetIP(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", portHex, err)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inode %q: %w", fields[9], err)
+		}
+		sockets = append(sockets, Socket{
+			Protocol: proto,
+			Address:  ip.String(),
+			Port:     uint16(port),
+			Inode:    inode,
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcNetIP parses an ip from procfs, it is stored as 32 bit words in
+// host byte order.
+func parseProcNetIP(s string) (net.IP, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, fmt.Errorf("invalid ip address %q", s)
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.NativeEndian.Uint32(b[i:]))
+	}
+	return ip, nil
+}
+
+// firewallCounters returns the counters of all nftables and iptables rules,
+// errors are only logged as the binaries are optional.
+func firewallCounters() []FirewallCounter {
+	var counters []FirewallCounter
+	if out, err := runFirewallCmd("nft", "--json", "list", "ruleset"); err == nil {
+		c, err := parseNftJSON(out)
+		if err != nil {
+			logrus.Debugf("Failed to parse nft ruleset: %v", err)
+		}
+		counters = append(counters, c...)
+	}
+	for _, backend := range []string{"iptables", "ip6tables"} {
+		if out, err := runFirewallCmd(backend+"-save", "-c"); err == nil {
+			counters = append(counters, parseIptablesSave(out, backend)...)
+		}
+	}
+	return counters
+}
+
+func runFirewallCmd(name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		logrus.Debugf("Failed to run %s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+		return nil, err
+	}
+	return out, nil
+}
+
+type nftRuleset struct {
+	Nftables []struct {
+		Rule *struct {
+			Family  string            `json:"family"`
+			Table   string            `json:"table"`
+			Chain   string            `json:"chain"`
+			Handle  int               `json:"handle"`
+			Comment string            `json:"comment"`
+			Expr    []json.RawMessage `json:"expr"`
+		} `json:"rule"`
+	} `json:"nftables"`
+}
+
+// parseNftJSON returns the counters of all rules with a counter statement in
+// the output of nft --json list ruleset.
+func parseNftJSON(data []byte) ([]FirewallCounter, error) {
+	var ruleset nftRuleset
+	if err := json.Unmarshal(data, &ruleset); err != nil {
+		return nil, err
+	}
+	var counters []FirewallCounter
+	for _, entry := range ruleset.Nftables {
+		rule := entry.Rule
+		if rule == nil {
+			continue
+		}
+		for _, expr := range rule.Expr {
+			var e struct {
+				Counter *struct {
+					Packets uint64 `json:"packets"`
+					Bytes   uint64 `json:"bytes"`
+				} `json:"counter"`
+			}
+			// expressions have different types, only counters are of interest
+			if json.Unmarshal(expr, &e) != nil || e.Counter == nil {
+				continue
+			}
+			name := "handle " + strconv.Itoa(rule.Handle)
+			if rule.Comment != "" {
+				name += " " + rule.Comment
+			}
+			counters = append(counters, FirewallCounter{
+				Backend: "nftables",
+				Family:  rule.Family,
+				Table:   rule.Table,
+				Chain:   rule.Chain,
+				Rule:    name,
+				Packets: e.Counter.Packets,
+				Bytes:   e.Counter.Bytes,
+			})
+		}
+	}
+	return counters, nil
+}
+
+// parseIptablesSave parses the output of iptables-save -c.
+func parseIptablesSave(data []byte, backend string) []FirewallCounter {
+	var counters []FirewallCounter
+	table := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = strings.TrimPrefix(line, "*")
+		case strings.HasPrefix(line, "["):
+			counter, rule, ok := strings.Cut(line, "] ")
+			if !ok {
+				continue
+			}
+			packets, bytes, ok := strings.Cut(strings.TrimPrefix(counter, "["), ":")
+			if !ok {
+				continue
+			}
+			fields := strings.Fields(rule)
+			if len(fields) < 2 || fields[0] != "-A" {
+				continue
+			}
+			p, err1 := strconv.ParseUint(packets, 10, 64)
+			b, err2 := strconv.ParseUint(bytes, 10, 64)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			counters = append(counters, FirewallCounter{
+				Backend: backend,
+				Table:   table,
+				Chain:   fields[1],
+				Rule:    rule,
+				Packets: p,
+				Bytes:   b,
+			})
+		}
+	}
+	return counters
+}
@@ -0,0 +1,93 @@
+package netnsinfo
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/containers/common/pkg/netns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcNet(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 12345 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:A2C4 0100007F:0277 01 00000000:00000000 00:00000000 00000000  1000        0 23456 1 0000000000000000 20 4 30 10 -1
+`
+	sockets, err := parseProcNet(strings.NewReader(tcp), "tcp")
+	require.NoError(t, err)
+	assert.Equal(t, []Socket{{Protocol: "tcp", Address: "127.0.0.1", Port: 631, Inode: 12345}}, sockets)
+
+	udp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000000000000000000001000000:0035 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 34567 2 0000000000000000 0
+`
+	sockets, err = parseProcNet(strings.NewReader(udp6), "udp6")
+	require.NoError(t, err)
+	assert.Equal(t, []Socket{{Protocol: "udp6", Address: "::1", Port: 53, Inode: 34567}}, sockets)
+
+	_, err = parseProcNet(strings.NewReader("header\n 0: XYZ:0035 0:0 0A 0 0 0 0 0 1\n"), "tcp")
+	require.Error(t, err)
+}
+
+func TestParseNftJSON(t *testing.T) {
+	data := `{"nftables": [
+		{"metainfo": {"version": "1.0.9"}},
+		{"table": {"family": "inet", "name": "netavark", "handle": 1}},
+		{"rule": {"family": "inet", "table": "netavark", "chain": "FORWARD", "handle": 4, "comment": "accept", "expr": [
+			{"match": {"op": "==", "left": {"meta": {"key": "iifname"}}, "right": "podman0"}},
+			{"counter": {"packets": 10, "bytes": 840}},
+			{"accept": null}
+		]}},
+		{"rule": {"family": "ip", "table": "nat", "chain": "POSTROUTING", "handle": 7, "expr": [{"masquerade": null}]}}
+	]}`
+	counters, err := parseNftJSON([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, []FirewallCounter{{
+		Backend: "nftables",
+		Family:  "inet",
+		Table:   "netavark",
+		Chain:   "FORWARD",
+		Rule:    "handle 4 accept",
+		Packets: 10,
+		Bytes:   840,
+	}}, counters)
+}
+
+func TestParseIptablesSave(t *testing.T) {
+	data := `# Generated by iptables-save
+*nat
+:POSTROUTING ACCEPT [5:300]
+[3:180] -A POSTROUTING -s 10.88.0.0/16 -j MASQUERADE
+COMMIT
+*filter
+[0:0] -A FORWARD -i podman0 -j ACCEPT
+COMMIT
+`
+	counters := parseIptablesSave([]byte(data), "iptables")
+	assert.Equal(t, []FirewallCounter{
+		{Backend: "iptables", Table: "nat", Chain: "POSTROUTING", Rule: "-A POSTROUTING -s 10.88.0.0/16 -j MASQUERADE", Packets: 3, Bytes: 180},
+		{Backend: "iptables", Table: "filter", Chain: "FORWARD", Rule: "-A FORWARD -i podman0 -j ACCEPT", Packets: 0, Bytes: 0},
+	}, counters)
+}
+
+func TestInspect(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+	netNS, err := netns.NewNS()
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, netns.UnmountNS(netNS.Path()))
+		netNS.Close()
+	}()
+
+	info, err := Inspect(netNS.Path(), &Options{Firewall: true})
+	require.NoError(t, err)
+	require.Len(t, info.Links, 1)
+	assert.Equal(t, "lo", info.Links[0].Name)
+	assert.Empty(t, info.Sockets)
+
+	_, err = Inspect("/non/existing/netns", nil)
+	require.Error(t, err)
+}
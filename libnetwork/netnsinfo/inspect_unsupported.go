@@ -0,0 +1,10 @@
+//go:build !linux
+
+package netnsinfo
+
+import "errors"
+
+// Inspect is only supported on linux.
+func Inspect(_ string, _ *Options) (*Info, error) {
+	return nil, errors.New("network namespace inspection is only supported on linux")
+}
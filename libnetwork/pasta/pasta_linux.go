@@ -16,9 +16,12 @@ import (
 	"net"
 	"os/exec"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ns"
+	internalutil "github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/portregistry"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/libnetwork/util"
 	"github.com/containers/common/pkg/config"
@@ -41,20 +44,30 @@ const (
 type SetupOptions struct {
 	// Config used to get pasta options and binary path via HelperBinariesDir
 	Config *config.Config
+	// ContainerID is the ID of the container, it is required when
+	// PortRegistry is set.
+	ContainerID string
 	// Netns is the path to the container Netns
 	Netns string
 	// Ports that should be forwarded in the container
 	Ports []types.PortMapping
+	// PortRegistry reserves the host ports of Ports for ContainerID to
+	// detect conflicts with other containers, optional. The caller must
+	// release them with Registry.Release once the container stopped.
+	PortRegistry *portregistry.Registry
 	// ExtraOptions are pasta(1) cli options, these will be appended after the
 	// pasta options from containers.conf to allow some form of overwrite.
 	ExtraOptions []string
+	// Pasta are typed pasta(1) options, the set fields overwrite the pasta
+	// table from containers.conf.
+	Pasta *config.PastaConfig
 }
 
 // Setup start the pasta process for the given netns.
 // The pasta binary is looked up in the HelperBinariesDir and $PATH.
 // Note that there is no need for any special cleanup logic, the pasta
 // process will automatically exit when the netns path is deleted.
-func Setup(opts *SetupOptions) (*SetupResult, error) {
+func Setup(opts *SetupOptions) (_ *SetupResult, retErr error) {
 	path, err := opts.Config.FindHelperBinary(BinaryName, true)
 	if err != nil {
 		return nil, fmt.Errorf("could not find pasta, the network namespace can't be configured: %w", err)
@@ -65,6 +78,15 @@ func Setup(opts *SetupOptions) (*SetupResult, error) {
 		return nil, err
 	}
 
+	if err := internalutil.ReservePorts(opts.PortRegistry, opts.ContainerID, opts.Ports, nil); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			internalutil.ReleasePorts(opts.PortRegistry, opts.ContainerID, nil)
+		}
+	}()
+
 	logrus.Debugf("pasta arguments: %s", strings.Join(cmdArgs, " "))
 
 	for {
@@ -169,11 +191,19 @@ func createPastaArgs(opts *SetupOptions) ([]string, []string, []string, error) {
 	noMapGW := true
 	quiet := true
 
+	pastaConfig := mergePastaConfig(&opts.Config.Network.Pasta, opts.Pasta)
+	if err := pastaConfig.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// the options set in the config followed by the ones set on the cli
+	rawArgs := append(slices.Clone(opts.Config.Network.PastaOptions.Get()), opts.ExtraOptions...)
+
 	cmdArgs := []string{"--config-net"}
-	// first append options set in the config
-	cmdArgs = append(cmdArgs, opts.Config.Network.PastaOptions.Get()...)
-	// then append the ones that were set on the cli
-	cmdArgs = append(cmdArgs, opts.ExtraOptions...)
+	// first append the typed options which are not set by the raw options
+	// or by the port mappings
+	cmdArgs = append(cmdArgs, typedPastaArgs(pastaConfig, rawArgs, opts.Ports)...)
+	cmdArgs = append(cmdArgs, rawArgs...)
 
 	cmdArgs = slices.DeleteFunc(cmdArgs, func(s string) bool {
 		// --map-gw is not a real pasta(1) option so we must remove it
@@ -188,6 +218,14 @@ func createPastaArgs(opts *SetupOptions) ([]string, []string, []string, error) {
 	var dnsForwardIPs []string
 	var mapGuestAddrIPs []string
 	for i, opt := range cmdArgs {
+		// long options may have their value after "="
+		value, hasValue := "", false
+		if strings.HasPrefix(opt, "--") {
+			opt, value, hasValue = strings.Cut(opt, "=")
+		}
+		if !hasValue && len(cmdArgs) > i+1 {
+			value = cmdArgs[i+1]
+		}
 		switch opt {
 		case "-t", "--tcp-ports":
 			noTCPInitPorts = false
@@ -197,16 +235,18 @@ func createPastaArgs(opts *SetupOptions) ([]string, []string, []string, error) {
 			noTCPNamespacePorts = false
 		case "-U", "--udp-ns":
 			noUDPNamespacePorts = false
+		case "--no-map-gw":
+			noMapGW = false
 		case "-d", "--debug", "--trace":
 			quiet = false
 		case dnsForwardOpt:
-			// if there is no arg after it pasta will likely error out anyway due invalid cli args
-			if len(cmdArgs) > i+1 {
-				dnsForwardIPs = append(dnsForwardIPs, cmdArgs[i+1])
+			// if there is no value pasta will likely error out anyway due invalid cli args
+			if value != "" {
+				dnsForwardIPs = append(dnsForwardIPs, value)
 			}
 		case mapGuestAddrOpt:
-			if len(cmdArgs) > i+1 {
-				mapGuestAddrIPs = append(mapGuestAddrIPs, cmdArgs[i+1])
+			if value != "" {
+				mapGuestAddrIPs = append(mapGuestAddrIPs, value)
 			}
 		}
 	}
@@ -278,3 +318,176 @@ func createPastaArgs(opts *SetupOptions) ([]string, []string, []string, error) {
 
 	return cmdArgs, dnsForwardIPs, mapGuestAddrIPs, nil
 }
+
+// mergePastaConfig returns the pasta config from containers.conf with the
+// set fields of override applied.
+func mergePastaConfig(base, override *config.PastaConfig) *config.PastaConfig {
+	merged := *base
+	if override == nil {
+		return &merged
+	}
+	for _, f := range []struct{ dst, src *[]string }{
+		{&merged.TCPPorts, &override.TCPPorts},
+		{&merged.UDPPorts, &override.UDPPorts},
+		{&merged.TCPNamespacePorts, &override.TCPNamespacePorts},
+		{&merged.UDPNamespacePorts, &override.UDPNamespacePorts},
+		{&merged.DNSForward, &override.DNSForward},
+		{&merged.MapGuestAddress, &override.MapGuestAddress},
+	} {
+		if len(*f.src) > 0 {
+			*f.dst = *f.src
+		}
+	}
+	for _, f := range []struct{ dst, src *string }{
+		{&merged.Interface, &override.Interface},
+		{&merged.OutboundInterface, &override.OutboundInterface},
+		{&merged.NamespaceInterface, &override.NamespaceInterface},
+		{&merged.DNSHost, &override.DNSHost},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if override.MTU != 0 {
+		merged.MTU = override.MTU
+	}
+	// selecting one ip family overwrites the other one
+	if override.IPv4Only || override.IPv6Only {
+		merged.IPv4Only = override.IPv4Only
+		merged.IPv6Only = override.IPv6Only
+	}
+	merged.MapGateway = merged.MapGateway || override.MapGateway
+	return &merged
+}
+
+// pastaOptionNames maps the pasta(1) options of the typed options to one
+// name per option, the short and long form and options which exclude each
+// other get the same name.
+var pastaOptionNames = map[string]string{
+	"-t":             "--tcp-ports",
+	"--tcp-ports":    "--tcp-ports",
+	"-u":             "--udp-ports",
+	"--udp-ports":    "--udp-ports",
+	"-T":             "--tcp-ns",
+	"--tcp-ns":       "--tcp-ns",
+	"-U":             "--udp-ns",
+	"--udp-ns":       "--udp-ns",
+	"-4":             "ip-family",
+	"--ipv4-only":    "ip-family",
+	"-6":             "ip-family",
+	"--ipv6-only":    "ip-family",
+	"-i":             "--interface",
+	"--interface":    "--interface",
+	"--outbound-if4": "--outbound-if4",
+	"--outbound-if6": "--outbound-if6",
+	"-I":             "--ns-ifname",
+	"--ns-ifname":    "--ns-ifname",
+	"-m":             "--mtu",
+	"--mtu":          "--mtu",
+	dnsForwardOpt:    dnsForwardOpt,
+	"--dns-host":     "--dns-host",
+	mapGuestAddrOpt:  mapGuestAddrOpt,
+	"--map-gw":       "map-gw",
+	"--no-map-gw":    "map-gw",
+}
+
+// pastaOptionName returns the name of the option in pastaOptionNames, the
+// value of a long option may be given after "=".
+func pastaOptionName(arg string) string {
+	if strings.HasPrefix(arg, "--") {
+		arg, _, _ = strings.Cut(arg, "=")
+	}
+	return pastaOptionNames[arg]
+}
+
+// typedPastaArgs returns the arguments of the typed options without the
+// options which are set in rawArgs, the raw options overwrite the typed
+// ones. The none and auto port modes are dropped for a protocol with
+// explicit port mappings, pasta does not accept them together with ports.
+func typedPastaArgs(c *config.PastaConfig, rawArgs []string, ports []types.PortMapping) []string {
+	overridden := make(map[string]bool)
+	for _, arg := range rawArgs {
+		if name := pastaOptionName(arg); name != "" {
+			overridden[name] = true
+		}
+	}
+	for _, port := range ports {
+		for _, protocol := range strings.Split(port.Protocol, ",") {
+			switch protocol {
+			case "tcp":
+				overridden["tcp-mode"] = true
+			case "udp":
+				overridden["udp-mode"] = true
+			}
+		}
+	}
+	var args []string
+	for _, arg := range pastaConfigArgs(c) {
+		name := pastaOptionNames[arg[0]]
+		if overridden[name] {
+			continue
+		}
+		if len(arg) > 1 && (arg[1] == config.PastaPortsNone || arg[1] == config.PastaPortsAuto) &&
+			(name == "--tcp-ports" && overridden["tcp-mode"] || name == "--udp-ports" && overridden["udp-mode"]) {
+			continue
+		}
+		args = append(args, arg...)
+	}
+	return args
+}
+
+// pastaConfigArgs translates the typed options to pasta(1) arguments, the
+// arguments are always in the same order. Each element is an option
+// followed by its value if it has one.
+func pastaConfigArgs(c *config.PastaConfig) [][]string {
+	var args [][]string
+	if c.IPv4Only {
+		args = append(args, []string{"--ipv4-only"})
+	}
+	if c.IPv6Only {
+		args = append(args, []string{"--ipv6-only"})
+	}
+	if c.Interface != "" {
+		args = append(args, []string{"--interface", c.Interface})
+	}
+	if c.OutboundInterface != "" {
+		if !c.IPv6Only {
+			args = append(args, []string{"--outbound-if4", c.OutboundInterface})
+		}
+		if !c.IPv4Only {
+			args = append(args, []string{"--outbound-if6", c.OutboundInterface})
+		}
+	}
+	if c.NamespaceInterface != "" {
+		args = append(args, []string{"--ns-ifname", c.NamespaceInterface})
+	}
+	if c.MTU != 0 {
+		args = append(args, []string{"--mtu", strconv.Itoa(c.MTU)})
+	}
+	for _, ip := range c.DNSForward {
+		args = append(args, []string{dnsForwardOpt, ip})
+	}
+	if c.DNSHost != "" {
+		args = append(args, []string{"--dns-host", c.DNSHost})
+	}
+	for _, ip := range c.MapGuestAddress {
+		args = append(args, []string{mapGuestAddrOpt, ip})
+	}
+	if c.MapGateway {
+		args = append(args, []string{"--map-gw"})
+	}
+	for _, ports := range []struct {
+		opt   string
+		specs []string
+	}{
+		{"-t", c.TCPPorts},
+		{"-u", c.UDPPorts},
+		{"-T", c.TCPNamespacePorts},
+		{"-U", c.UDPNamespacePorts},
+	} {
+		for _, spec := range ports.specs {
+			args = append(args, []string{ports.opt, spec})
+		}
+	}
+	return args
+}
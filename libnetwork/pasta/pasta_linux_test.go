@@ -1,12 +1,16 @@
 package pasta
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/containers/common/internal/attributedstring"
+	"github.com/containers/common/libnetwork/portregistry"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/pkg/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func makeSetupOptions(configArgs, extraArgs []string, ports []types.PortMapping) *SetupOptions {
@@ -18,6 +22,12 @@ func makeSetupOptions(configArgs, extraArgs []string, ports []types.PortMapping)
 	}
 }
 
+func withPastaConfig(opts *SetupOptions, conf, setup *config.PastaConfig) *SetupOptions {
+	opts.Config.Network.Pasta = *conf
+	opts.Pasta = setup
+	return opts
+}
+
 func Test_createPastaArgs(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -329,6 +339,87 @@ func Test_createPastaArgs(t *testing.T) {
 			wantDnsForward:   []string{dnsForwardIpv4},
 			wantMapGuestAddr: []string{"192.168.255.255", "::1"},
 		},
+		{
+			name: "typed options",
+			input: withPastaConfig(makeSetupOptions(nil, nil, nil), &config.PastaConfig{
+				TCPPorts:           []string{"8080", "127.0.0.1/443:8443"},
+				UDPNamespacePorts:  []string{config.PastaPortsAuto},
+				Interface:          "eth0",
+				OutboundInterface:  "wlan0",
+				NamespaceInterface: "enp1s0",
+				MTU:                1500,
+				IPv4Only:           true,
+				DNSForward:         []string{"10.0.2.3"},
+				DNSHost:            "192.168.1.1",
+				MapGateway:         true,
+			}, nil),
+			wantArgs: []string{
+				"--config-net", "--ipv4-only", "--interface", "eth0", "--outbound-if4", "wlan0",
+				"--ns-ifname", "enp1s0", "--mtu", "1500", dnsForwardOpt, "10.0.2.3",
+				"--dns-host", "192.168.1.1", "-t", "8080", "-t", "127.0.0.1/443:8443",
+				"-U", "auto", "-u", "none", "-T", "none", "--quiet", "--netns", "netns123",
+				mapGuestAddrOpt, mapGuestAddrIpv4,
+			},
+			wantDnsForward:   []string{"10.0.2.3"},
+			wantMapGuestAddr: []string{mapGuestAddrIpv4},
+		},
+		{
+			name: "typed setup options overwrite config",
+			input: withPastaConfig(makeSetupOptions(nil, []string{"--mtu", "9000"}, nil), &config.PastaConfig{
+				TCPPorts: []string{config.PastaPortsAll},
+				MTU:      1500,
+				IPv6Only: true,
+			}, &config.PastaConfig{
+				TCPPorts:        []string{config.PastaPortsAuto},
+				IPv4Only:        true,
+				MapGuestAddress: []string{"10.0.2.2"},
+			}),
+			wantArgs: []string{
+				"--config-net", "--ipv4-only", mapGuestAddrOpt, "10.0.2.2",
+				"-t", "auto", "--mtu", "9000", dnsForwardOpt, dnsForwardIpv4, "-u", "none",
+				"-T", "none", "-U", "none", "--no-map-gw", "--quiet", "--netns", "netns123",
+			},
+			wantDnsForward:   []string{dnsForwardIpv4},
+			wantMapGuestAddr: []string{"10.0.2.2"},
+		},
+		{
+			name: "typed port mode with port mappings",
+			input: withPastaConfig(makeSetupOptions(nil, nil,
+				[]types.PortMapping{{HostPort: 80, ContainerPort: 80, Protocol: "tcp", Range: 1}},
+			), &config.PastaConfig{
+				TCPPorts: []string{config.PastaPortsNone},
+				UDPPorts: []string{config.PastaPortsAuto},
+			}, nil),
+			wantArgs: []string{
+				"--config-net", "-u", "auto", "-t", "80-80:80-80", dnsForwardOpt, dnsForwardIpv4,
+				"-T", "none", "-U", "none", "--no-map-gw", "--quiet", "--netns", "netns123",
+				mapGuestAddrOpt, mapGuestAddrIpv4,
+			},
+			wantDnsForward:   []string{dnsForwardIpv4},
+			wantMapGuestAddr: []string{mapGuestAddrIpv4},
+		},
+		{
+			name: "raw options overwrite typed list options",
+			input: withPastaConfig(makeSetupOptions([]string{"--dns-forward=10.0.2.4", "-I", "eth1"}, nil, nil), &config.PastaConfig{
+				DNSForward:         []string{"10.0.2.3", "10.0.2.5"},
+				NamespaceInterface: "enp1s0",
+				MTU:                1500,
+			}, nil),
+			wantArgs: []string{
+				"--config-net", "--mtu", "1500", "--dns-forward=10.0.2.4", "-I", "eth1",
+				"-t", "none", "-u", "none", "-T", "none", "-U", "none", "--no-map-gw", "--quiet",
+				"--netns", "netns123", mapGuestAddrOpt, mapGuestAddrIpv4,
+			},
+			wantDnsForward:   []string{"10.0.2.4"},
+			wantMapGuestAddr: []string{mapGuestAddrIpv4},
+		},
+		{
+			name: "invalid typed options",
+			input: withPastaConfig(makeSetupOptions(nil, nil, nil), &config.PastaConfig{
+				TCPNamespacePorts: []string{config.PastaPortsAll},
+			}, nil),
+			wantErr: `invalid pasta tcp_namespace_ports: mode "all" is not supported`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -344,3 +435,27 @@ func Test_createPastaArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestSetupReservesPorts(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, BinaryName), []byte("#!/bin/sh\nexit 1\n"), 0o755))
+	registry, err := portregistry.New(filepath.Join(dir, "ports"))
+	require.NoError(t, err)
+	_, err = registry.Reserve("ctr1", []types.PortMapping{{HostPort: 8080, ContainerPort: 80}}, nil)
+	require.NoError(t, err)
+
+	opts := makeSetupOptions(nil, nil, []types.PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}})
+	opts.Config.Engine.HelperBinariesDir = attributedstring.NewSlice([]string{dir})
+	opts.ContainerID = "ctr2"
+	opts.PortRegistry = registry
+	_, err = Setup(opts)
+	assert.ErrorIs(t, err, portregistry.ErrPortInUse)
+
+	// the ports are released again when pasta fails
+	require.NoError(t, registry.Release("ctr1"))
+	_, err = Setup(opts)
+	assert.ErrorContains(t, err, "pasta failed with exit code 1")
+	mappings, err := registry.Mappings()
+	require.NoError(t, err)
+	assert.Empty(t, mappings)
+}
@@ -0,0 +1,129 @@
+// Package portforward adds and removes the port forwards of running rootless
+// containers. The ports given at Setup are passed to the port handler on its
+// command line or stdin, this package talks to the api of the running
+// handler instead so a port can be exposed without restarting the container.
+//
+// Only the slirp4netns api socket, used with the slirp4netns
+// port_handler=slirp4netns option, can change the ports of a running
+// container. pasta only reads the ports from its command line, start the
+// container with the "-t auto" and "-u auto" pasta options instead so pasta
+// forwards the ports the container binds while it runs. rootlessport, the
+// default port handler of slirp4netns and rootless bridge networks, has no
+// api to change its ports.
+package portforward
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containers/common/libnetwork/portregistry"
+	"github.com/containers/common/libnetwork/slirp4netns"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/pkg/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotSupported is returned when the container does not use the
+// slirp4netns port handler, its ports cannot be changed while it runs.
+var ErrNotSupported = errors.New("changing the ports of a running container is only supported with the slirp4netns port handler")
+
+// Options are the options for Add and Remove.
+type Options struct {
+	// Config is used to find the api sockets in the engine tmp dir.
+	Config *config.Config
+	// ContainerID of the running container.
+	ContainerID string
+	// PortRegistry records the added ports of the container to detect
+	// conflicts with other containers, optional. It should be the
+	// registry the container was set up with.
+	PortRegistry *portregistry.Registry
+}
+
+// Add forwards the ports to the running container.
+func Add(opts *Options, ports []types.PortMapping) error {
+	ports, err := checkRequest(opts, ports)
+	if err != nil || len(ports) == 0 {
+		return err
+	}
+	if opts.PortRegistry != nil {
+		if _, err := opts.PortRegistry.Reserve(opts.ContainerID, ports, &portregistry.ReserveOptions{Append: true}); err != nil {
+			return err
+		}
+	}
+	if err := slirp4netns.AddPortMappings(opts.Config, opts.ContainerID, ports); err != nil {
+		releaseMappings(opts, ports)
+		return err
+	}
+	return nil
+}
+
+// Remove stops forwarding the ports to the running container.
+func Remove(opts *Options, ports []types.PortMapping) error {
+	ports, err := checkRequest(opts, ports)
+	if err != nil || len(ports) == 0 {
+		return err
+	}
+	if err := slirp4netns.RemovePortMappings(opts.Config, opts.ContainerID, ports); err != nil {
+		return err
+	}
+	releaseMappings(opts, ports)
+	return nil
+}
+
+// checkRequest returns the normalized ports, it returns ErrNotSupported
+// when the container has no slirp4netns api socket.
+func checkRequest(opts *Options, ports []types.PortMapping) ([]types.PortMapping, error) {
+	if opts.ContainerID == "" {
+		return nil, fmt.Errorf("container ID must be set: %w", types.ErrInvalidArg)
+	}
+	ports, err := normalizePorts(ports)
+	if err != nil || len(ports) == 0 {
+		return nil, err
+	}
+	if _, err := os.Stat(slirp4netns.APISocketPath(opts.Config, opts.ContainerID)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("container %s: %w", opts.ContainerID, ErrNotSupported)
+		}
+		return nil, err
+	}
+	return ports, nil
+}
+
+// releaseMappings removes the ports from the registry, errors are only
+// logged as the forwards were already changed.
+func releaseMappings(opts *Options, ports []types.PortMapping) {
+	if opts.PortRegistry == nil {
+		return
+	}
+	if err := opts.PortRegistry.ReleaseMappings(opts.ContainerID, ports); err != nil {
+		logrus.Errorf("Failed to release the ports of container %s: %v", opts.ContainerID, err)
+	}
+}
+
+// normalizePorts validates the ports and sets the default protocol and range.
+func normalizePorts(ports []types.PortMapping) ([]types.PortMapping, error) {
+	normalized := make([]types.PortMapping, 0, len(ports))
+	for _, port := range ports {
+		if port.HostPort == 0 || port.ContainerPort == 0 {
+			return nil, fmt.Errorf("host and container port must be set: %w", types.ErrInvalidArg)
+		}
+		if port.Protocol == "" {
+			port.Protocol = "tcp"
+		}
+		for _, proto := range strings.Split(port.Protocol, ",") {
+			if proto != "tcp" && proto != "udp" {
+				return nil, fmt.Errorf("can't forward protocol %q: %w", proto, types.ErrInvalidArg)
+			}
+		}
+		if port.Range == 0 {
+			port.Range = 1
+		}
+		if uint32(port.HostPort)+uint32(port.Range) > 65536 || uint32(port.ContainerPort)+uint32(port.Range) > 65536 {
+			return nil, fmt.Errorf("port range exceeds the maximum port: %w", types.ErrInvalidArg)
+		}
+		normalized = append(normalized, port)
+	}
+	return normalized, nil
+}
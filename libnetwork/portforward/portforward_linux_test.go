@@ -0,0 +1,152 @@
+package portforward
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/containers/common/libnetwork/portregistry"
+	"github.com/containers/common/libnetwork/slirp4netns"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOptions(t *testing.T) *Options {
+	conf := &config.Config{}
+	conf.Engine.TmpDir = t.TempDir()
+	return &Options{Config: conf, ContainerID: "ctr"}
+}
+
+// fakeSlirp4netns serves the slirp4netns api socket, it keeps the forwarded
+// ports and records the commands. Forwarding host port 9999 fails.
+func fakeSlirp4netns(t *testing.T, path string) *[]string {
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	var (
+		mu       sync.Mutex
+		commands []string
+		entries  []map[string]any
+		nextID   = 1
+	)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			var cmd struct {
+				Execute string         `json:"execute"`
+				Args    map[string]any `json:"arguments"`
+			}
+			_ = json.Unmarshal(data, &cmd)
+			mu.Lock()
+			resp := map[string]any{"return": map[string]any{}}
+			switch cmd.Execute {
+			case "add_hostfwd":
+				if cmd.Args["host_port"] == float64(9999) {
+					resp = map[string]any{"error": map[string]any{"desc": "bind failed"}}
+					break
+				}
+				cmd.Args["id"] = nextID
+				resp["return"] = map[string]any{"id": nextID}
+				nextID++
+				entries = append(entries, cmd.Args)
+			case "list_hostfwd":
+				resp["return"] = map[string]any{"entries": entries}
+			case "remove_hostfwd":
+				for i, e := range entries {
+					if e["id"] == int(cmd.Args["id"].(float64)) {
+						entries = append(entries[:i], entries[i+1:]...)
+						break
+					}
+				}
+			default:
+				resp = map[string]any{"error": map[string]any{"desc": "bad request"}}
+			}
+			commands = append(commands, string(data))
+			mu.Unlock()
+			_ = json.NewEncoder(conn).Encode(resp)
+			conn.Close()
+		}
+	}()
+	return &commands
+}
+
+func TestSlirp4netns(t *testing.T) {
+	opts := newOptions(t)
+	commands := fakeSlirp4netns(t, slirp4netns.APISocketPath(opts.Config, opts.ContainerID))
+
+	err := Add(opts, []types.PortMapping{{HostPort: 8080, ContainerPort: 80, Range: 2, Protocol: "tcp,udp"}})
+	require.NoError(t, err)
+	require.Len(t, *commands, 4)
+	assert.JSONEq(t, `{"execute":"add_hostfwd","arguments":{"proto":"tcp","host_addr":"0.0.0.0","host_port":8080,"guest_addr":"","guest_port":80}}`, (*commands)[0])
+	assert.JSONEq(t, `{"execute":"add_hostfwd","arguments":{"proto":"udp","host_addr":"0.0.0.0","host_port":8081,"guest_addr":"","guest_port":81}}`, (*commands)[3])
+
+	err = Remove(opts, []types.PortMapping{{HostPort: 8081, ContainerPort: 81, Protocol: "udp"}})
+	require.NoError(t, err)
+	require.Len(t, *commands, 6)
+	assert.JSONEq(t, `{"execute":"list_hostfwd"}`, (*commands)[4])
+	assert.JSONEq(t, `{"execute":"remove_hostfwd","arguments":{"id":4}}`, (*commands)[5])
+}
+
+func TestAddRollback(t *testing.T) {
+	opts := newOptions(t)
+	commands := fakeSlirp4netns(t, slirp4netns.APISocketPath(opts.Config, opts.ContainerID))
+	registry, err := portregistry.New(t.TempDir())
+	require.NoError(t, err)
+	opts.PortRegistry = registry
+
+	err = Add(opts, []types.PortMapping{{HostPort: 9998, ContainerPort: 80, Range: 2}})
+	require.ErrorContains(t, err, "bind failed")
+	require.Len(t, *commands, 3)
+	assert.JSONEq(t, `{"execute":"remove_hostfwd","arguments":{"id":1}}`, (*commands)[2])
+	mappings, err := registry.Mappings()
+	require.NoError(t, err)
+	assert.Empty(t, mappings)
+
+	// the added ports are recorded in the registry
+	err = Add(opts, []types.PortMapping{{HostPort: 8080, ContainerPort: 80}})
+	require.NoError(t, err)
+	owner, err := registry.Owner("", 8080, "tcp")
+	require.NoError(t, err)
+	require.NotNil(t, owner)
+	assert.Equal(t, "ctr", owner.ContainerID)
+	other := &Options{Config: opts.Config, ContainerID: "other", PortRegistry: registry}
+	fakeSlirp4netns(t, slirp4netns.APISocketPath(other.Config, other.ContainerID))
+	err = Add(other, []types.PortMapping{{HostPort: 8080, ContainerPort: 80}})
+	require.ErrorIs(t, err, portregistry.ErrPortInUse)
+
+	err = Remove(opts, []types.PortMapping{{HostPort: 8080, ContainerPort: 80}})
+	require.NoError(t, err)
+	owner, err = registry.Owner("", 8080, "tcp")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+}
+
+func TestInvalidRequests(t *testing.T) {
+	opts := newOptions(t)
+	// without the slirp4netns api socket the handler cannot change ports
+	err := Add(opts, []types.PortMapping{{HostPort: 8080, ContainerPort: 80}})
+	require.ErrorIs(t, err, ErrNotSupported)
+
+	tests := []types.PortMapping{
+		{ContainerPort: 80},
+		{HostPort: 8080, ContainerPort: 80, Protocol: "sctp"},
+		{HostPort: 65535, ContainerPort: 80, Range: 2},
+	}
+	for _, port := range tests {
+		err := Add(opts, []types.PortMapping{port})
+		require.ErrorIs(t, err, types.ErrInvalidArg)
+	}
+
+	opts.ContainerID = ""
+	err = Add(opts, []types.PortMapping{{HostPort: 8080, ContainerPort: 80}})
+	require.ErrorIs(t, err, types.ErrInvalidArg)
+}
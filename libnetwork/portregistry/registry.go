@@ -0,0 +1,397 @@
+// Package portregistry keeps track of the host ports used by the port
+// mappings of all containers. It detects conflicts before the network is set
+// up by one of the network backends, so a conflict is reported with the
+// owning container instead of a bind error from netavark, CNI or
+// rootlessport.
+//
+// The netavark and CNI backends use the registry in the "ports" directory of
+// their run directory, pasta and slirp4netns use the registry given in their
+// SetupOptions. The backends only reserve mappings with a host port, a
+// caller which wants the registry to allocate host ports calls Reserve
+// before the setup and passes the returned mappings on.
+package portregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/containers/storage/pkg/lockfile"
+)
+
+const (
+	registryFile = "ports.json"
+	lockFile     = "ports.lock"
+
+	// DefaultRangeStart and DefaultRangeEnd limit the ports which are
+	// allocated for mappings without a host port, they match the default
+	// linux ephemeral port range.
+	DefaultRangeStart uint16 = 32768
+	DefaultRangeEnd   uint16 = 60999
+)
+
+// ErrPortInUse is returned when a host port is already used by another
+// container.
+var ErrPortInUse = errors.New("port is already in use")
+
+// Registry stores the port mappings per container in a file, it is safe for
+// concurrent use by several processes.
+type Registry struct {
+	path string
+	lock *lockfile.LockFile
+}
+
+// ReserveOptions are the options for Reserve.
+type ReserveOptions struct {
+	// RangeStart and RangeEnd limit the host ports which are allocated for
+	// mappings without a host port, the defaults are DefaultRangeStart and
+	// DefaultRangeEnd.
+	RangeStart uint16
+	RangeEnd   uint16
+	// Networks the mappings are forwarded on. They are added to the
+	// networks of the container, the reservation is kept until all of them
+	// are released with ReleaseNetworks.
+	Networks []string
+	// Append keeps the mappings already reserved by the container and
+	// adds the new ones, by default they are replaced.
+	Append bool
+}
+
+// reservation is the entry of a container in the registry file.
+type reservation struct {
+	Networks []string            `json:"networks,omitempty"`
+	Mappings []types.PortMapping `json:"mappings"`
+}
+
+// PortOwner describes the container which uses a host port.
+type PortOwner struct {
+	// ContainerID of the container using the port.
+	ContainerID string
+	// Mapping is the port mapping which contains the port.
+	Mapping types.PortMapping
+}
+
+// New returns the registry stored in dir, the directory is created when it
+// does not exist. It should be on a tmpfs so the registry is reset on reboot.
+func New(dir string) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := lockfile.GetLockFile(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{path: filepath.Join(dir, registryFile), lock: lock}, nil
+}
+
+// Reserve checks the port mappings of the container for conflicts with the
+// mappings of all other containers and stores them. Mappings with a zero
+// host port get the lowest free host port range of the same size, so the
+// allocation is deterministic. It returns the mappings with all host ports
+// set. Calling Reserve again for a container replaces its mappings unless
+// Append is set.
+func (r *Registry) Reserve(containerID string, mappings []types.PortMapping, options *ReserveOptions) ([]types.PortMapping, error) {
+	if containerID == "" {
+		return nil, fmt.Errorf("container ID must be set: %w", types.ErrInvalidArg)
+	}
+	rangeStart, rangeEnd := DefaultRangeStart, DefaultRangeEnd
+	if options != nil {
+		if options.RangeStart != 0 {
+			rangeStart = options.RangeStart
+		}
+		if options.RangeEnd != 0 {
+			rangeEnd = options.RangeEnd
+		}
+	}
+	if rangeStart > rangeEnd {
+		return nil, fmt.Errorf("invalid port range %d-%d: %w", rangeStart, rangeEnd, types.ErrInvalidArg)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reservations, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	previous := reservations[containerID]
+	delete(reservations, containerID)
+	ports := usedPorts(reservations)
+
+	result := make([]types.PortMapping, len(mappings))
+	var reserved []types.PortMapping
+	if options != nil && options.Append && previous != nil {
+		reserved = slices.Clone(previous.Mappings)
+	}
+	// allocate the fixed ports first so an allocated port cannot take them,
+	// the mappings of the container are checked against each other as well
+	for _, fixed := range []bool{true, false} {
+		for i, mapping := range mappings {
+			if (mapping.HostPort != 0) != fixed {
+				continue
+			}
+			if err := validateMapping(&mapping); err != nil {
+				return nil, err
+			}
+			ports[containerID] = reserved
+			if mapping.HostPort == 0 {
+				if err := allocate(ports, &mapping, rangeStart, rangeEnd); err != nil {
+					return nil, err
+				}
+			} else if owner := findOwner(ports, &mapping); owner != nil {
+				return nil, conflictError(&mapping, owner)
+			}
+			reserved = append(reserved, mapping)
+			result[i] = mapping
+		}
+	}
+
+	if len(reserved) > 0 {
+		res := &reservation{Mappings: reserved}
+		if previous != nil {
+			res.Networks = previous.Networks
+		}
+		if options != nil {
+			for _, name := range options.Networks {
+				if !slices.Contains(res.Networks, name) {
+					res.Networks = append(res.Networks, name)
+				}
+			}
+		}
+		reservations[containerID] = res
+	}
+	if err := r.save(reservations); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Release removes the port mappings of the container.
+func (r *Registry) Release(containerID string) error {
+	return r.ReleaseNetworks(containerID, nil)
+}
+
+// ReleaseNetworks removes the networks from the reservation of the
+// container, the port mappings are removed once no network is left. With no
+// networks given the mappings are always removed.
+func (r *Registry) ReleaseNetworks(containerID string, networks []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reservations, err := r.load()
+	if err != nil {
+		return err
+	}
+	res, ok := reservations[containerID]
+	if !ok {
+		return nil
+	}
+	if len(networks) > 0 {
+		res.Networks = slices.DeleteFunc(res.Networks, func(name string) bool {
+			return slices.Contains(networks, name)
+		})
+	}
+	if len(networks) == 0 || len(res.Networks) == 0 {
+		delete(reservations, containerID)
+	}
+	return r.save(reservations)
+}
+
+// ReleaseMappings removes the port mappings from the reservation of the
+// container, the mappings must be equal to the reserved ones. The
+// reservation is removed once no mapping is left.
+func (r *Registry) ReleaseMappings(containerID string, mappings []types.PortMapping) error {
+	mappings = slices.Clone(mappings)
+	for i := range mappings {
+		if err := validateMapping(&mappings[i]); err != nil {
+			return err
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reservations, err := r.load()
+	if err != nil {
+		return err
+	}
+	res, ok := reservations[containerID]
+	if !ok {
+		return nil
+	}
+	res.Mappings = slices.DeleteFunc(res.Mappings, func(m types.PortMapping) bool {
+		return slices.Contains(mappings, m)
+	})
+	if len(res.Mappings) == 0 {
+		delete(reservations, containerID)
+	}
+	return r.save(reservations)
+}
+
+// Owner returns the container which uses the host port for the protocol on
+// the host ip, an empty host ip means all ips. It returns nil when the port
+// is not used.
+func (r *Registry) Owner(hostIP string, hostPort uint16, protocol string) (*PortOwner, error) {
+	mapping := types.PortMapping{HostIP: hostIP, HostPort: hostPort, Protocol: protocol}
+	if err := validateMapping(&mapping); err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reservations, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return findOwner(usedPorts(reservations), &mapping), nil
+}
+
+// Mappings returns the stored port mappings, the key is the container ID.
+func (r *Registry) Mappings() (map[string][]types.PortMapping, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reservations, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return usedPorts(reservations), nil
+}
+
+func (r *Registry) load() (map[string]*reservation, error) {
+	reservations := map[string]*reservation{}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return reservations, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &reservations); err != nil {
+		return nil, fmt.Errorf("failed to parse port registry %s: %w", r.path, err)
+	}
+	return reservations, nil
+}
+
+func (r *Registry) save(reservations map[string]*reservation) error {
+	data, err := json.Marshal(reservations)
+	if err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(r.path, data, 0o600)
+}
+
+// usedPorts returns the port mappings of the reservations by container ID.
+func usedPorts(reservations map[string]*reservation) map[string][]types.PortMapping {
+	ports := make(map[string][]types.PortMapping, len(reservations))
+	for id, res := range reservations {
+		ports[id] = res.Mappings
+	}
+	return ports
+}
+
+func validateMapping(mapping *types.PortMapping) error {
+	if mapping.HostIP != "" && net.ParseIP(mapping.HostIP) == nil {
+		return fmt.Errorf("invalid host ip %q: %w", mapping.HostIP, types.ErrInvalidArg)
+	}
+	if mapping.Protocol == "" {
+		mapping.Protocol = "tcp"
+	}
+	for _, proto := range strings.Split(mapping.Protocol, ",") {
+		switch proto {
+		case "tcp", "udp", "sctp":
+		default:
+			return fmt.Errorf("unknown port protocol %q: %w", proto, types.ErrInvalidArg)
+		}
+	}
+	if mapping.Range == 0 {
+		mapping.Range = 1
+	}
+	if uint32(mapping.HostPort)+uint32(mapping.Range) > 65536 {
+		return fmt.Errorf("host port %d with range %d exceeds the maximum port: %w", mapping.HostPort, mapping.Range, types.ErrInvalidArg)
+	}
+	return nil
+}
+
+// allocate sets the host port of the mapping to the lowest free range in
+// [start, end].
+func allocate(used map[string][]types.PortMapping, mapping *types.PortMapping, start, end uint16) error {
+	for port := uint32(start); port+uint32(mapping.Range)-1 <= uint32(end); port++ {
+		mapping.HostPort = uint16(port)
+		owner := findOwner(used, mapping)
+		if owner == nil {
+			return nil
+		}
+		// skip behind the conflicting mapping
+		if last := uint32(owner.Mapping.HostPort) + uint32(owner.Mapping.Range) - 1; last > port {
+			port = last
+		}
+	}
+	mapping.HostPort = 0
+	return fmt.Errorf("no free host port range of size %d in %d-%d: %w", mapping.Range, start, end, ErrPortInUse)
+}
+
+// findOwner returns the first mapping in used which conflicts with the
+// mapping, the containers are checked in a stable order.
+func findOwner(used map[string][]types.PortMapping, mapping *types.PortMapping) *PortOwner {
+	ids := make([]string, 0, len(used))
+	for id := range used {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for _, m := range used[id] {
+			if mappingsConflict(&m, mapping) {
+				return &PortOwner{ContainerID: id, Mapping: m}
+			}
+		}
+	}
+	return nil
+}
+
+func mappingsConflict(a, b *types.PortMapping) bool {
+	rangeA, rangeB := max(a.Range, 1), max(b.Range, 1)
+	if uint32(a.HostPort)+uint32(rangeA) <= uint32(b.HostPort) || uint32(b.HostPort)+uint32(rangeB) <= uint32(a.HostPort) {
+		return false
+	}
+	if !protocolsOverlap(a.Protocol, b.Protocol) {
+		return false
+	}
+	return hostIPsOverlap(a.HostIP, b.HostIP)
+}
+
+func protocolsOverlap(a, b string) bool {
+	for _, pa := range strings.Split(a, ",") {
+		for _, pb := range strings.Split(b, ",") {
+			if pa == pb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostIPsOverlap returns true when binding both ips would conflict. An empty
+// ip and "::" bind all addresses, "0.0.0.0" binds all ipv4 addresses.
+func hostIPsOverlap(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil || (ipA.IsUnspecified() && ipA.To4() == nil) || (ipB.IsUnspecified() && ipB.To4() == nil) {
+		return true
+	}
+	isV4A, isV4B := ipA.To4() != nil, ipB.To4() != nil
+	if isV4A != isV4B {
+		return false
+	}
+	return ipA.IsUnspecified() || ipB.IsUnspecified() || ipA.Equal(ipB)
+}
+
+func conflictError(mapping *types.PortMapping, owner *PortOwner) error {
+	hostIP := mapping.HostIP
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
+	}
+	return fmt.Errorf("host port %d/%s on %s conflicts with port %d/%s of container %s: %w",
+		mapping.HostPort, mapping.Protocol, hostIP, owner.Mapping.HostPort, owner.Mapping.Protocol, owner.ContainerID, ErrPortInUse)
+}
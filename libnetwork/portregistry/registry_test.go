@@ -0,0 +1,159 @@
+package portregistry
+
+import (
+	"testing"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveConflicts(t *testing.T) {
+	r, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = r.Reserve("ctr1", []types.PortMapping{
+		{HostPort: 8080, ContainerPort: 80, Range: 10},
+		{HostIP: "127.0.0.1", HostPort: 53, ContainerPort: 53, Protocol: "udp"},
+	}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		mapping  types.PortMapping
+		conflict bool
+	}{
+		{name: "same port", mapping: types.PortMapping{HostPort: 8080}, conflict: true},
+		{name: "inside range", mapping: types.PortMapping{HostPort: 8089}, conflict: true},
+		{name: "overlapping range", mapping: types.PortMapping{HostPort: 8000, Range: 81}, conflict: true},
+		{name: "after range", mapping: types.PortMapping{HostPort: 8090}},
+		{name: "other protocol", mapping: types.PortMapping{HostPort: 8080, Protocol: "udp"}},
+		{name: "protocol list", mapping: types.PortMapping{HostPort: 8080, Protocol: "udp,tcp"}, conflict: true},
+		{name: "specific ip", mapping: types.PortMapping{HostIP: "10.0.0.1", HostPort: 8080}, conflict: true},
+		{name: "ipv6 any", mapping: types.PortMapping{HostIP: "::", HostPort: 8080}, conflict: true},
+		{name: "other ip", mapping: types.PortMapping{HostIP: "127.0.0.2", HostPort: 53, Protocol: "udp"}},
+		{name: "ipv4 any", mapping: types.PortMapping{HostIP: "0.0.0.0", HostPort: 53, Protocol: "udp"}, conflict: true},
+		{name: "ipv6 ip", mapping: types.PortMapping{HostIP: "::1", HostPort: 53, Protocol: "udp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Reserve("ctr2", []types.PortMapping{tt.mapping}, nil)
+			if tt.conflict {
+				require.ErrorIs(t, err, ErrPortInUse)
+				assert.Contains(t, err.Error(), "container ctr1")
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, r.Release("ctr2"))
+		})
+	}
+
+	// mappings of the same container must not conflict either
+	_, err = r.Reserve("ctr2", []types.PortMapping{{HostPort: 9000}, {HostPort: 9000}}, nil)
+	require.ErrorIs(t, err, ErrPortInUse)
+
+	// reserving again replaces the old mappings
+	_, err = r.Reserve("ctr1", []types.PortMapping{{HostPort: 9000}}, nil)
+	require.NoError(t, err)
+	_, err = r.Reserve("ctr2", []types.PortMapping{{HostPort: 8080}}, nil)
+	require.NoError(t, err)
+
+	_, err = r.Reserve("ctr3", []types.PortMapping{{HostPort: 80, Protocol: "icmp"}}, nil)
+	require.ErrorIs(t, err, types.ErrInvalidArg)
+}
+
+func TestReserveAllocate(t *testing.T) {
+	r, err := New(t.TempDir())
+	require.NoError(t, err)
+	options := &ReserveOptions{RangeStart: 10000, RangeEnd: 10009}
+
+	_, err = r.Reserve("ctr1", []types.PortMapping{{HostPort: 10002, ContainerPort: 80}}, nil)
+	require.NoError(t, err)
+
+	// the fixed port is reserved before the ranges are allocated
+	mappings, err := r.Reserve("ctr2", []types.PortMapping{
+		{ContainerPort: 80, Range: 2},
+		{ContainerPort: 90, Range: 3},
+		{HostPort: 10000, ContainerPort: 100},
+	}, options)
+	require.NoError(t, err)
+	require.Len(t, mappings, 3)
+	assert.Equal(t, uint16(10003), mappings[0].HostPort)
+	assert.Equal(t, uint16(10005), mappings[1].HostPort)
+	assert.Equal(t, uint16(3), mappings[1].Range)
+	assert.Equal(t, uint16(10000), mappings[2].HostPort)
+
+	_, err = r.Reserve("ctr3", []types.PortMapping{{ContainerPort: 80, Range: 3}}, options)
+	require.ErrorIs(t, err, ErrPortInUse)
+	mappings, err = r.Reserve("ctr3", []types.PortMapping{{ContainerPort: 80, Range: 2}}, options)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(10008), mappings[0].HostPort)
+
+	owner, err := r.Owner("", 10006, "tcp")
+	require.NoError(t, err)
+	require.NotNil(t, owner)
+	assert.Equal(t, "ctr2", owner.ContainerID)
+	assert.Equal(t, uint16(90), owner.Mapping.ContainerPort)
+
+	require.NoError(t, r.Release("ctr2"))
+	owner, err = r.Owner("", 10006, "tcp")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+
+	all, err := r.Mappings()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+func TestReleaseNetworks(t *testing.T) {
+	r, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	mappings := []types.PortMapping{{HostPort: 8080, ContainerPort: 80}}
+	_, err = r.Reserve("ctr1", mappings, &ReserveOptions{Networks: []string{"net1"}})
+	require.NoError(t, err)
+	// connecting another network keeps the reservation of the first one
+	_, err = r.Reserve("ctr1", mappings, &ReserveOptions{Networks: []string{"net2"}})
+	require.NoError(t, err)
+
+	require.NoError(t, r.ReleaseNetworks("ctr1", []string{"net1"}))
+	owner, err := r.Owner("", 8080, "tcp")
+	require.NoError(t, err)
+	require.NotNil(t, owner)
+	assert.Equal(t, "ctr1", owner.ContainerID)
+
+	require.NoError(t, r.ReleaseNetworks("ctr1", []string{"net2"}))
+	owner, err = r.Owner("", 8080, "tcp")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+
+	require.NoError(t, r.ReleaseNetworks("unknown", []string{"net1"}))
+}
+
+func TestReserveAppendAndReleaseMappings(t *testing.T) {
+	r, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = r.Reserve("ctr1", []types.PortMapping{{HostPort: 8080, ContainerPort: 80}}, nil)
+	require.NoError(t, err)
+	_, err = r.Reserve("ctr1", []types.PortMapping{{HostPort: 8080, ContainerPort: 81}}, &ReserveOptions{Append: true})
+	require.ErrorIs(t, err, ErrPortInUse)
+	_, err = r.Reserve("ctr1", []types.PortMapping{{HostPort: 9090, ContainerPort: 90}}, &ReserveOptions{Append: true})
+	require.NoError(t, err)
+	mappings, err := r.Mappings()
+	require.NoError(t, err)
+	assert.Len(t, mappings["ctr1"], 2)
+
+	require.NoError(t, r.ReleaseMappings("ctr1", []types.PortMapping{{HostPort: 8080, ContainerPort: 80}}))
+	owner, err := r.Owner("", 8080, "tcp")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+	owner, err = r.Owner("", 9090, "tcp")
+	require.NoError(t, err)
+	require.NotNil(t, owner)
+
+	require.NoError(t, r.ReleaseMappings("ctr1", []types.PortMapping{{HostPort: 9090, ContainerPort: 90}}))
+	mappings, err = r.Mappings()
+	require.NoError(t, err)
+	assert.Empty(t, mappings)
+}
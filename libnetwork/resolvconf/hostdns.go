@@ -0,0 +1,270 @@
+package resolvconf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// systemdResolvedIPv2 is the extra stub of systemd-resolved which does
+	// not forward to the local LLMNR and mDNS resolvers.
+	systemdResolvedIPv2 = "127.0.0.54"
+)
+
+// hostPaths are the files used to find the real nameservers of the host.
+type hostPaths struct {
+	// resolvedResolvConf lists the upstream servers of systemd-resolved.
+	resolvedResolvConf string
+	// resolvedLinkDir contains the per link state of systemd-resolved, the
+	// file names are the interface indexes.
+	resolvedLinkDir string
+	// networkManagerResolvConf lists the upstream servers of NetworkManager
+	// when it runs a local dnsmasq.
+	networkManagerResolvConf string
+}
+
+var defaultHostPaths = hostPaths{
+	resolvedResolvConf:       "/run/systemd/resolve/resolv.conf",
+	resolvedLinkDir:          "/run/systemd/resolve/netif",
+	networkManagerResolvConf: "/run/NetworkManager/no-stub-resolv.conf",
+}
+
+// HostDNS is the dns configuration of the host as seen from a container
+// with its own network namespace.
+type HostDNS struct {
+	// Nameservers are the upstream servers, a local stub resolver is
+	// replaced by the servers it forwards to.
+	Nameservers []string
+	// Searches are the search domains.
+	Searches []string
+	// Options are the resolv.conf options.
+	Options []string
+	// Source is the file the servers were read from or the link state
+	// directory of systemd-resolved.
+	Source string
+}
+
+// DiscoverHostDNS returns the dns configuration of the host. When
+// /etc/resolv.conf only points at the local stub of systemd-resolved or
+// NetworkManager the real upstream servers are returned instead.
+func DiscoverHostDNS() (*HostDNS, error) {
+	return defaultHostPaths.discover(DefaultResolvConf)
+}
+
+func (p *hostPaths) discover(resolvConfPath string) (*HostDNS, error) {
+	contents, err := os.ReadFile(resolvConfPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	upstream, source, err := p.upstreamResolvConf(contents)
+	if err != nil {
+		return nil, err
+	}
+	if source == "" {
+		source = resolvConfPath
+	}
+	return &HostDNS{
+		Nameservers: getNameservers(upstream),
+		Searches:    getSearchDomains(upstream),
+		Options:     getOptions(upstream),
+		Source:      source,
+	}, nil
+}
+
+// upstreamResolvConf returns the resolv.conf content with the real upstream
+// servers when contents only lists local stub resolvers, otherwise contents
+// is returned unchanged. The returned source is the file or directory the
+// content is from or empty when contents is used.
+func (p *hostPaths) upstreamResolvConf(contents []byte) ([]byte, string, error) {
+	nameservers := getNameservers(contents)
+	if len(nameservers) == 0 || slices.ContainsFunc(nameservers, func(ns string) bool {
+		ip := net.ParseIP(ns)
+		return ip == nil || !ip.IsLoopback()
+	}) {
+		return contents, "", nil
+	}
+
+	if usesResolvedStub(nameservers) {
+		resolved, err := readOptionalFile(p.resolvedResolvConf)
+		if err != nil {
+			return nil, "", fmt.Errorf("local resolver detected, but could not read real resolv.conf at %q: %w", p.resolvedResolvConf, err)
+		}
+		if len(getNameservers(resolved)) > 0 {
+			logrus.Debugf("found local resolver, using %q to get the nameservers", p.resolvedResolvConf)
+			return resolved, p.resolvedResolvConf, nil
+		}
+		// the global resolv.conf of systemd-resolved is empty when all
+		// servers are configured per link, e.g. by NetworkManager
+		links, err := readResolvedLinks(p.resolvedLinkDir)
+		if err != nil {
+			return nil, "", err
+		}
+		if servers, searches := resolvedDefaultServers(links); len(servers) > 0 {
+			logrus.Debugf("found local resolver, using the systemd-resolved link state to get the nameservers")
+			return render(servers, searches, getOptions(contents)), p.resolvedLinkDir, nil
+		}
+		return contents, "", nil
+	}
+
+	if slices.Contains(nameservers, localhost) {
+		// used by NetworkManager https://github.com/containers/podman/issues/13599
+		nm, err := readOptionalFile(p.networkManagerResolvConf)
+		if err != nil {
+			return nil, "", fmt.Errorf("local resolver detected, but could not read real resolv.conf at %q: %w", p.networkManagerResolvConf, err)
+		}
+		if len(getNameservers(nm)) > 0 {
+			logrus.Debugf("found local resolver, using %q to get the nameservers", p.networkManagerResolvConf)
+			return nm, p.networkManagerResolvConf, nil
+		}
+	}
+	return contents, "", nil
+}
+
+func usesResolvedStub(nameservers []string) bool {
+	return slices.Contains(nameservers, systemdResolvedIP) || slices.Contains(nameservers, systemdResolvedIPv2)
+}
+
+// readOptionalFile returns no error when the file does not exist, the
+// detection logic is not perfect.
+func readOptionalFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return content, nil
+}
+
+// resolvedLink is the dns config of an interface in systemd-resolved.
+type resolvedLink struct {
+	index   int
+	servers []string
+	// domains are search and route domains.
+	domains []string
+	// routeDomains are only used to route queries, "." is the catch all.
+	routeDomains []string
+	// defaultRoute is set when the link is used for all domains without a
+	// more specific route.
+	defaultRoute bool
+}
+
+// readResolvedLinks parses the link state files of systemd-resolved, the
+// links are sorted by the interface index.
+func readResolvedLinks(dir string) ([]resolvedLink, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var links []resolvedLink
+	for _, entry := range entries {
+		index, err := strconv.Atoi(entry.Name())
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		links = append(links, parseResolvedLink(index, content))
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].index < links[j].index })
+	return links, nil
+}
+
+func parseResolvedLink(index int, content []byte) resolvedLink {
+	link := resolvedLink{index: index}
+	defaultRoute := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		switch key {
+		case "SERVERS":
+			for _, server := range strings.Fields(value) {
+				if ip := parseResolvedServer(server); ip != "" {
+					link.servers = append(link.servers, ip)
+				}
+			}
+		case "DOMAINS":
+			link.domains = strings.Fields(value)
+		case "ROUTE_DOMAINS":
+			for _, domain := range strings.Fields(value) {
+				link.routeDomains = append(link.routeDomains, strings.TrimPrefix(domain, "~"))
+			}
+		case "DEFAULT_ROUTE":
+			defaultRoute = value
+		}
+	}
+	// without an explicit setting resolved uses a link for all domains
+	// unless it has route only domains
+	link.defaultRoute = defaultRoute == "yes" ||
+		(defaultRoute == "" && !slices.ContainsFunc(link.routeDomains, func(d string) bool { return d != "." }))
+	if slices.Contains(link.routeDomains, ".") {
+		link.defaultRoute = true
+	}
+	return link
+}
+
+// parseResolvedServer returns the ip of a server in the systemd-resolved
+// format, i.e. ip[:port][%ifindex][#name] with brackets around ipv6 with a
+// port. Servers on another port than 53 and link local servers are skipped
+// as resolv.conf cannot express them.
+func parseResolvedServer(server string) string {
+	server, _, _ = strings.Cut(server, "#")
+	// the interface index is always last, also after the port
+	if i := strings.LastIndex(server, "%"); i >= 0 && !strings.Contains(server[i:], "]") {
+		server = server[:i]
+	}
+	if host, port, err := net.SplitHostPort(server); err == nil {
+		if port != "53" {
+			return ""
+		}
+		server = host
+	}
+	server, _, _ = strings.Cut(server, "%")
+	// link local servers need the zone which is lost here
+	if ip := net.ParseIP(server); ip == nil || ip.IsLinkLocalUnicast() {
+		return ""
+	}
+	return server
+}
+
+// resolvedDefaultServers returns the servers and search domains of all
+// links used for the default route.
+func resolvedDefaultServers(links []resolvedLink) ([]string, []string) {
+	var servers, searches []string
+	for _, link := range links {
+		if !link.defaultRoute {
+			continue
+		}
+		for _, server := range link.servers {
+			if !slices.Contains(servers, server) {
+				servers = append(servers, server)
+			}
+		}
+		for _, domain := range link.domains {
+			if !slices.Contains(searches, domain) {
+				searches = append(searches, domain)
+			}
+		}
+	}
+	return servers, searches
+}
@@ -0,0 +1,154 @@
+package resolvconf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	resolvedStub = "nameserver 127.0.0.53\noptions edns0 trust-ad\nsearch lan\n"
+
+	// wifi is the default route, the vpn is only used for its domains
+	wifiLink = `# This is private data. Do not parse.
+LLMNR=yes
+MDNS=no
+SERVERS=192.168.1.1 fe80::1%3
+DOMAINS=lan
+DEFAULT_ROUTE=yes
+`
+	vpnLink = `# This is private data. Do not parse.
+SERVERS=10.0.0.53#dns.corp.example.com 10.0.0.54:5353 [fd00::53]:53
+DOMAINS=corp.example.com
+ROUTE_DOMAINS=internal.example.com
+DEFAULT_ROUTE=no
+`
+)
+
+func writeHostPaths(t *testing.T, resolved, networkManager string, links map[string]string) *hostPaths {
+	dir := t.TempDir()
+	p := &hostPaths{
+		resolvedResolvConf:       filepath.Join(dir, "resolved-resolv.conf"),
+		resolvedLinkDir:          filepath.Join(dir, "netif"),
+		networkManagerResolvConf: filepath.Join(dir, "no-stub-resolv.conf"),
+	}
+	if resolved != "" {
+		require.NoError(t, os.WriteFile(p.resolvedResolvConf, []byte(resolved), 0o644))
+	}
+	if networkManager != "" {
+		require.NoError(t, os.WriteFile(p.networkManagerResolvConf, []byte(networkManager), 0o644))
+	}
+	require.NoError(t, os.Mkdir(p.resolvedLinkDir, 0o755))
+	for name, content := range links {
+		require.NoError(t, os.WriteFile(filepath.Join(p.resolvedLinkDir, name), []byte(content), 0o644))
+	}
+	return p
+}
+
+func TestDiscoverHostDNS(t *testing.T) {
+	links := map[string]string{"3": wifiLink, "7": vpnLink, "invalid": "SERVERS=1.1.1.1\n"}
+
+	tests := []struct {
+		name           string
+		resolvConf     string
+		resolved       string
+		networkManager string
+		links          map[string]string
+		want           HostDNS
+	}{
+		{
+			name:       "no local resolver",
+			resolvConf: resolv2,
+			resolved:   "nameserver 9.9.9.9\n",
+			links:      links,
+			want:       HostDNS{Nameservers: []string{"1.1.1.1"}, Searches: []string{"example.com"}, Options: []string{"edns0"}, Source: "resolv.conf"},
+		},
+		{
+			name:       "systemd-resolved",
+			resolvConf: resolvedStub,
+			resolved:   "nameserver 192.168.1.1\nnameserver 10.0.0.53\nsearch lan corp.example.com\n",
+			links:      links,
+			want: HostDNS{
+				Nameservers: []string{"192.168.1.1", "10.0.0.53"},
+				Searches:    []string{"lan", "corp.example.com"},
+				Options:     []string{},
+				Source:      "resolved-resolv.conf",
+			},
+		},
+		{
+			name:       "systemd-resolved with only link state",
+			resolvConf: resolvedStub,
+			links:      links,
+			want: HostDNS{
+				Nameservers: []string{"192.168.1.1"},
+				Searches:    []string{"lan"},
+				Options:     []string{"edns0", "trust-ad"},
+				Source:      "netif",
+			},
+		},
+		{
+			name:       "systemd-resolved without upstream servers",
+			resolvConf: "nameserver 127.0.0.54\n",
+			want:       HostDNS{Nameservers: []string{"127.0.0.54"}, Searches: []string{}, Options: []string{}, Source: "resolv.conf"},
+		},
+		{
+			name:           "NetworkManager",
+			resolvConf:     "nameserver 127.0.0.1\n",
+			networkManager: "nameserver 192.168.1.1\nsearch lan\n",
+			want:           HostDNS{Nameservers: []string{"192.168.1.1"}, Searches: []string{"lan"}, Options: []string{}, Source: "no-stub-resolv.conf"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := writeHostPaths(t, tt.resolved, tt.networkManager, tt.links)
+			resolvConf := filepath.Join(filepath.Dir(p.resolvedLinkDir), "resolv.conf")
+			require.NoError(t, os.WriteFile(resolvConf, []byte(tt.resolvConf), 0o644))
+
+			got, err := p.discover(resolvConf)
+			require.NoError(t, err)
+			got.Source = filepath.Base(got.Source)
+			assert.Equal(t, tt.want, *got)
+		})
+	}
+}
+
+func TestNewWithLocalResolver(t *testing.T) {
+	p := writeHostPaths(t, "", "", map[string]string{"3": wifiLink, "7": vpnLink})
+	base := filepath.Join(t.TempDir(), "resolv.conf")
+	target := filepath.Join(t.TempDir(), "new-resolv.conf")
+	require.NoError(t, os.WriteFile(base, []byte(resolvedStub), 0o644))
+
+	err := New(&Params{
+		Path:           target,
+		Namespaces:     []specs.LinuxNamespace{{Type: specs.NetworkNamespace}},
+		IPv6Enabled:    true,
+		resolvConfPath: base,
+		hostPaths:      p,
+	})
+	require.NoError(t, err)
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "search lan\nnameserver 192.168.1.1\noptions edns0 trust-ad\n", string(content))
+}
+
+func TestParseResolvedServer(t *testing.T) {
+	tests := map[string]string{
+		"1.1.1.1":               "1.1.1.1",
+		"1.1.1.1:53":            "1.1.1.1",
+		"1.1.1.1#cloudflare":    "1.1.1.1",
+		"1.1.1.1:853#tls":       "",
+		"fd00::1":               "fd00::1",
+		"fe80::1%3":             "",
+		"[fd00::1]:53%3#name":   "fd00::1",
+		"[fd00::1]:53#name":     "fd00::1",
+		"not-an-ip":             "",
+		"[fe80::1%eth0]:53#foo": "",
+	}
+	for server, want := range tests {
+		assert.Equal(t, want, parseResolvedServer(server), server)
+	}
+}
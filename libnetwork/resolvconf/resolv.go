@@ -10,7 +10,6 @@ import (
 
 	"github.com/containers/storage/pkg/fileutils"
 	"github.com/opencontainers/runtime-spec/specs-go"
-	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -58,6 +57,9 @@ type Params struct {
 	// options. This should only be used for testing purposes. For all other
 	// callers this defaults to /etc/resolv.conf.
 	resolvConfPath string
+	// hostPaths are the files used to find the nameservers of local
+	// resolvers. This should only be used for testing purposes.
+	hostPaths *hostPaths
 }
 
 func getDefaultResolvConf(params *Params) ([]byte, bool, error) {
@@ -90,32 +92,16 @@ func getDefaultResolvConf(params *Params) ([]byte, bool, error) {
 		return contents, hostNS, nil
 	}
 
-	ns := getNameservers(contents)
+	hostPaths := &defaultHostPaths
+	// this is only used by testing
+	if params.hostPaths != nil {
+		hostPaths = params.hostPaths
+	}
 	// Check for local only resolver, in this case we want to get the real nameservers
 	// since localhost is not reachable from the netns.
-	if len(ns) == 1 {
-		var path string
-		switch ns[0] {
-		case systemdResolvedIP:
-			// used by systemd-resolved
-			path = "/run/systemd/resolve/resolv.conf"
-		case localhost:
-			// used by NetworkManager https://github.com/containers/podman/issues/13599
-			path = "/run/NetworkManager/no-stub-resolv.conf"
-		}
-		if path != "" {
-			// read the actual resolv.conf file for
-			resolvedContents, err := os.ReadFile(path)
-			if err != nil {
-				// do not error when the file does not exists, the detection logic is not perfect
-				if !errors.Is(err, os.ErrNotExist) {
-					return nil, false, fmt.Errorf("local resolver detected, but could not read real resolv.conf at %q: %w", path, err)
-				}
-			} else {
-				logrus.Debugf("found local resolver, using %q to get the nameservers", path)
-				contents = resolvedContents
-			}
-		}
+	contents, _, err = hostPaths.upstreamResolvConf(contents)
+	if err != nil {
+		return nil, false, err
 	}
 
 	return contents, hostNS, nil
@@ -135,26 +135,24 @@ func getOptions(resolvConf []byte) []string {
 // for every element in dns, a "search" entry for every element in
 // dnsSearch, and an "options" entry for every element in dnsOptions.
 func build(path string, dns, dnsSearch, dnsOptions []string) error {
+	return os.WriteFile(path, render(dns, dnsSearch, dnsOptions), 0o644)
+}
+
+// render returns the resolv.conf content for the given entries.
+func render(dns, dnsSearch, dnsOptions []string) []byte {
 	content := new(bytes.Buffer)
 	if len(dnsSearch) > 0 {
 		if searchString := strings.Join(dnsSearch, " "); strings.Trim(searchString, " ") != "." {
-			if _, err := content.WriteString("search " + searchString + "\n"); err != nil {
-				return err
-			}
+			content.WriteString("search " + searchString + "\n")
 		}
 	}
 	for _, dns := range dns {
-		if _, err := content.WriteString("nameserver " + dns + "\n"); err != nil {
-			return err
-		}
+		content.WriteString("nameserver " + dns + "\n")
 	}
 	if len(dnsOptions) > 0 {
 		if optsString := strings.Join(dnsOptions, " "); strings.Trim(optsString, " ") != "" {
-			if _, err := content.WriteString("options " + optsString + "\n"); err != nil {
-				return err
-			}
+			content.WriteString("options " + optsString + "\n")
 		}
 	}
-
-	return os.WriteFile(path, content.Bytes(), 0o644)
+	return content.Bytes()
 }
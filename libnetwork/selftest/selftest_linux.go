@@ -0,0 +1,484 @@
+// Package selftest checks the connectivity of a network. It attaches a
+// throwaway network namespace to the network with the backend and checks
+// the gateway, dns, port forwarding and outbound connections from it, so a
+// "no network in the container" report can be narrowed down to a single
+// failing step without collecting logs by hand.
+package selftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containers/common/libnetwork/resolvconf"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/common/pkg/netns"
+	"github.com/containers/storage/pkg/stringid"
+	"github.com/containers/storage/pkg/unshare"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultTimeout is the timeout of a single check.
+	DefaultTimeout = 5 * time.Second
+	// ContainerPort is the port forwarded to the namespace.
+	ContainerPort uint16 = 8080
+
+	interfaceName = "eth0"
+	portToken     = "libnetwork-selftest"
+)
+
+// Step names in the report.
+const (
+	StepSetup       = "setup"
+	StepGateway     = "gateway"
+	StepDNS         = "dns"
+	StepPortForward = "port_forward"
+	StepOutbound    = "outbound"
+	StepTeardown    = "teardown"
+)
+
+// Status is the result of a step.
+type Status string
+
+const (
+	Passed  Status = "passed"
+	Failed  Status = "failed"
+	Skipped Status = "skipped"
+)
+
+// Options are the options for Run.
+type Options struct {
+	// Network is the name or ID of the network to test.
+	Network string
+	// DNSName is resolved in the dns check. For networks with dns enabled
+	// the name of the test container is resolved by default, otherwise the
+	// check is skipped when it is not set.
+	DNSName string
+	// HostIP and HostPort are used for the port forward check, the
+	// defaults are 127.0.0.1 and a free port. The check is skipped as
+	// rootless because the ports are forwarded by the caller.
+	HostIP   string
+	HostPort uint16
+	// OutboundAddress is an ip:port outside of the network which is
+	// connected to over tcp to check the outbound nat. The check is skipped
+	// when it is not set.
+	OutboundAddress string
+	// Timeout of every check, defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// StepResult is the result of a single check.
+type StepResult struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// Message contains the error of a failed step or why it was skipped.
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the result of Run.
+type Report struct {
+	Network     string               `json:"network"`
+	Backend     types.NetworkBackend `json:"backend"`
+	ContainerID string               `json:"container_id"`
+	// Status is the status block returned by the backend Setup.
+	Status types.StatusBlock `json:"status"`
+	Steps  []StepResult      `json:"steps"`
+	// Passed is true when no step failed.
+	Passed bool `json:"passed"`
+}
+
+type selfTest struct {
+	network types.ContainerNetwork
+	options Options
+	report  *Report
+	nsPath  string
+}
+
+// Run attaches a new network namespace to the network, runs all checks and
+// tears it down again. An error is only returned when the test cannot run,
+// failed checks are part of the report.
+func Run(network types.ContainerNetwork, options *Options) (*Report, error) {
+	if options == nil || options.Network == "" {
+		return nil, fmt.Errorf("network must be set: %w", types.ErrInvalidArg)
+	}
+	t := &selfTest{
+		network: network,
+		options: *options,
+		report: &Report{
+			Network:     options.Network,
+			Backend:     network.NetworkInfo().Backend,
+			ContainerID: stringid.GenerateRandomID(),
+		},
+	}
+	if t.options.Timeout == 0 {
+		t.options.Timeout = DefaultTimeout
+	}
+	if t.options.HostIP == "" {
+		t.options.HostIP = "127.0.0.1"
+	}
+	if net.ParseIP(t.options.HostIP) == nil {
+		return nil, fmt.Errorf("invalid host ip %q: %w", t.options.HostIP, types.ErrInvalidArg)
+	}
+	if t.options.OutboundAddress != "" {
+		host, _, err := net.SplitHostPort(t.options.OutboundAddress)
+		if err != nil || net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("outbound address %q must be ip:port: %w", t.options.OutboundAddress, types.ErrInvalidArg)
+		}
+	}
+	if t.options.HostPort == 0 && !unshare.IsRootless() {
+		port, err := freePort()
+		if err != nil {
+			return nil, err
+		}
+		t.options.HostPort = port
+	}
+
+	netNS, err := netns.NewNS()
+	if err != nil {
+		return nil, fmt.Errorf("create network namespace: %w", err)
+	}
+	defer func() {
+		_ = netns.UnmountNS(netNS.Path())
+		netNS.Close()
+	}()
+	t.nsPath = netNS.Path()
+
+	setupOptions := t.setupOptions()
+	if t.step(StepSetup, t.setup) {
+		t.step(StepGateway, t.checkGateway)
+		t.step(StepDNS, t.checkDNS)
+		t.step(StepPortForward, t.checkPortForward)
+		t.step(StepOutbound, t.checkOutbound)
+	} else {
+		for _, name := range []string{StepGateway, StepDNS, StepPortForward, StepOutbound} {
+			t.skip(name, "setup failed")
+		}
+	}
+	// also tear down after a failed setup, it may have left resources
+	t.step(StepTeardown, func() error {
+		return t.network.Teardown(t.nsPath, types.TeardownOptions{NetworkOptions: setupOptions.NetworkOptions})
+	})
+
+	t.report.Passed = true
+	for _, step := range t.report.Steps {
+		if step.Status == Failed {
+			t.report.Passed = false
+		}
+	}
+	return t.report, nil
+}
+
+func (t *selfTest) setupOptions() types.SetupOptions {
+	options := types.SetupOptions{NetworkOptions: types.NetworkOptions{
+		ContainerID:   t.report.ContainerID,
+		ContainerName: "selftest-" + t.report.ContainerID[:12],
+		Networks: map[string]types.PerNetworkOptions{
+			t.options.Network: {InterfaceName: interfaceName},
+		},
+	}}
+	if t.options.HostPort != 0 {
+		options.PortMappings = []types.PortMapping{{
+			HostIP:        t.options.HostIP,
+			HostPort:      t.options.HostPort,
+			ContainerPort: ContainerPort,
+			Protocol:      "tcp",
+		}}
+	}
+	return options
+}
+
+// step runs the check and records the result, it returns true when the
+// check passed.
+func (t *selfTest) step(name string, check func() error) bool {
+	start := time.Now()
+	err := check()
+	result := StepResult{Name: name, Status: Passed, Duration: time.Since(start)}
+	var skip *skipError
+	switch {
+	case errors.As(err, &skip):
+		result.Status = Skipped
+		result.Message = skip.reason
+	case err != nil:
+		result.Status = Failed
+		result.Message = err.Error()
+	}
+	t.report.Steps = append(t.report.Steps, result)
+	return result.Status == Passed
+}
+
+func (t *selfTest) skip(name, reason string) {
+	t.report.Steps = append(t.report.Steps, StepResult{Name: name, Status: Skipped, Message: reason})
+}
+
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return "skipped: " + e.reason
+}
+
+func (t *selfTest) setup() error {
+	status, err := t.network.Setup(t.nsPath, t.setupOptions())
+	if err != nil {
+		return err
+	}
+	block, ok := status[t.options.Network]
+	if !ok {
+		// the status is keyed by name, the test may be given the ID
+		for _, b := range status {
+			block = b
+		}
+	}
+	t.report.Status = block
+	return nil
+}
+
+func (t *selfTest) addresses() []types.NetAddress {
+	var addrs []types.NetAddress
+	for _, netInterface := range t.report.Status.Interfaces {
+		addrs = append(addrs, netInterface.Subnets...)
+	}
+	return addrs
+}
+
+func (t *selfTest) inNetns(toRun func() error) error {
+	return ns.WithNetNSPath(t.nsPath, func(_ ns.NetNS) error {
+		return toRun()
+	})
+}
+
+func (t *selfTest) checkGateway() error {
+	var gateways []net.IP
+	for _, addr := range t.addresses() {
+		if addr.Gateway != nil {
+			gateways = append(gateways, addr.Gateway)
+		}
+	}
+	if len(gateways) == 0 {
+		return &skipError{reason: "network has no gateway"}
+	}
+	return t.inNetns(func() error {
+		var errs []error
+		for _, gw := range gateways {
+			if err := ping(gw, t.options.Timeout); err != nil {
+				errs = append(errs, fmt.Errorf("gateway %s: %w", gw, err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func (t *selfTest) checkDNS() error {
+	name := t.options.DNSName
+	servers := t.report.Status.DNSServerIPs
+	qtype := dnsmessage.TypeA
+	if len(servers) > 0 && name == "" {
+		// the network dns server knows the test container
+		name = t.setupOptions().ContainerName
+		qtype = dnsmessage.TypeAAAA
+		for _, addr := range t.addresses() {
+			if addr.IPNet.IP.To4() != nil {
+				qtype = dnsmessage.TypeA
+			}
+		}
+	}
+	if name == "" {
+		return &skipError{reason: "network has no dns server and no dns name is set"}
+	}
+	if len(servers) == 0 {
+		hostDNS, err := resolvconf.DiscoverHostDNS()
+		if err != nil {
+			return fmt.Errorf("get host nameservers: %w", err)
+		}
+		for _, server := range hostDNS.Nameservers {
+			// the loopback of the host is not reachable from the netns
+			if ip := net.ParseIP(server); ip != nil && !ip.IsLoopback() {
+				servers = append(servers, ip)
+			}
+		}
+		if len(servers) == 0 {
+			return errors.New("no nameservers found")
+		}
+	}
+	return t.inNetns(func() error {
+		var errs []error
+		for _, server := range servers {
+			if err := resolve(server, name, qtype, t.options.Timeout); err != nil {
+				errs = append(errs, fmt.Errorf("resolve %s via %s: %w", name, server, err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func (t *selfTest) checkPortForward() error {
+	if t.options.HostPort == 0 {
+		return &skipError{reason: "ports are forwarded by the caller as rootless"}
+	}
+	var l net.Listener
+	err := t.inNetns(func() error {
+		var err error
+		l, err = net.Listen("tcp", ":"+strconv.Itoa(int(ContainerPort)))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("listen in the namespace: %w", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(portToken))
+	}()
+
+	addr := net.JoinHostPort(t.options.HostIP, strconv.Itoa(int(t.options.HostPort)))
+	conn, err := net.DialTimeout("tcp", addr, t.options.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(t.options.Timeout)); err != nil {
+		return err
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("read from %s: %w", addr, err)
+	}
+	if string(got) != portToken {
+		return fmt.Errorf("connection to %s was not forwarded to the namespace", addr)
+	}
+	return nil
+}
+
+func (t *selfTest) checkOutbound() error {
+	if t.options.OutboundAddress == "" {
+		return &skipError{reason: "no outbound address is set"}
+	}
+	return t.inNetns(func() error {
+		conn, err := net.DialTimeout("tcp", t.options.OutboundAddress, t.options.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+func freePort() (uint16, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// ping sends an icmp echo request to the ip and waits for the reply, it
+// needs a raw socket.
+func ping(ip net.IP, timeout time.Duration) error {
+	network, request, reply := "ip4:icmp", byte(8), byte(0)
+	if ip.To4() == nil {
+		network, request, reply = "ip6:ipv6-icmp", 128, 129
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	msg := []byte{request, 0, 0, 0, byte(id >> 8), byte(id), 0, 1, 'p', 'i', 'n', 'g'}
+	// the kernel computes the checksum for icmpv6
+	if ip.To4() != nil {
+		sum := icmpChecksum(msg)
+		msg[2], msg[3] = byte(sum>>8), byte(sum)
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		from, ok := addr.(*net.IPAddr)
+		if ok && from.IP.Equal(ip) && n >= 8 && buf[0] == reply && bytes.Equal(buf[4:6], msg[4:6]) {
+			return nil
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// resolve sends a query for the name to the server and checks that it
+// returns at least one answer.
+func resolve(server net.IP, name string, qtype dnsmessage.Type, timeout time.Duration) error {
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(os.Getpid()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	buf, err := query.Pack()
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(server.String(), "53"), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	reply := make([]byte, 4096)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return err
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(reply[:n]); err != nil || msg.ID != query.ID {
+			continue
+		}
+		if msg.RCode != dnsmessage.RCodeSuccess {
+			return fmt.Errorf("server returned %s", msg.RCode)
+		}
+		if len(msg.Answers) == 0 {
+			return errors.New("server returned no answers")
+		}
+		return nil
+	}
+}
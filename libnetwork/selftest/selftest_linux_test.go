@@ -0,0 +1,237 @@
+package selftest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containers/common/libnetwork/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeNetwork attaches the namespace with its loopback interface, it
+// serves dns for the container name and forwards the host port with a
+// proxy like rootlessport does.
+type fakeNetwork struct {
+	types.ContainerNetwork
+	setupErr error
+
+	dns        net.PacketConn
+	proxy      net.Listener
+	tornDown   bool
+	setupCalls int
+}
+
+func (f *fakeNetwork) NetworkInfo() types.NetworkInfo {
+	return types.NetworkInfo{Backend: "fake"}
+}
+
+func (f *fakeNetwork) Setup(nsPath string, options types.SetupOptions) (map[string]types.StatusBlock, error) {
+	f.setupCalls++
+	if f.setupErr != nil {
+		return nil, f.setupErr
+	}
+	err := ns.WithNetNSPath(nsPath, func(_ ns.NetNS) error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetUp(lo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]types.StatusBlock{"test": {
+		DNSServerIPs: []net.IP{net.ParseIP("127.0.0.1")},
+		Interfaces: map[string]types.NetInterface{interfaceName: {Subnets: []types.NetAddress{{
+			IPNet:   types.IPNet{IPNet: net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}},
+			Gateway: net.ParseIP("127.0.0.1"),
+		}}}},
+	}}
+	f.dns, err = serveDNS(nsPath, options.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	pm := options.PortMappings[0]
+	f.proxy, err = net.Listen("tcp", net.JoinHostPort(pm.HostIP, strconv.Itoa(int(pm.HostPort))))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := f.proxy.Accept()
+			if err != nil {
+				return
+			}
+			_ = ns.WithNetNSPath(nsPath, func(_ ns.NetNS) error {
+				target, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(int(pm.ContainerPort)))
+				if err != nil {
+					conn.Close()
+					return err
+				}
+				go func() {
+					_, _ = io.Copy(conn, target)
+					conn.Close()
+					target.Close()
+				}()
+				return nil
+			})
+		}
+	}()
+	return status, nil
+}
+
+func (f *fakeNetwork) Teardown(_ string, _ types.TeardownOptions) error {
+	f.tornDown = true
+	var errs []error
+	if f.dns != nil {
+		errs = append(errs, f.dns.Close())
+	}
+	if f.proxy != nil {
+		errs = append(errs, f.proxy.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// serveDNS answers A queries for name with 127.0.0.1 on the loopback of the
+// namespace, all other queries fail like an unreachable upstream server.
+func serveDNS(nsPath, name string) (net.PacketConn, error) {
+	var conn net.PacketConn
+	err := ns.WithNetNSPath(nsPath, func(_ ns.NetNS) error {
+		var err error
+		conn, err = net.ListenPacket("udp", "127.0.0.1:53")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			msg.Header.Response = true
+			if q.Type == dnsmessage.TypeA && q.Name.String() == name+"." {
+				msg.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			} else {
+				msg.Header.RCode = dnsmessage.RCodeServerFailure
+			}
+			reply, err := msg.Pack()
+			if err == nil {
+				_, _ = conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return conn, nil
+}
+
+func requireRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+}
+
+func stepStatus(report *Report) map[string]Status {
+	steps := make(map[string]Status, len(report.Steps))
+	for _, step := range report.Steps {
+		steps[step.Name] = step.Status
+	}
+	return steps
+}
+
+func TestRun(t *testing.T) {
+	requireRoot(t)
+	network := &fakeNetwork{}
+	report, err := Run(network, &Options{Network: "test"})
+	require.NoError(t, err)
+	assert.True(t, network.tornDown)
+	assert.Equal(t, types.NetworkBackend("fake"), report.Backend)
+	assert.Len(t, report.ContainerID, 64)
+	assert.Equal(t, map[string]Status{
+		StepSetup:       Passed,
+		StepGateway:     Passed,
+		StepDNS:         Passed,
+		StepPortForward: Passed,
+		StepOutbound:    Skipped,
+		StepTeardown:    Passed,
+	}, stepStatus(report), report.Steps)
+	assert.True(t, report.Passed)
+}
+
+func TestRunFailedChecks(t *testing.T) {
+	requireRoot(t)
+	// nothing listens on the outbound address and the upstream of the
+	// dns server is not reachable
+	report, err := Run(&fakeNetwork{}, &Options{
+		Network:         "test",
+		DNSName:         "example.com",
+		OutboundAddress: "127.0.0.1:1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Status{
+		StepSetup:       Passed,
+		StepGateway:     Passed,
+		StepDNS:         Failed,
+		StepPortForward: Passed,
+		StepOutbound:    Failed,
+		StepTeardown:    Passed,
+	}, stepStatus(report), report.Steps)
+	assert.False(t, report.Passed)
+}
+
+func TestRunFailedSetup(t *testing.T) {
+	requireRoot(t)
+	network := &fakeNetwork{setupErr: errors.New("no such network")}
+	report, err := Run(network, &Options{Network: "test"})
+	require.NoError(t, err)
+	assert.True(t, network.tornDown)
+	assert.Equal(t, "no such network", report.Steps[0].Message)
+	assert.Equal(t, map[string]Status{
+		StepSetup:       Failed,
+		StepGateway:     Skipped,
+		StepDNS:         Skipped,
+		StepPortForward: Skipped,
+		StepOutbound:    Skipped,
+		StepTeardown:    Passed,
+	}, stepStatus(report))
+	assert.False(t, report.Passed)
+}
+
+func TestRunInvalidOptions(t *testing.T) {
+	network := &fakeNetwork{}
+	for _, options := range []*Options{
+		nil,
+		{},
+		{Network: "test", HostIP: "localhost"},
+		{Network: "test", OutboundAddress: "example.com:80"},
+		{Network: "test", OutboundAddress: "1.1.1.1"},
+	} {
+		_, err := Run(network, options)
+		require.ErrorIs(t, err, types.ErrInvalidArg)
+	}
+	assert.Zero(t, network.setupCalls)
+}
+
+func TestICMPChecksum(t *testing.T) {
+	// echo request with id 1 and sequence 1
+	assert.Equal(t, uint16(0xf7fd), icmpChecksum([]byte{8, 0, 0, 0, 0, 1, 0, 1}))
+	assert.Equal(t, uint16(0xf6fd), icmpChecksum([]byte{8, 0, 0, 0, 0, 1, 0, 1, 1}))
+}
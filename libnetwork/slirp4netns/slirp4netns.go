@@ -19,6 +19,8 @@ import (
 	"time"
 
 	"github.com/containernetworking/plugins/pkg/ns"
+	internalutil "github.com/containers/common/libnetwork/internal/util"
+	"github.com/containers/common/libnetwork/portregistry"
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/pkg/config"
 	"github.com/containers/common/pkg/rootlessport"
@@ -45,9 +47,20 @@ type slirp4netnsCmdArg struct {
 	GuestPort uint16 `json:"guest_port"`
 }
 
+type slirp4netnsRemoveArg struct {
+	ID int `json:"id"`
+}
+
 type slirp4netnsCmd struct {
-	Execute string            `json:"execute"`
-	Args    slirp4netnsCmdArg `json:"arguments"`
+	Execute string `json:"execute"`
+	Args    any    `json:"arguments,omitempty"`
+}
+
+type slirp4netnsHostFwd struct {
+	ID       int    `json:"id"`
+	Proto    string `json:"proto"`
+	HostAddr string `json:"host_addr"`
+	HostPort uint16 `json:"host_port"`
 }
 
 type networkOptions struct {
@@ -70,6 +83,10 @@ type SetupOptions struct {
 	Netns string
 	// Ports the should be forwarded
 	Ports []types.PortMapping
+	// PortRegistry reserves the host ports of Ports for ContainerID to
+	// detect conflicts with other containers, optional. The caller must
+	// release them with Registry.Release once the container stopped.
+	PortRegistry *portregistry.Registry
 	// ExtraOptions for slirp4netns that were set on the cli
 	ExtraOptions []string
 	// Slirp4netnsExitPipeR pipe used to exit the slirp4netns process.
@@ -245,7 +262,7 @@ func createBasicSlirpCmdArgs(options *networkOptions, features *slirpFeatures) (
 
 // Setup can be called in rootful as well as in rootless.
 // Spawns the slirp4netns process and setup port forwarding if ports are given.
-func Setup(opts *SetupOptions) (*SetupResult, error) {
+func Setup(opts *SetupOptions) (_ *SetupResult, retErr error) {
 	path := opts.Config.Engine.NetworkCmdPath
 	if path == "" {
 		var err error
@@ -278,6 +295,15 @@ func Setup(opts *SetupOptions) (*SetupResult, error) {
 		return nil, err
 	}
 
+	if err := internalutil.ReservePorts(opts.PortRegistry, opts.ContainerID, opts.Ports, nil); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			internalutil.ReleasePorts(opts.PortRegistry, opts.ContainerID, nil)
+		}
+	}()
+
 	// the slirp4netns arguments being passed are described as follows:
 	// from the slirp4netns documentation: https://github.com/rootless-containers/slirp4netns
 	// -c, --configure Brings up the tap interface
@@ -288,9 +314,10 @@ func Setup(opts *SetupOptions) (*SetupResult, error) {
 		cmdArgs = append(cmdArgs, "-e", "4")
 	}
 
+	// the api socket is also needed to change the ports of the running container
 	var apiSocket string
-	if havePortMapping && netOptions.isSlirpHostForward {
-		apiSocket = filepath.Join(opts.Config.Engine.TmpDir, opts.ContainerID+".net")
+	if netOptions.isSlirpHostForward {
+		apiSocket = APISocketPath(opts.Config, opts.ContainerID)
 		cmdArgs = append(cmdArgs, "--api-socket", apiSocket)
 	}
 
@@ -639,34 +666,108 @@ func setupRootlessPortMappingViaSlirp(ports []types.PortMapping, cmd *exec.Cmd,
 	// for each port we want to add we need to open a connection to the slirp4netns control socket
 	// and send the add_hostfwd command.
 	for _, port := range ports {
-		protocols := strings.Split(port.Protocol, ",")
-		for _, protocol := range protocols {
-			hostIP := port.HostIP
-			if hostIP == "" {
-				hostIP = "0.0.0.0"
+		err := forEachSlirpPort(port, func(protocol, hostIP string, hostPort, guestPort uint16) error {
+			_, err := openSlirp4netnsPort(apiSocket, protocol, hostIP, hostPort, guestPort)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	logrus.Debug("slirp4netns port-forwarding setup via add_hostfwd is ready")
+	return nil
+}
+
+// APISocketPath returns the path of the slirp4netns api socket of the
+// container, it only exists with port_handler=slirp4netns.
+func APISocketPath(conf *config.Config, containerID string) string {
+	return filepath.Join(conf.Engine.TmpDir, containerID+".net")
+}
+
+// AddPortMappings forwards the ports to the running container via the
+// slirp4netns api socket. When a forward fails the forwards added before
+// are removed again.
+func AddPortMappings(conf *config.Config, containerID string, ports []types.PortMapping) (retErr error) {
+	apiSocket := APISocketPath(conf, containerID)
+	var added []int
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		for _, id := range added {
+			apiCmd := slirp4netnsCmd{Execute: "remove_hostfwd", Args: slirp4netnsRemoveArg{ID: id}}
+			if err := slirp4netnsAPI(apiSocket, &apiCmd, nil); err != nil {
+				logrus.Errorf("Failed to remove slirp4netns port forward %d: %v", id, err)
+			}
+		}
+	}()
+	for _, port := range ports {
+		err := forEachSlirpPort(port, func(protocol, hostIP string, hostPort, guestPort uint16) error {
+			id, err := openSlirp4netnsPort(apiSocket, protocol, hostIP, hostPort, guestPort)
+			if err != nil {
+				return err
 			}
-			for i := range port.Range {
-				if err := openSlirp4netnsPort(apiSocket, protocol, hostIP, port.HostPort+i, port.ContainerPort+i); err != nil {
+			added = append(added, id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemovePortMappings removes the forwards of the ports from the running
+// container via the slirp4netns api socket. Ports which are not forwarded
+// are ignored.
+func RemovePortMappings(conf *config.Config, containerID string, ports []types.PortMapping) error {
+	apiSocket := APISocketPath(conf, containerID)
+	var list struct {
+		Entries []slirp4netnsHostFwd `json:"entries"`
+	}
+	if err := slirp4netnsAPI(apiSocket, &slirp4netnsCmd{Execute: "list_hostfwd"}, &list); err != nil {
+		return err
+	}
+	for _, port := range ports {
+		err := forEachSlirpPort(port, func(protocol, hostIP string, hostPort, _ uint16) error {
+			for _, entry := range list.Entries {
+				if entry.Proto != protocol || entry.HostAddr != hostIP || entry.HostPort != hostPort {
+					continue
+				}
+				apiCmd := slirp4netnsCmd{Execute: "remove_hostfwd", Args: slirp4netnsRemoveArg{ID: entry.ID}}
+				if err := slirp4netnsAPI(apiSocket, &apiCmd, nil); err != nil {
 					return err
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
-	logrus.Debug("slirp4netns port-forwarding setup via add_hostfwd is ready")
 	return nil
 }
 
-// openSlirp4netnsPort sends the slirp4netns pai quey to the given socket
-func openSlirp4netnsPort(apiSocket, proto, hostip string, hostport, guestport uint16) error {
-	conn, err := net.Dial("unix", apiSocket)
-	if err != nil {
-		return fmt.Errorf("cannot open connection to %s: %w", apiSocket, err)
-	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			logrus.Errorf("Unable to close slirp4netns connection: %q", err)
+// forEachSlirpPort calls fn for every single port of the mapping, slirp4netns
+// has no port ranges and a forward per protocol.
+func forEachSlirpPort(port types.PortMapping, fn func(protocol, hostIP string, hostPort, guestPort uint16) error) error {
+	hostIP := port.HostIP
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
+	}
+	for _, protocol := range strings.Split(port.Protocol, ",") {
+		for i := range max(port.Range, 1) {
+			if err := fn(protocol, hostIP, port.HostPort+i, port.ContainerPort+i); err != nil {
+				return err
+			}
 		}
-	}()
+	}
+	return nil
+}
+
+// openSlirp4netnsPort sends the slirp4netns pai quey to the given socket,
+// it returns the id of the forward.
+func openSlirp4netnsPort(apiSocket, proto, hostip string, hostport, guestport uint16) (int, error) {
 	apiCmd := slirp4netnsCmd{
 		Execute: "add_hostfwd",
 		Args: slirp4netnsCmdArg{
@@ -676,9 +777,28 @@ func openSlirp4netnsPort(apiSocket, proto, hostip string, hostport, guestport ui
 			GuestPort: guestport,
 		},
 	}
+	var result struct {
+		ID int `json:"id"`
+	}
+	err := slirp4netnsAPI(apiSocket, &apiCmd, &result)
+	return result.ID, err
+}
+
+// slirp4netnsAPI sends the command to the api socket and decodes the return
+// value into result if it is not nil.
+func slirp4netnsAPI(apiSocket string, apiCmd *slirp4netnsCmd, result any) error {
+	conn, err := net.Dial("unix", apiSocket)
+	if err != nil {
+		return fmt.Errorf("cannot open connection to %s: %w", apiSocket, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.Errorf("Unable to close slirp4netns connection: %q", err)
+		}
+	}()
 	// create the JSON payload and send it.  Mark the end of request shutting down writes
 	// to the socket, as requested by slirp4netns.
-	data, err := json.Marshal(&apiCmd)
+	data, err := json.Marshal(apiCmd)
 	if err != nil {
 		return fmt.Errorf("cannot marshal JSON for slirp4netns: %w", err)
 	}
@@ -692,19 +812,27 @@ func openSlirp4netnsPort(apiSocket, proto, hostip string, hostport, guestport ui
 	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
 		return fmt.Errorf("cannot shutdown the socket %s: %w", apiSocket, err)
 	}
-	buf := make([]byte, 2048)
-	readLength, err := conn.Read(buf)
+	// the list of forwarded ports may be longer than a single read
+	buf, err := io.ReadAll(conn)
 	if err != nil {
 		return fmt.Errorf("cannot read from control socket %s: %w", apiSocket, err)
 	}
 	// if there is no 'error' key in the received JSON data, then the operation was
 	// successful.
-	var y map[string]any
-	if err := json.Unmarshal(buf[0:readLength], &y); err != nil {
+	var y struct {
+		Error  any             `json:"error"`
+		Return json.RawMessage `json:"return"`
+	}
+	if err := json.Unmarshal(buf, &y); err != nil {
 		return fmt.Errorf("parsing error status from slirp4netns: %w", err)
 	}
-	if e, found := y["error"]; found {
-		return fmt.Errorf("from slirp4netns while setting up port redirection: %v", e)
+	if y.Error != nil {
+		return fmt.Errorf("from slirp4netns while executing %s: %v", apiCmd.Execute, y.Error)
+	}
+	if result != nil {
+		if err := json.Unmarshal(y.Return, result); err != nil {
+			return fmt.Errorf("parsing %s result from slirp4netns: %w", apiCmd.Execute, err)
+		}
 	}
 	return nil
 }
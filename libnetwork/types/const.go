@@ -47,6 +47,16 @@ const (
 	NoDefaultRoute = "no_default_route"
 	BclimOption    = "bclim"
 	VRFOption      = "vrf"
+
+	// VLANFilteringOption enables vlan filtering on the bridge of a bridge
+	// network without a default vlan, so that containers can be put into
+	// vlans with the per network container options.
+	VLANFilteringOption = "vlan_filtering"
+	// VLANTrunkOption is a comma separated list of vlan IDs and ranges,
+	// e.g. "10,20-30", which are allowed tagged on the bridge port. It can
+	// be set on a bridge network and per container, the vlan option sets the
+	// untagged access vlan of a container.
+	VLANTrunkOption = "vlan_trunk"
 )
 
 type NetworkBackend string
@@ -10,7 +10,8 @@ type ContainerNetwork interface {
 	// NetworkCreate will take a partial filled Network and fill the
 	// missing fields. It creates the Network and returns the full Network.
 	NetworkCreate(Network, *NetworkCreateOptions) (Network, error)
-	// NetworkUpdate will take network name and ID and updates network DNS Servers.
+	// NetworkUpdate will take network name and ID and updates the network
+	// DNS servers, labels, options, routes, lease ranges and subnets.
 	NetworkUpdate(nameOrID string, options NetworkUpdateOptions) error
 	// NetworkRemove will remove the Network with the given name or ID.
 	NetworkRemove(nameOrID string) error
@@ -36,6 +37,17 @@ type ContainerNetwork interface {
 	// Only used as rootless and should return an error as root.
 	RootlessNetnsInfo() (*RootlessNetnsInfo, error)
 
+	// RootlessNetnsStatus returns diagnostics about the rootless netns,
+	// e.g. whether pasta or slirp4netns is still running.
+	// Only used as rootless and should return an error as root.
+	RootlessNetnsStatus() (*RootlessNetnsStatus, error)
+
+	// RootlessNetnsRecover restarts pasta or slirp4netns when it is no
+	// longer running and checks that the mounts can be set up again. The
+	// netns is kept so the attached containers are not affected.
+	// Only used as rootless and should return an error as root.
+	RootlessNetnsRecover(options RootlessNetnsRecoverOptions) (*RootlessNetnsStatus, error)
+
 	// Drivers will return the list of supported network drivers
 	// for this interface.
 	Drivers() []string
@@ -47,6 +59,36 @@ type ContainerNetwork interface {
 	// NetworkInfo return the network information about backend type,
 	// binary path, package version and so on.
 	NetworkInfo() NetworkInfo
+
+	// IPAMLeases returns the ips allocated by the ipam on the network with
+	// the given name or ID, including reserved ips. If nameOrID is empty
+	// the leases of all networks are returned.
+	IPAMLeases(nameOrID string) ([]IPAMLease, error)
+	// IPAMReserve reserves all ips in the range on the network so they are
+	// never allocated to a container. It fails when one of the ips is
+	// already allocated.
+	IPAMReserve(nameOrID string, ipRange LeaseRange) error
+	// IPAMUnreserve releases reserved ips in the range on the network.
+	IPAMUnreserve(nameOrID string, ipRange LeaseRange) error
+	// IPAMReclaim finds the leases of containers which no longer exist and
+	// releases them. It returns the affected leases.
+	IPAMReclaim(options IPAMReclaimOptions) ([]IPAMLease, error)
+
+	// NetworkExport returns a portable document with the networks with the
+	// given names or IDs including their IPAM reservations. If no network
+	// is given all networks except the default network are exported.
+	NetworkExport(namesOrIDs ...string) (*NetworkExport, error)
+	// NetworkImport creates the networks from an export document, which
+	// may come from another host or the other backend. It returns the
+	// created networks.
+	NetworkImport(export *NetworkExport, options NetworkImportOptions) ([]Network, error)
+
+	// NetworkReconcile compares the IPAM state, the network configs and the
+	// live interfaces against the running containers and removes what was
+	// leaked by containers which are no longer running, e.g. when a
+	// container was killed during Setup. It returns the orphans found, on
+	// an error the report contains all orphans found so far.
+	NetworkReconcile(options NetworkReconcileOptions) (*NetworkReconcileReport, error)
 }
 
 // Network describes the Network attributes.
@@ -87,12 +129,37 @@ type Network struct {
 	IPAMOptions map[string]string `json:"ipam_options,omitempty"`
 }
 
-// NetworkOptions for a given container.
+// NetworkUpdateOptions describes the changes NetworkUpdate applies to a
+// Network. Except for the DNS servers the changes only take effect for
+// containers connected after the update.
 type NetworkUpdateOptions struct {
 	// List of custom DNS server for podman's DNS resolver.
 	// Priority order will be kept as defined by user in the configuration.
 	AddDNSServers    []string `json:"add_dns_servers,omitempty"`
 	RemoveDNSServers []string `json:"remove_dns_servers,omitempty"`
+	// AddLabels adds the labels to the network, existing keys are overwritten.
+	AddLabels map[string]string `json:"add_labels,omitempty"`
+	// RemoveLabels removes the labels with the given keys.
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+	// SetOptions adds or overwrites network options. Only the mtu, isolate
+	// and metric options can be changed.
+	SetOptions map[string]string `json:"set_options,omitempty"`
+	// RemoveOptions removes the options with the given keys, the same
+	// restrictions as for SetOptions apply.
+	RemoveOptions []string `json:"remove_options,omitempty"`
+	// AddRoutes adds routes to the network.
+	AddRoutes []Route `json:"add_routes,omitempty"`
+	// RemoveRoutes removes all routes with the given destinations.
+	// swagger:type []string
+	RemoveRoutes []IPNet `json:"remove_routes,omitempty"`
+	// AddSubnets adds subnets to the network. Containers which are already
+	// connected only get an address in the new subnets after they are
+	// connected again.
+	AddSubnets []Subnet `json:"add_subnets,omitempty"`
+	// LeaseRanges changes the lease range of existing subnets, the key is
+	// the subnet in CIDR form. A nil value removes the lease range. All ips
+	// currently allocated in the subnet must be part of the new range.
+	LeaseRanges map[string]*LeaseRange `json:"lease_ranges,omitempty"`
 }
 
 // NetworkInfo contains the network information.
@@ -221,6 +288,126 @@ type LeaseRange struct {
 	EndIP net.IP `json:"end_ip,omitempty"`
 }
 
+// IPAMLease describes an ip address allocated by the ipam of the network
+// backend.
+type IPAMLease struct {
+	// Network is the name of the network the ip was allocated on.
+	Network string `json:"network"`
+	// Subnet the ip belongs to.
+	// swagger:strfmt string
+	Subnet IPNet `json:"subnet"`
+	// IP is the allocated ip.
+	// swagger:strfmt string
+	IP net.IP `json:"ip"`
+	// ContainerID is the ID of the container the ip is allocated to.
+	// It is empty for reserved ips.
+	ContainerID string `json:"container_id,omitempty"`
+	// Reserved is true if the ip was reserved with IPAMReserve.
+	Reserved bool `json:"reserved,omitempty"`
+	// AllocatedAt is the time the ip was allocated. It is zero when not
+	// known, e.g. for leases created by older versions.
+	AllocatedAt time.Time `json:"allocated_at"`
+}
+
+// IPAMReclaimOptions are the options for IPAMReclaim.
+type IPAMReclaimOptions struct {
+	// ContainerExists must return true if the container with the given ID
+	// still exists, the leases of all other containers are reclaimed.
+	// Required.
+	ContainerExists func(containerID string) bool
+	// DryRun only reports the leases which would be reclaimed.
+	DryRun bool
+}
+
+// NetworkExportVersion is the version of the NetworkExport format written by
+// NetworkExport.
+const NetworkExportVersion = 1
+
+// NetworkExport is a portable document describing networks, it can be
+// imported with NetworkImport by both the netavark and CNI backends.
+type NetworkExport struct {
+	// Version of the export format.
+	Version int `json:"version"`
+	// Backend the networks were exported from.
+	Backend NetworkBackend `json:"backend"`
+	// Networks contains the exported networks sorted by name.
+	Networks []ExportedNetwork `json:"networks"`
+}
+
+// ExportedNetwork is a network with its IPAM state in a NetworkExport.
+type ExportedNetwork struct {
+	Network
+	// Reservations contains the ip ranges reserved with IPAMReserve.
+	Reservations []LeaseRange `json:"ipam_reservations,omitempty"`
+}
+
+// NetworkImportOptions are the options for NetworkImport.
+type NetworkImportOptions struct {
+	// IgnoreIfExists skips networks which already exist instead of
+	// failing the import.
+	IgnoreIfExists bool
+}
+
+// NetworkReconcileOptions are the options for NetworkReconcile.
+type NetworkReconcileOptions struct {
+	// RunningContainers contains the IDs of all running containers, the
+	// network resources of all other containers are orphans.
+	RunningContainers []string
+	// DryRun only reports the orphans.
+	DryRun bool
+}
+
+// NetworkReconcileReport lists the orphaned network resources.
+type NetworkReconcileReport struct {
+	// Leases are the IPAM leases of containers which are not running.
+	Leases []IPAMLease `json:"leases,omitempty"`
+	// IPAMNetworks are networks which no longer exist but still have
+	// IPAM state.
+	IPAMNetworks []string `json:"ipam_networks,omitempty"`
+	// Attachments are the attachments of containers which are not running.
+	// They are removed by running the teardown of the backend which also
+	// removes their firewall rules. The netavark backend derives them from
+	// the stale leases, they have no interface name and their port
+	// mappings are unknown.
+	Attachments []OrphanedAttachment `json:"attachments,omitempty"`
+	// Interfaces are the veths on the bridges of networks without a
+	// running container, bridges which were not created by the backend
+	// and unmanaged networks are skipped. They are only checked as root,
+	// the rootless netns is removed together with its interfaces when the
+	// last container is stopped.
+	Interfaces []OrphanedInterface `json:"interfaces,omitempty"`
+	// Ports are the host port reservations of containers which are not
+	// running, they are released from the port registry of the backend.
+	Ports []OrphanedPorts `json:"ports,omitempty"`
+}
+
+// OrphanedPorts are the host ports reserved by a container which is not
+// running.
+type OrphanedPorts struct {
+	ContainerID  string        `json:"container_id"`
+	PortMappings []PortMapping `json:"port_mappings"`
+}
+
+// OrphanedAttachment is a network attachment of a container which is not
+// running.
+type OrphanedAttachment struct {
+	ContainerID string `json:"container_id"`
+	Network     string `json:"network"`
+	// InterfaceName is the interface name in the container.
+	InterfaceName string `json:"interface_name"`
+}
+
+// OrphanedInterface is a host interface of a bridge network without a
+// running container.
+type OrphanedInterface struct {
+	// Name of the interface.
+	Name string `json:"name"`
+	// Network the interface belongs to.
+	Network string `json:"network"`
+	// Type is bridge or veth.
+	Type string `json:"type"`
+}
+
 // StatusBlock contains the network information about a container
 // connected to one Network.
 type StatusBlock struct {
@@ -271,6 +458,30 @@ type PerNetworkOptions struct {
 	InterfaceName string `json:"interface_name"`
 	// Driver-specific options for this container.
 	Options map[string]string `json:"options,omitempty"`
+	// Bandwidth limits the traffic of the container on this network.
+	// Only bridge networks support it with the CNI backend. Optional.
+	Bandwidth *BandwidthOptions `json:"bandwidth,omitempty"`
+	// Routes are added in the container in addition to the routes of the
+	// network. Optional.
+	Routes []Route `json:"routes,omitempty"`
+	// DefaultRouteMetric overwrites the metric of the default route of
+	// this network in the container. Optional.
+	// Not supported by the CNI backend.
+	DefaultRouteMetric *uint32 `json:"default_route_metric,omitempty"`
+}
+
+// BandwidthOptions describes the traffic shaping for a container on one
+// network. Rates are in bits per second and bursts in bits, a rate must
+// always be set together with its burst.
+type BandwidthOptions struct {
+	// IngressRate limits the traffic sent to the container.
+	IngressRate uint64 `json:"ingress_rate,omitempty"`
+	// IngressBurst is the amount of traffic which may exceed IngressRate.
+	IngressBurst uint64 `json:"ingress_burst,omitempty"`
+	// EgressRate limits the traffic sent by the container.
+	EgressRate uint64 `json:"egress_rate,omitempty"`
+	// EgressBurst is the amount of traffic which may exceed EgressRate.
+	EgressBurst uint64 `json:"egress_burst,omitempty"`
 }
 
 // NetworkOptions for a given container.
@@ -289,6 +500,11 @@ type NetworkOptions struct {
 	DNSServers []string `json:"dns_servers,omitempty"`
 	// ContainerHostname is the configured DNS hostname of the container.
 	ContainerHostname string `json:"container_hostname"`
+	// DefaultRouteNetwork is the name of the network which provides the
+	// default route when the container is connected to several networks,
+	// the default routes of the other networks are not added. Optional,
+	// by default all networks add their default route.
+	DefaultRouteNetwork string `json:"default_route_network,omitempty"`
 }
 
 // PortMapping is one or more ports that will be mapped into the container.
@@ -352,6 +568,41 @@ type RootlessNetnsInfo struct {
 	MapGuestIps []string
 }
 
+// RootlessNetnsStatus contains diagnostics about the rootless netns.
+type RootlessNetnsStatus struct {
+	// Path of the netns.
+	Path string `json:"path"`
+	// Exists is true when the netns exists, it is created by the first
+	// Setup and removed when the last container is torn down.
+	Exists bool `json:"exists"`
+	// Program providing the connectivity, pasta or slirp4netns.
+	Program string `json:"program"`
+	// Pid of the program, zero when the pid file cannot be read.
+	Pid int `json:"pid,omitempty"`
+	// Running is true when the program is still running.
+	Running bool `json:"running"`
+	// RefCount is the number of container networks using the netns.
+	RefCount int `json:"ref_count"`
+	// IPAddresses used in the netns.
+	IPAddresses []net.IP `json:"ip_addresses,omitempty"`
+	// DNSForwardIPs are the nameservers in the netns resolv.conf.
+	DNSForwardIPs []string `json:"dns_forward_ips,omitempty"`
+	// MapGuestIPs are used for the host.containers.internal entry.
+	MapGuestIPs []string `json:"map_guest_ips,omitempty"`
+	// ResolvConf is the resolv.conf file mounted in the netns.
+	ResolvConf string `json:"resolv_conf"`
+	// MountError is set when a source of the mounts in the netns is
+	// missing.
+	MountError string `json:"mount_error,omitempty"`
+}
+
+// RootlessNetnsRecoverOptions are the options for RootlessNetnsRecover.
+type RootlessNetnsRecoverOptions struct {
+	// Force restarts pasta or slirp4netns even when it is still running,
+	// e.g. when it hangs.
+	Force bool
+}
+
 // FilterFunc can be passed to NetworkList to filter the networks.
 type FilterFunc func(Network) bool
 
@@ -2,18 +2,40 @@ package util
 
 import (
 	"fmt"
+	"net"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/common/pkg/filters"
 	"github.com/containers/common/pkg/util"
+	"github.com/sirupsen/logrus"
 )
 
+// IsNetworkInUseFunc must return true when a container is connected to the
+// network. It is used by the dangling and in-use filters, the definition of
+// in use is up to the caller.
+type IsNetworkInUseFunc func(network types.Network) (bool, error)
+
+// NetworkFilterOptions are the options for GenerateNetworkFiltersWithOptions
+// and GenerateNetworkPruneFiltersWithOptions.
+type NetworkFilterOptions struct {
+	// IsNetworkInUse is required for the dangling and in-use filters.
+	IsNetworkInUse IsNetworkInUseFunc
+}
+
 func GenerateNetworkFilters(f map[string][]string) ([]types.FilterFunc, error) {
+	return GenerateNetworkFiltersWithOptions(f, nil)
+}
+
+// GenerateNetworkFiltersWithOptions is like GenerateNetworkFilters but
+// supports filters which need information from the caller.
+func GenerateNetworkFiltersWithOptions(f map[string][]string, options *NetworkFilterOptions) ([]types.FilterFunc, error) {
 	filterFuncs := make([]types.FilterFunc, 0, len(f))
 	for key, filterValues := range f {
-		filterFunc, err := createFilterFuncs(key, filterValues)
+		filterFunc, err := createFilterFuncs(key, filterValues, options)
 		if err != nil {
 			return nil, err
 		}
@@ -22,7 +44,7 @@ func GenerateNetworkFilters(f map[string][]string) ([]types.FilterFunc, error) {
 	return filterFuncs, nil
 }
 
-func createFilterFuncs(key string, filterValues []string) (types.FilterFunc, error) {
+func createFilterFuncs(key string, filterValues []string, options *NetworkFilterOptions) (types.FilterFunc, error) {
 	switch strings.ToLower(key) {
 	case "name":
 		// matches one name, regex allowed
@@ -42,15 +64,20 @@ func createFilterFuncs(key string, filterValues []string) (types.FilterFunc, err
 			return filters.FilterID(net.ID, filterValues)
 		}, nil
 
-		// TODO: add dns enabled, internal filter
 	}
-	return createPruneFilterFuncs(key, filterValues)
+	return createPruneFilterFuncs(key, filterValues, options)
 }
 
 func GenerateNetworkPruneFilters(f map[string][]string) ([]types.FilterFunc, error) {
+	return GenerateNetworkPruneFiltersWithOptions(f, nil)
+}
+
+// GenerateNetworkPruneFiltersWithOptions is like GenerateNetworkPruneFilters
+// but supports filters which need information from the caller.
+func GenerateNetworkPruneFiltersWithOptions(f map[string][]string, options *NetworkFilterOptions) ([]types.FilterFunc, error) {
 	filterFuncs := make([]types.FilterFunc, 0, len(f))
 	for key, filterValues := range f {
-		filterFunc, err := createPruneFilterFuncs(key, filterValues)
+		filterFunc, err := createPruneFilterFuncs(key, filterValues, options)
 		if err != nil {
 			return nil, err
 		}
@@ -59,7 +86,7 @@ func GenerateNetworkPruneFilters(f map[string][]string) ([]types.FilterFunc, err
 	return filterFuncs, nil
 }
 
-func createPruneFilterFuncs(key string, filterValues []string) (types.FilterFunc, error) {
+func createPruneFilterFuncs(key string, filterValues []string, options *NetworkFilterOptions) (types.FilterFunc, error) {
 	switch strings.ToLower(key) {
 	case "label":
 		// matches all labels
@@ -70,6 +97,73 @@ func createPruneFilterFuncs(key string, filterValues []string) (types.FilterFunc
 		return func(net types.Network) bool {
 			return filters.MatchNegatedLabelFilters(filterValues, net.Labels)
 		}, nil
+	case "label-regex":
+		// matches all labels, the value is a regex
+		return createLabelRegexFilter(filterValues)
+	case "dns_enabled":
+		return createBoolFilter(key, filterValues, func(net types.Network) bool {
+			return net.DNSEnabled
+		})
+	case "internal":
+		return createBoolFilter(key, filterValues, func(net types.Network) bool {
+			return net.Internal
+		})
+	case "ipv6":
+		return createBoolFilter(key, filterValues, func(net types.Network) bool {
+			return net.IPv6Enabled
+		})
+	case "subnet":
+		// matches one subnet, a cidr matches overlapping subnets and an ip
+		// the subnet which contains it
+		return createSubnetFilter(filterValues)
+	case "gateway":
+		// matches one gateway
+		ips := make([]net.IP, 0, len(filterValues))
+		for _, value := range filterValues {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid gateway filter value %q", value)
+			}
+			ips = append(ips, ip)
+		}
+		return func(net types.Network) bool {
+			for _, subnet := range net.Subnets {
+				if slices.ContainsFunc(ips, subnet.Gateway.Equal) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "option":
+		// matches all options, given as key or key=value
+		return func(net types.Network) bool {
+			for _, value := range filterValues {
+				k, v, hasValue := strings.Cut(value, "=")
+				val, ok := net.Options[k]
+				if !ok || (hasValue && val != v) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case "dangling", "in-use":
+		if options == nil || options.IsNetworkInUse == nil {
+			return nil, fmt.Errorf("filter %q is not supported", key)
+		}
+		want, err := parseBoolFilterValues(key, filterValues)
+		if err != nil {
+			return nil, err
+		}
+		dangling := strings.ToLower(key) == "dangling"
+		return func(net types.Network) bool {
+			inUse, err := options.IsNetworkInUse(net)
+			if err != nil {
+				// never treat a network as unused when we do not know
+				logrus.Warnf("Failed to check if network %s is in use: %v", net.Name, err)
+				inUse = true
+			}
+			return (inUse != dangling) == want
+		}, nil
 	case "until":
 		until, err := filters.ComputeUntilTimestamp(filterValues)
 		if err != nil {
@@ -82,3 +176,88 @@ func createPruneFilterFuncs(key string, filterValues []string) (types.FilterFunc
 		return nil, fmt.Errorf("invalid filter %q", key)
 	}
 }
+
+// parseBoolFilterValues parses the values of a bool filter, all values must
+// be the same.
+func parseBoolFilterValues(key string, filterValues []string) (bool, error) {
+	if len(filterValues) == 0 {
+		return true, nil
+	}
+	var want bool
+	for i, value := range filterValues {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s filter value %q: %w", key, value, err)
+		}
+		if i > 0 && b != want {
+			return false, fmt.Errorf("conflicting %s filter values", key)
+		}
+		want = b
+	}
+	return want, nil
+}
+
+func createBoolFilter(key string, filterValues []string, get func(types.Network) bool) (types.FilterFunc, error) {
+	want, err := parseBoolFilterValues(key, filterValues)
+	if err != nil {
+		return nil, err
+	}
+	return func(net types.Network) bool {
+		return get(net) == want
+	}, nil
+}
+
+func createLabelRegexFilter(filterValues []string) (types.FilterFunc, error) {
+	type labelRegex struct {
+		key   string
+		value *regexp.Regexp
+	}
+	labels := make([]labelRegex, 0, len(filterValues))
+	for _, filterValue := range filterValues {
+		key, value, _ := strings.Cut(filterValue, "=")
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid label-regex filter value %q: %w", filterValue, err)
+		}
+		labels = append(labels, labelRegex{key: key, value: re})
+	}
+	return func(net types.Network) bool {
+		for _, label := range labels {
+			val, ok := net.Labels[label.key]
+			if !ok || !label.value.MatchString(val) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func createSubnetFilter(filterValues []string) (types.FilterFunc, error) {
+	subnets := make([]*net.IPNet, 0, len(filterValues))
+	for _, value := range filterValues {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet filter value %q: %w", value, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return func(net types.Network) bool {
+		for _, s := range net.Subnets {
+			for _, subnet := range subnets {
+				if s.Subnet.Contains(subnet.IP) || subnet.Contains(s.Subnet.IP) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
@@ -1,6 +1,12 @@
 package util
 
-import "testing"
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/containers/common/libnetwork/types"
+)
 
 func TestGenerateFilterFunc(t *testing.T) {
 	testValues := []string{
@@ -37,10 +43,90 @@ func TestGenerateFilterFunc(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			for _, entry := range tt.args.keys {
-				if _, err := createFilterFuncs(entry, tt.args.labels); err != nil {
+				if _, err := createFilterFuncs(entry, tt.args.labels, nil); err != nil {
 					t.Errorf("createPruneFilterFuncs() failed on %s with entry %s: %s", tt.name, entry, err.Error())
 				}
 			}
 		})
 	}
 }
+
+func TestNetworkFilters(t *testing.T) {
+	subnet1, _ := types.ParseCIDR("10.89.0.0/24")
+	subnet2, _ := types.ParseCIDR("fd10::/64")
+	network1 := types.Network{
+		Name:        "net1",
+		DNSEnabled:  true,
+		IPv6Enabled: true,
+		Subnets: []types.Subnet{
+			{Subnet: subnet1, Gateway: net.ParseIP("10.89.0.1")},
+			{Subnet: subnet2, Gateway: net.ParseIP("fd10::1")},
+		},
+		Labels:  map[string]string{"app": "web-1"},
+		Options: map[string]string{types.MTUOption: "1500"},
+	}
+	network2 := types.Network{
+		Name:     "net2",
+		Internal: true,
+	}
+	options := &NetworkFilterOptions{
+		IsNetworkInUse: func(network types.Network) (bool, error) {
+			return network.Name == "net1", nil
+		},
+	}
+
+	tests := []struct {
+		name    string
+		filters map[string][]string
+		want    []string
+		wantErr bool
+	}{
+		{name: "dns enabled", filters: map[string][]string{"dns_enabled": {"true"}}, want: []string{"net1"}},
+		{name: "internal", filters: map[string][]string{"internal": {"1"}}, want: []string{"net2"}},
+		{name: "not internal", filters: map[string][]string{"internal": {"false"}}, want: []string{"net1"}},
+		{name: "ipv6", filters: map[string][]string{"ipv6": {"true"}}, want: []string{"net1"}},
+		{name: "invalid bool", filters: map[string][]string{"ipv6": {"maybe"}}, wantErr: true},
+		{name: "conflicting bool", filters: map[string][]string{"internal": {"true", "false"}}, wantErr: true},
+		{name: "subnet contained", filters: map[string][]string{"subnet": {"10.89.0.128/25"}}, want: []string{"net1"}},
+		{name: "subnet containing", filters: map[string][]string{"subnet": {"10.0.0.0/8"}}, want: []string{"net1"}},
+		{name: "subnet ip", filters: map[string][]string{"subnet": {"fd10::5"}}, want: []string{"net1"}},
+		{name: "subnet no overlap", filters: map[string][]string{"subnet": {"10.90.0.0/24"}}, want: []string{}},
+		{name: "invalid subnet", filters: map[string][]string{"subnet": {"10.90.0.0/33"}}, wantErr: true},
+		{name: "gateway", filters: map[string][]string{"gateway": {"fd10::1"}}, want: []string{"net1"}},
+		{name: "option key", filters: map[string][]string{"option": {"mtu"}}, want: []string{"net1"}},
+		{name: "option value", filters: map[string][]string{"option": {"mtu=9000"}}, want: []string{}},
+		{name: "label regex", filters: map[string][]string{"label-regex": {"app=web-[0-9]+"}}, want: []string{"net1"}},
+		{name: "label regex anchored", filters: map[string][]string{"label-regex": {"app=web"}}, want: []string{}},
+		{name: "dangling", filters: map[string][]string{"dangling": {"true"}}, want: []string{"net2"}},
+		{name: "in use", filters: map[string][]string{"in-use": {}}, want: []string{"net1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filterFuncs, err := GenerateNetworkFiltersWithOptions(tt.filters, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateNetworkFiltersWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := []string{}
+		outer:
+			for _, network := range []types.Network{network1, network2} {
+				for _, filter := range filterFuncs {
+					if !filter(network) {
+						continue outer
+					}
+				}
+				got = append(got, network.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filtered networks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the in use filters need the callback
+	if _, err := GenerateNetworkPruneFilters(map[string][]string{"dangling": {"true"}}); err == nil {
+		t.Error("GenerateNetworkPruneFilters() expected error for dangling filter without callback")
+	}
+}
@@ -613,9 +613,15 @@ type NetworkConfig struct {
 	// allocate subnets automatically for podman network create.
 	// It will iterate through the list and will pick the first free subnet
 	// with the given size. This is only used for ipv4 subnets, ipv6 subnets
-	// are always assigned randomly.
+	// are assigned from DefaultIPv6SubnetPools.
 	DefaultSubnetPools []SubnetPool `toml:"default_subnet_pools,omitempty"`
 
+	// DefaultIPv6SubnetPools is a list of ipv6 subnets and size which are
+	// used to allocate ipv6 subnets automatically for podman network create.
+	// It works like DefaultSubnetPools. If it is empty a random unique local
+	// /64 subnet is used.
+	DefaultIPv6SubnetPools []SubnetPool `toml:"default_ipv6_subnet_pools,omitempty"`
+
 	// DefaultRootlessNetworkCmd is used to set the default rootless network
 	// program, either "slirp4nents" (default) or "pasta".
 	DefaultRootlessNetworkCmd string `toml:"default_rootless_network_cmd,omitempty"`
@@ -623,6 +629,18 @@ type NetworkConfig struct {
 	// NetworkConfigDir is where network configuration files are stored.
 	NetworkConfigDir string `toml:"network_config_dir,omitempty"`
 
+	// NetworkDefinitionsDir is a directory with declarative network
+	// definitions in the JSON network format. Networks which are defined
+	// there but do not exist are created when the network backend is
+	// initialized, differences to existing networks are logged. The
+	// differences are only checked again once the definitions change.
+	NetworkDefinitionsDir string `toml:"network_definitions_dir,omitempty"`
+
+	// NetworkDefinitionsUpdate applies the differences between the network
+	// definitions and the existing networks where possible instead of only
+	// logging them.
+	NetworkDefinitionsUpdate bool `toml:"network_definitions_update,omitempty"`
+
 	// DNSBindPort is the port that should be used by dns forwarding daemon
 	// for netavark rootful bridges with dns enabled. This can be necessary
 	// when other dns forwarders run on the machine. 53 is used if unset.
@@ -631,6 +649,10 @@ type NetworkConfig struct {
 	// PastaOptions contains a default list of pasta(1) options that should
 	// be used when running pasta.
 	PastaOptions attributedstring.Slice `toml:"pasta_options,omitempty"`
+
+	// Pasta contains the typed pasta(1) options, the options which are also
+	// set in PastaOptions are dropped.
+	Pasta PastaConfig `toml:"pasta,omitempty"`
 }
 
 type SubnetPool struct {
@@ -694,6 +716,22 @@ type FarmConfig struct {
 	Default string `json:",omitempty" toml:"default,omitempty"`
 	// List is a map of farms created where key=farm-name and value=list of connections
 	List map[string][]string `json:",omitempty" toml:"list,omitempty"`
+	// Nodes is a map of scheduling metadata where key=connection-name
+	Nodes map[string]FarmNode `json:",omitempty" toml:"nodes,omitempty"`
+}
+
+// FarmNode describes the scheduling metadata of a connection used as a farm node
+type FarmNode struct {
+	// Platforms the node can build natively, e.g. "linux/arm64"
+	Platforms []string `json:",omitempty" toml:"platforms,omitempty"`
+	// CPUs is the number of CPUs available on the node, 0 means unknown
+	CPUs int `json:",omitempty" toml:"cpus,omitempty,omitzero"`
+	// Weight is the relative share of jobs scheduled to the node, defaults to 1
+	Weight int `json:",omitempty" toml:"weight,omitempty,omitzero"`
+	// Labels are arbitrary key=value pairs used to restrict node selection
+	Labels map[string]string `json:",omitempty" toml:"labels,omitempty"`
+	// MaxJobs is the maximum number of concurrent jobs on the node, 0 means unlimited
+	MaxJobs int `json:",omitempty" toml:"max_jobs,omitempty,omitzero"`
 }
 
 // Destination represents destination for remote service
@@ -701,7 +739,8 @@ type Destination struct {
 	// URI, required. Example: ssh://root@example.com:22/run/podman/podman.sock
 	URI string `toml:"uri"`
 
-	// Identity file with ssh key, optional
+	// Identity file with ssh key, optional. Can also reference a key held by
+	// the ssh-agent ("agent:"), a PKCS#11 token ("pkcs11:") or a secret ("secret:").
 	Identity string `json:",omitempty" toml:"identity,omitempty"`
 
 	// isMachine describes if the remote destination is a machine.
@@ -919,8 +958,20 @@ func (c *NetworkConfig) Validate() error {
 			}
 		}
 	}
+	for _, pool := range c.DefaultIPv6SubnetPools {
+		if pool.Base == nil || pool.Base.IP.To4() != nil {
+			return fmt.Errorf("invalid ipv6 subnet pool %v", pool.Base)
+		}
+		ones, _ := pool.Base.IPNet.Mask.Size()
+		if ones > pool.Size {
+			return fmt.Errorf("invalid ipv6 subnet pool, size is bigger than subnet %q", &pool.Base.IPNet)
+		}
+		if pool.Size > 128 {
+			return errors.New("invalid ipv6 subnet pool size, must be between 0-128")
+		}
+	}
 
-	return nil
+	return c.Pasta.Validate()
 }
 
 // FindConmon iterates over (*Config).ConmonPath and returns the path
@@ -87,6 +87,27 @@ var _ = Describe("Config Local", func() {
 
 		// Then
 		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		// ipv4 subnets are not valid ipv6 pools
+		defConf.Network.DefaultSubnetPools = nil
+		defConf.Network.DefaultIPv6SubnetPools = []SubnetPool{
+			{Base: &net, Size: 28},
+		}
+		err = defConf.Network.Validate()
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		net6, _ := types.ParseCIDR("fd00::/48")
+		defConf.Network.DefaultIPv6SubnetPools = []SubnetPool{
+			{Base: &net6, Size: 129},
+		}
+		err = defConf.Network.Validate()
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		defConf.Network.DefaultIPv6SubnetPools = []SubnetPool{
+			{Base: &net6, Size: 64},
+		}
+		err = defConf.Network.Validate()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
 	})
 
 	It("parse network subnet pool", func() {
@@ -104,6 +125,13 @@ var _ = Describe("Config Local", func() {
 				Size: 24,
 			}},
 		))
+		net3, _ := types.ParseCIDR("2001:db8:1::/48")
+		gomega.Expect(config.Network.DefaultIPv6SubnetPools).To(gomega.Equal(
+			[]SubnetPool{{
+				Base: &net3,
+				Size: 64,
+			}},
+		))
 	})
 
 	It("parse dns port", func() {
@@ -142,6 +170,54 @@ var _ = Describe("Config Local", func() {
 		gomega.Expect(config2.Network.PastaOptions.Get()).To(gomega.Equal([]string{"-t", "auto"}))
 	})
 
+	It("parse network.pasta", func() {
+		// Given
+		config, err := newLocked(&Options{}, &paths{})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(config.Network.Pasta).To(gomega.BeZero())
+		// When
+		config2, err := newLocked(&Options{}, &paths{etc: "testdata/containers_default.conf"})
+		// Then
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(config2.Network.Pasta).To(gomega.Equal(PastaConfig{
+			TCPPorts:          []string{"8080", "127.0.0.1/443:8443"},
+			UDPNamespacePorts: []string{PastaPortsAuto},
+			OutboundInterface: "eth0",
+			MTU:               1500,
+			IPv4Only:          true,
+			DNSForward:        []string{"10.0.2.3"},
+		}))
+	})
+
+	It("should fail on invalid pasta options", func() {
+		for _, pasta := range []PastaConfig{
+			{TCPPorts: []string{"auto", "8080"}},
+			{TCPPorts: []string{"0"}},
+			{UDPPorts: []string{"90-80"}},
+			{TCPPorts: []string{"localhost/80"}},
+			{UDPNamespacePorts: []string{PastaPortsAll}},
+			{Interface: "eth0 eth1"},
+			{NamespaceInterface: "averyveryverylongname"},
+			{MTU: 67},
+			{IPv4Only: true, IPv6Only: true},
+			{DNSForward: []string{"dns"}},
+			{DNSHost: "1.1.1"},
+			{MapGuestAddress: []string{"host"}},
+		} {
+			conf := NetworkConfig{Pasta: pasta}
+			gomega.Expect(conf.Validate()).To(gomega.HaveOccurred(), "%+v", pasta)
+		}
+
+		conf := NetworkConfig{Pasta: PastaConfig{
+			TCPPorts:          []string{"8080", "::1/80:8080", "127.0.0.1%lo/1024-2048,~1500"},
+			TCPNamespacePorts: []string{PastaPortsAuto},
+			MTU:               65520,
+			IPv6Only:          true,
+			DNSForward:        []string{"fd00::53"},
+		}}
+		gomega.Expect(conf.Validate()).ToNot(gomega.HaveOccurred())
+	})
+
 	It("parse default_rootless_network_cmd", func() {
 		// Given
 		config, err := newLocked(&Options{}, &paths{})
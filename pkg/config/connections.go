@@ -130,6 +130,9 @@ func EditConnectionConfig(callback func(cfg *ConnectionsFile) error) error {
 	if conf.Farm.List == nil {
 		conf.Farm.List = make(map[string][]string)
 	}
+	if conf.Farm.Nodes == nil {
+		conf.Farm.Nodes = make(map[string]FarmNode)
+	}
 
 	if err := callback(conf); err != nil {
 		return err
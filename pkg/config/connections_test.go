@@ -235,6 +235,54 @@ var _ = Describe("Connections conf", func() {
 			))
 		})
 
+		It("SelectFarmNodes()", func() {
+			err := EditConnectionConfig(func(cfg *ConnectionsFile) error {
+				cfg.Connection.Connections["arm"] = Destination{URI: "ssh://arm"}
+				cfg.Connection.Connections["amd"] = Destination{URI: "ssh://amd"}
+				cfg.Farm.List["farm1"] = []string{"test", "arm", "amd"}
+				cfg.Farm.Nodes["test"] = FarmNode{Platforms: []string{"linux/amd64"}, CPUs: 2}
+				cfg.Farm.Nodes["amd"] = FarmNode{Platforms: []string{"linux/amd64", "linux/386"}, CPUs: 8, MaxJobs: 1, Labels: map[string]string{"ssd": "true"}}
+				cfg.Farm.Nodes["arm"] = FarmNode{Platforms: []string{"linux/arm64", "linux/arm/v7"}, Weight: 2}
+				return nil
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			conf, err := newLocked(&Options{}, &paths{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			node, err := conf.GetFarmNode("arm")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(node.Weight).To(gomega.Equal(2))
+
+			sel, err := conf.SelectFarmNodes("", &FarmNodeSelectOptions{Platforms: []string{"linux/arm", "linux/amd64", "linux/amd64"}})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(sel).To(gomega.HaveLen(3))
+			gomega.Expect(sel[0].Connection.Name).To(gomega.Equal("arm"))
+			// amd has more CPUs but can only run a single job
+			gomega.Expect(sel[1].Connection.Name).To(gomega.Equal("amd"))
+			gomega.Expect(sel[2].Connection.Name).To(gomega.Equal("test"))
+
+			// load reported by the nodes moves jobs away from busy nodes
+			sel, err = conf.SelectFarmNodes("farm1", &FarmNodeSelectOptions{
+				Platforms: []string{"linux/amd64"},
+				Load:      map[string]FarmNodeLoad{"amd": {CPUUtilization: 95}},
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(sel[0].Connection.Name).To(gomega.Equal("test"))
+
+			sel, err = conf.SelectFarmNodes("farm1", &FarmNodeSelectOptions{
+				Platforms: []string{"linux/amd64"},
+				Labels:    map[string]string{"ssd": "true"},
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(sel[0].Connection.Name).To(gomega.Equal("amd"))
+
+			_, err = conf.SelectFarmNodes("farm1", &FarmNodeSelectOptions{
+				Platforms: []string{"linux/386"},
+				Load:      map[string]FarmNodeLoad{"amd": {RunningJobs: 1}},
+			})
+			gomega.Expect(err).To(gomega.MatchError(ErrNoFarmNode))
+		})
+
 		It("GetAllFarms()", func() {
 			conf, err := newLocked(&Options{}, &paths{})
 			gomega.Expect(err).ToNot(gomega.HaveOccurred())
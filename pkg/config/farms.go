@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// FarmNodeLoad is the current load of a farm node as reported by the node itself.
+type FarmNodeLoad struct {
+	// RunningJobs is the number of jobs currently running on the node
+	RunningJobs int
+	// CPUUtilization is the busy percentage of the node CPUs (0-100)
+	CPUUtilization float64
+	// CPUs reported by the node, used when the node metadata does not set it
+	CPUs int
+}
+
+// FarmNodeSelectOptions describes which nodes are wanted from a farm.
+type FarmNodeSelectOptions struct {
+	// Platforms that need a node, one node is selected per platform
+	Platforms []string
+	// Labels every selected node must carry
+	Labels map[string]string
+	// Load is the current load per connection name, nodes without an
+	// entry are assumed to be idle
+	Load map[string]FarmNodeLoad
+}
+
+// FarmNodeSelection is a node selected to build the given platform.
+type FarmNodeSelection struct {
+	// Platform to build on the node
+	Platform string
+	// Connection to the node
+	Connection Connection
+	// Node is the scheduling metadata of the connection
+	Node FarmNode
+}
+
+// ErrNoFarmNode is returned when no node of the farm can build a requested platform.
+var ErrNoFarmNode = errors.New("no farm node available")
+
+// normalizePlatform lowercases the platform and drops a trailing slash so
+// that "Linux/AMD64/" and "linux/amd64" match.
+func normalizePlatform(platform string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(platform)), "/")
+}
+
+// supportsPlatform returns true if the node can build the platform natively.
+// A platform without variant matches any variant of the node, e.g.
+// "linux/arm" matches a node listing "linux/arm/v7".
+func (n *FarmNode) supportsPlatform(platform string) bool {
+	want := normalizePlatform(platform)
+	for _, p := range n.Platforms {
+		have := normalizePlatform(p)
+		if have == want || strings.HasPrefix(have, want+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *FarmNode) matchesLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if val, ok := n.Labels[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+// score returns how suitable a node is for one more job, higher is better.
+func (n *FarmNode) score(load FarmNodeLoad, assigned int) float64 {
+	weight := n.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	cpus := n.CPUs
+	if cpus <= 0 {
+		cpus = load.CPUs
+	}
+	if cpus <= 0 {
+		cpus = 1
+	}
+	idle := 1 - min(max(load.CPUUtilization, 0), 100)/100
+	jobs := float64(load.RunningJobs + assigned + 1)
+	// the idle fraction is floored so that fully busy nodes can still be
+	// ranked by weight and job count
+	return float64(weight) * float64(cpus) * max(idle, 0.01) / jobs
+}
+
+// getFarmNodes returns the scheduling metadata for all nodes, entries in the
+// connections file override the ones in containers.conf.
+func (c *Config) getFarmNodes() (map[string]FarmNode, error) {
+	path, err := connectionsConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	conConf, err := readConnectionConf(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]FarmNode, len(c.Farms.Nodes)+len(conConf.Farm.Nodes))
+	maps.Copy(nodes, c.Farms.Nodes)
+	maps.Copy(nodes, conConf.Farm.Nodes)
+	return nodes, nil
+}
+
+// GetFarmNode returns the scheduling metadata of the given connection.
+// A connection without metadata returns an empty FarmNode.
+func (c *Config) GetFarmNode(name string) (FarmNode, error) {
+	nodes, err := c.getFarmNodes()
+	if err != nil {
+		return FarmNode{}, err
+	}
+	return nodes[name], nil
+}
+
+// SelectFarmNodes selects one node of the given farm for each requested platform,
+// if name is empty the default farm is used. Only nodes that build the platform
+// natively, carry all requested labels and have not reached their MaxJobs limit
+// are considered. Among those the node with the best ratio of weight and CPUs to
+// its current load is picked, nodes selected for an earlier platform count as
+// loaded with one more job. Ties are broken by connection name so the result is
+// deterministic.
+func (c *Config) SelectFarmNodes(name string, options *FarmNodeSelectOptions) ([]FarmNodeSelection, error) {
+	if options == nil {
+		options = &FarmNodeSelectOptions{}
+	}
+	var (
+		conns []Connection
+		err   error
+	)
+	if name == "" {
+		name, conns, err = c.GetDefaultFarmConnections()
+	} else {
+		conns, err = c.GetFarmConnections(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := c.getFarmNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(conns, func(a, b Connection) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	assigned := make(map[string]int, len(conns))
+	selections := make([]FarmNodeSelection, 0, len(options.Platforms))
+	for _, platform := range options.Platforms {
+		best := -1
+		var bestScore float64
+		for i := range conns {
+			node := nodes[conns[i].Name]
+			if !node.supportsPlatform(platform) || !node.matchesLabels(options.Labels) {
+				continue
+			}
+			load := options.Load[conns[i].Name]
+			if node.MaxJobs > 0 && load.RunningJobs+assigned[conns[i].Name] >= node.MaxJobs {
+				continue
+			}
+			if score := node.score(load, assigned[conns[i].Name]); best < 0 || score > bestScore {
+				best = i
+				bestScore = score
+			}
+		}
+		if best < 0 {
+			return nil, fmt.Errorf("farm %q platform %q: %w", name, platform, ErrNoFarmNode)
+		}
+		assigned[conns[best].Name]++
+		selections = append(selections, FarmNodeSelection{
+			Platform:   platform,
+			Connection: conns[best],
+			Node:       nodes[conns[best].Name],
+		})
+	}
+	return selections, nil
+}
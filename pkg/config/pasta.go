@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Port forwarding modes of pasta(1), they must be the only entry of a port
+// list.
+const (
+	// PastaPortsNone forwards no ports, this is the default.
+	PastaPortsNone = "none"
+	// PastaPortsAuto forwards the ports which are bound when pasta starts
+	// and keeps scanning for new ones.
+	PastaPortsAuto = "auto"
+	// PastaPortsAll forwards all unbound, non-ephemeral ports, it is only
+	// valid for ports forwarded into the container.
+	PastaPortsAll = "all"
+)
+
+// pastaMaxMTU is the largest mtu accepted by pasta(1).
+const pastaMaxMTU = 65520
+
+// PastaConfig represents the "network.pasta" TOML config table. It contains
+// typed pasta(1) options which are translated to the pasta arguments before
+// the raw PastaOptions, so the raw options can still overwrite them.
+type PastaConfig struct {
+	// TCPPorts and UDPPorts are forwarded from the host into the container.
+	// An entry is either a port spec in the pasta(1) format, e.g. "8080",
+	// "80:8080" or "127.0.0.1/1024-2048", or a mode which must be the only
+	// entry: "none", "auto" or "all".
+	TCPPorts []string `toml:"tcp_ports,omitempty"`
+	UDPPorts []string `toml:"udp_ports,omitempty"`
+	// TCPNamespacePorts and UDPNamespacePorts are forwarded from the
+	// container to the host, the "all" mode is not supported for them.
+	TCPNamespacePorts []string `toml:"tcp_namespace_ports,omitempty"`
+	UDPNamespacePorts []string `toml:"udp_namespace_ports,omitempty"`
+
+	// Interface is the host interface used to get the addresses and routes
+	// which are copied into the container.
+	Interface string `toml:"interface,omitempty"`
+	// OutboundInterface is the host interface for the outbound traffic of
+	// the container.
+	OutboundInterface string `toml:"outbound_interface,omitempty"`
+	// NamespaceInterface is the name of the interface in the container.
+	NamespaceInterface string `toml:"namespace_interface,omitempty"`
+	// MTU of the interface in the container.
+	MTU int `toml:"mtu,omitempty"`
+
+	// IPv4Only and IPv6Only disable the other ip family, only one of them
+	// can be set.
+	IPv4Only bool `toml:"ipv4_only,omitempty"`
+	IPv6Only bool `toml:"ipv6_only,omitempty"`
+
+	// DNSForward are the addresses in the container which forward dns
+	// queries to the host, 169.254.1.1 is used by default.
+	DNSForward []string `toml:"dns_forward,omitempty"`
+	// DNSHost is the nameserver on the host the forwarded queries are sent
+	// to, by default the first nameserver of the host is used.
+	DNSHost string `toml:"dns_host,omitempty"`
+
+	// MapGateway maps the gateway address in the container to the host.
+	MapGateway bool `toml:"map_gateway,omitempty"`
+	// MapGuestAddress are the addresses in the container which are mapped to
+	// the host, they are used for host.containers.internal. 169.254.1.2 is
+	// used by default.
+	MapGuestAddress []string `toml:"map_guest_address,omitempty"`
+}
+
+// Validate returns an error when the options cannot be translated to valid
+// pasta(1) arguments.
+func (c *PastaConfig) Validate() error {
+	for _, p := range []struct {
+		name     string
+		ports    []string
+		allowAll bool
+	}{
+		{"tcp_ports", c.TCPPorts, true},
+		{"udp_ports", c.UDPPorts, true},
+		{"tcp_namespace_ports", c.TCPNamespacePorts, false},
+		{"udp_namespace_ports", c.UDPNamespacePorts, false},
+	} {
+		if err := validatePastaPorts(p.ports, p.allowAll); err != nil {
+			return fmt.Errorf("invalid pasta %s: %w", p.name, err)
+		}
+	}
+	for _, i := range []struct{ name, iface string }{
+		{"interface", c.Interface},
+		{"outbound_interface", c.OutboundInterface},
+		{"namespace_interface", c.NamespaceInterface},
+	} {
+		if err := validatePastaInterface(i.iface); err != nil {
+			return fmt.Errorf("invalid pasta %s: %w", i.name, err)
+		}
+	}
+	if c.MTU != 0 && (c.MTU < 68 || c.MTU > pastaMaxMTU) {
+		return fmt.Errorf("invalid pasta mtu %d, it must be between 68 and %d", c.MTU, pastaMaxMTU)
+	}
+	if c.IPv4Only && c.IPv6Only {
+		return errors.New("pasta ipv4_only and ipv6_only cannot be set together")
+	}
+	for _, ip := range append(append([]string{}, c.DNSForward...), c.MapGuestAddress...) {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid pasta address %q", ip)
+		}
+	}
+	if c.DNSHost != "" && net.ParseIP(c.DNSHost) == nil {
+		return fmt.Errorf("invalid pasta dns_host %q", c.DNSHost)
+	}
+	return nil
+}
+
+func validatePastaPorts(ports []string, allowAll bool) error {
+	for _, spec := range ports {
+		switch spec {
+		case PastaPortsNone, PastaPortsAuto, PastaPortsAll:
+			if spec == PastaPortsAll && !allowAll {
+				return fmt.Errorf("mode %q is not supported", spec)
+			}
+			if len(ports) > 1 {
+				return fmt.Errorf("mode %q must be the only entry", spec)
+			}
+		default:
+			if err := validatePastaPortSpec(spec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validatePastaPortSpec checks a spec in the [address[%interface]/]ports[:ports]
+// format, ports is a comma separated list of ports and ranges which can be
+// excluded with "~".
+func validatePastaPortSpec(spec string) error {
+	ports := spec
+	if i := strings.LastIndex(spec, "/"); i != -1 {
+		addr, _, _ := strings.Cut(spec[:i], "%")
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid address in port spec %q", spec)
+		}
+		ports = spec[i+1:]
+	}
+	for _, part := range strings.Split(ports, ",") {
+		part = strings.TrimPrefix(part, "~")
+		hostPorts, containerPorts, hasMapping := strings.Cut(part, ":")
+		if err := validatePastaPortRange(hostPorts); err != nil {
+			return fmt.Errorf("invalid port spec %q: %w", spec, err)
+		}
+		if hasMapping {
+			if err := validatePastaPortRange(containerPorts); err != nil {
+				return fmt.Errorf("invalid port spec %q: %w", spec, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validatePastaPortRange(ports string) error {
+	first, last, isRange := strings.Cut(ports, "-")
+	start, err := strconv.ParseUint(first, 10, 16)
+	if err != nil || start == 0 {
+		return fmt.Errorf("invalid port %q", first)
+	}
+	if isRange {
+		end, err := strconv.ParseUint(last, 10, 16)
+		if err != nil || end < start {
+			return fmt.Errorf("invalid port range %q", ports)
+		}
+	}
+	return nil
+}
+
+func validatePastaInterface(name string) error {
+	// IFNAMSIZ minus the terminating null byte
+	if len(name) > 15 || strings.ContainsFunc(name, func(r rune) bool {
+		return r == '/' || r == ':' || unicode.IsSpace(r)
+	}) {
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+	return nil
+}
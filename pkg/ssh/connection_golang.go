@@ -115,14 +115,11 @@ func golangConnectionExec(options ConnectionExecOptions, input io.Reader) (*Conn
 		return nil, err
 	}
 
-	cfg, err := ValidateAndConfigure(uri, options.Identity, false)
+	dialAdd, release, err := connectionClient(options.Pool, uri, options.Identity)
 	if err != nil {
 		return nil, err
 	}
-	dialAdd, err := ssh.Dial("tcp", uri.Host, cfg) // dial the client
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect: %w", err)
-	}
+	defer release()
 
 	out, err := ExecRemoteCommandWithInput(dialAdd, strings.Join(options.Args, " "), input)
 	if err != nil {
@@ -145,52 +142,48 @@ func golangConnectionScp(options ConnectionScpOptions) (*ConnectionScpReport, er
 	if err != nil {
 		return nil, err
 	}
-	cfg, err := ValidateAndConfigure(uri, options.Identity, false)
+	dial, release, err := connectionClient(options.Pool, uri, options.Identity)
 	if err != nil {
 		return nil, err
 	}
-
-	dial, err := ssh.Dial("tcp", uri.Host, cfg) // dial the client
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect: %w", err)
-	}
+	defer release()
 	sc, err := sftp.NewClient(dial)
 	if err != nil {
 		return nil, err
 	}
+	defer sc.Close()
 
-	f, err := os.OpenFile(localFile, (os.O_RDWR | os.O_CREATE), 0o644)
-	if err != nil {
-		return nil, err
-	}
-
-	parent := filepath.Dir(remoteFile)
-	path := string(filepath.Separator)
-	dirs := strings.Split(parent, path)
-	for _, dir := range dirs {
-		path = filepath.Join(path, dir)
-		// ignore errors due to most of the dirs already existing
-		_ = sc.Mkdir(path)
+	if swap {
+		_, err = CopyFromRemote(sc, remoteFile, localFile, options.CopyOptions)
+	} else {
+		_, err = CopyToRemote(sc, localFile, remoteFile, options.CopyOptions)
 	}
-
-	remote, err := sc.OpenFile(remoteFile, (os.O_RDWR | os.O_CREATE))
 	if err != nil {
 		return nil, err
 	}
-	defer remote.Close()
+	return &ConnectionScpReport{Response: remoteFile}, nil
+}
 
-	if !swap {
-		_, err = io.Copy(remote, f)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		_, err = io.Copy(f, remote)
+// connectionClient returns a client from the pool or, if pool is nil, dials a
+// new one. The returned function must be called once the client is not used
+// anymore, it releases the pooled client or closes the dialed one.
+func connectionClient(pool *Pool, uri *url.URL, identity string) (*ssh.Client, func(), error) {
+	if pool != nil {
+		pc, err := pool.acquire(uri, identity, false)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		return pc.client, func() { pool.release(pc) }, nil
+	}
+	cfg, err := ValidateAndConfigure(uri, identity, false)
+	if err != nil {
+		return nil, nil, err
 	}
-	return &ConnectionScpReport{Response: remote.Name()}, nil
+	client, err := ssh.Dial("tcp", uri.Host, cfg) // dial the client
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return client, func() { client.Close() }, nil
 }
 
 // ExecRemoteCommand takes a ssh client connection and a command to run and executes the
@@ -251,12 +244,14 @@ func ValidateAndConfigure(uri *url.URL, iden string, insecureIsMachineConnection
 	passwd, passwdSet := uri.User.Password()
 	if iden != "" { // iden might be blank if coming from image scp or if no validation is needed
 		value := iden
-		s, err := PublicKey(value, []byte(passwd))
+		s, err := IdentitySigners(value, []byte(passwd))
 		if err != nil {
 			return nil, fmt.Errorf("failed to read identity %q: %w", value, err)
 		}
-		signers = append(signers, s)
-		logrus.Debugf("SSH Ident Key %q %s %s", value, ssh.FingerprintSHA256(s.PublicKey()), s.PublicKey().Type())
+		signers = append(signers, s...)
+		for _, k := range s {
+			logrus.Debugf("SSH Ident Key %q %s %s", value, ssh.FingerprintSHA256(k.PublicKey()), k.PublicKey().Type())
+		}
 	} else if sock, found := os.LookupEnv("SSH_AUTH_SOCK"); found { // validate ssh information, specifically the unix file socket used by the ssh agent.
 		logrus.Debugf("Found SSH_AUTH_SOCK %q, ssh-agent signer enabled", sock)
 
@@ -3,6 +3,7 @@ package ssh
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os/exec"
@@ -48,9 +49,12 @@ func nativeConnectionCreate(options ConnectionCreateOptions) error {
 
 	args := []string{uri.User.String() + "@" + uri.Hostname()}
 
-	if len(dst.Identity) > 0 {
-		args = append(args, "-i", dst.Identity)
+	idArgs, cleanup, err := nativeIdentityArgs(dst.Identity)
+	if err != nil {
+		return err
 	}
+	defer cleanup()
+	args = append(args, idArgs...)
 	if len(conf.Engine.SSHConfig) > 0 {
 		args = append(args, "-F", conf.Engine.SSHConfig)
 	}
@@ -125,9 +129,12 @@ func nativeConnectionExec(options ConnectionExecOptions, input io.Reader) (*Conn
 	}
 
 	args := []string{}
-	if len(dst.Identity) > 0 {
-		args = append(args, "-i", dst.Identity)
+	idArgs, cleanup, err := nativeIdentityArgs(dst.Identity)
+	if err != nil {
+		return nil, err
 	}
+	defer cleanup()
+	args = append(args, idArgs...)
 	if len(conf.Engine.SSHConfig) > 0 {
 		args = append(args, "-F", conf.Engine.SSHConfig)
 	}
@@ -146,6 +153,9 @@ func nativeConnectionExec(options ConnectionExecOptions, input io.Reader) (*Conn
 }
 
 func nativeConnectionScp(options ConnectionScpOptions) (*ConnectionScpReport, error) {
+	if c := options.CopyOptions; c != nil && (c.Resume || c.Checksum || c.Progress != nil) {
+		return nil, errors.New("resume, checksum and progress copy options are only supported in golang mode")
+	}
 	host, remotePath, localPath, swap, err := ParseScpArgs(options)
 	if err != nil {
 		return nil, err
@@ -166,9 +176,12 @@ func nativeConnectionScp(options ConnectionScpOptions) (*ConnectionScpReport, er
 	}
 
 	args := []string{}
-	if len(dst.Identity) > 0 {
-		args = append(args, "-i", dst.Identity)
+	idArgs, cleanup, err := nativeIdentityArgs(dst.Identity)
+	if err != nil {
+		return nil, err
 	}
+	defer cleanup()
+	args = append(args, idArgs...)
 	if len(conf.Engine.SSHConfig) > 0 {
 		args = append(args, "-F", conf.Engine.SSHConfig)
 	}
@@ -177,6 +190,12 @@ func nativeConnectionScp(options ConnectionScpOptions) (*ConnectionScpReport, er
 	if !strings.Contains(host, "@") {
 		userString = uri.User.String() + "@"
 	}
+
+	// copy directories like the golang mode does, -r is ignored for files
+	args = append(args, "-r")
+	if options.CopyOptions != nil && options.CopyOptions.PreservePermissions {
+		args = append(args, "-p")
+	}
 	// meaning, we are copying from a remote host
 	if swap {
 		args = append(args, userString+host+":"+remotePath, localPath)
@@ -0,0 +1,339 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/common/pkg/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConnectionStatus describes the result of probing a service destination.
+type ConnectionStatus string
+
+const (
+	// ConnectionHealthy means the destination accepted the connection,
+	// the service socket answered and the API version is acceptable.
+	ConnectionHealthy = ConnectionStatus("healthy")
+	// ConnectionUnreachable means the host could not be reached or the
+	// ssh handshake did not complete.
+	ConnectionUnreachable = ConnectionStatus("unreachable")
+	// ConnectionSocketDead means the host is reachable but the service
+	// socket on it does not accept connections or does not answer.
+	ConnectionSocketDead = ConnectionStatus("socket-dead")
+	// ConnectionAPIMismatch means the service answered but reported an
+	// API version lower than the requested minimum.
+	ConnectionAPIMismatch = ConnectionStatus("api-mismatch")
+)
+
+const (
+	// defaultCheckTimeout is used when ConnectionCheckOptions.Timeout is unset.
+	defaultCheckTimeout = 5 * time.Second
+
+	// apiVersionHeader is set by the podman service on every response.
+	apiVersionHeader = "Libpod-API-Version"
+	// compatAPIVersionHeader is the docker compatible version header.
+	compatAPIVersionHeader = "API-Version"
+)
+
+// ErrNoHealthyConnection is returned when none of the given connections passed the health check.
+var ErrNoHealthyConnection = errors.New("no healthy connection found")
+
+type ConnectionCheckOptions struct {
+	// Timeout bounds the whole probe of a single destination, including
+	// the ssh handshake and the API request. Defaults to 5 seconds.
+	Timeout time.Duration
+	// CacheTTL is how long a probe result is reused for the same destination.
+	// Zero disables caching.
+	CacheTTL time.Duration
+	// MinAPIVersion is the lowest acceptable service API version, e.g. "4.0.0".
+	// When empty the API version is reported but not checked.
+	MinAPIVersion string
+}
+
+type ConnectionCheckReport struct {
+	// Name of the connection that was probed
+	Name string
+	// URI of the connection that was probed
+	URI string
+	// Status is the result of the probe
+	Status ConnectionStatus
+	// Latency is the time it took to complete the probe
+	Latency time.Duration
+	// APIVersion reported by the remote service, if it answered
+	APIVersion string
+	// Error describes why the probe failed, nil when healthy
+	Error error
+	// CheckedAt is the time the probe was started
+	CheckedAt time.Time
+}
+
+type checkCacheEntry struct {
+	report  ConnectionCheckReport
+	expires time.Time
+}
+
+var (
+	checkCache     = make(map[string]checkCacheEntry)
+	checkCacheLock sync.Mutex
+)
+
+// ResetConnectionCheckCache drops all cached probe results.
+func ResetConnectionCheckCache() {
+	checkCacheLock.Lock()
+	defer checkCacheLock.Unlock()
+	clear(checkCache)
+}
+
+func checkCacheKey(conn *config.Connection, minAPIVersion string) string {
+	return strings.Join([]string{conn.Name, conn.URI, conn.Identity, minAPIVersion}, "\x00")
+}
+
+// CheckConnection probes the given connection and reports whether it can be used.
+// For ssh destinations the host is dialed, the service socket is forwarded and
+// its API version queried, unix and tcp destinations are queried directly.
+// Note that the probe uses the same authentication as the other functions in
+// this package, identities which need a passphrase will prompt for it.
+func CheckConnection(conn *config.Connection, options *ConnectionCheckOptions) *ConnectionCheckReport {
+	if options == nil {
+		options = &ConnectionCheckOptions{}
+	}
+	key := checkCacheKey(conn, options.MinAPIVersion)
+	if options.CacheTTL > 0 {
+		checkCacheLock.Lock()
+		entry, ok := checkCache[key]
+		checkCacheLock.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			report := entry.report
+			return &report
+		}
+	}
+
+	report := probeConnection(conn, options)
+
+	if options.CacheTTL > 0 {
+		checkCacheLock.Lock()
+		checkCache[key] = checkCacheEntry{report: *report, expires: report.CheckedAt.Add(options.CacheTTL)}
+		checkCacheLock.Unlock()
+	}
+	return report
+}
+
+// CheckConnections probes all given connections in parallel. The reports are
+// returned in the same order as the connections.
+func CheckConnections(conns []config.Connection, options *ConnectionCheckOptions) []ConnectionCheckReport {
+	reports := make([]ConnectionCheckReport, len(conns))
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reports[i] = *CheckConnection(&conns[i], options)
+		}(i)
+	}
+	wg.Wait()
+	return reports
+}
+
+// FirstHealthyConnection probes the given connections and returns the first
+// one in list order that is healthy. All connections are probed concurrently
+// so a dead node only costs a single timeout. If no connection is healthy the
+// returned error wraps ErrNoHealthyConnection and lists the individual failures.
+func FirstHealthyConnection(conns []config.Connection, options *ConnectionCheckOptions) (*config.Connection, *ConnectionCheckReport, error) {
+	reports := CheckConnections(conns, options)
+	failures := make([]string, 0, len(reports))
+	for i := range reports {
+		if reports[i].Status == ConnectionHealthy {
+			return &conns[i], &reports[i], nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s: %v", reports[i].Name, reports[i].Status, reports[i].Error))
+	}
+	if len(failures) == 0 {
+		return nil, nil, ErrNoHealthyConnection
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrNoHealthyConnection, strings.Join(failures, "; "))
+}
+
+// FirstHealthyFarmConnection returns the first healthy connection of the given
+// farm, if name is empty the default farm is used.
+func FirstHealthyFarmConnection(cfg *config.Config, name string, options *ConnectionCheckOptions) (*config.Connection, *ConnectionCheckReport, error) {
+	var (
+		conns []config.Connection
+		err   error
+	)
+	if name == "" {
+		name, conns, err = cfg.GetDefaultFarmConnections()
+	} else {
+		conns, err = cfg.GetFarmConnections(name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, report, err := FirstHealthyConnection(conns, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("farm %q: %w", name, err)
+	}
+	return conn, report, nil
+}
+
+func probeConnection(conn *config.Connection, options *ConnectionCheckOptions) *ConnectionCheckReport {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	report := &ConnectionCheckReport{
+		Name:      conn.Name,
+		URI:       conn.URI,
+		CheckedAt: time.Now(),
+	}
+	deadline := report.CheckedAt.Add(timeout)
+
+	status, version, err := probeDestination(&conn.Destination, deadline)
+	if err == nil && options.MinAPIVersion != "" && compareAPIVersions(version, options.MinAPIVersion) < 0 {
+		status = ConnectionAPIMismatch
+		err = fmt.Errorf("API version %q is lower than required %q", version, options.MinAPIVersion)
+	}
+	report.Status = status
+	report.APIVersion = version
+	report.Error = err
+	report.Latency = time.Since(report.CheckedAt)
+	logrus.Debugf("Connection %q (%s) is %s after %s", conn.Name, conn.URI, status, report.Latency)
+	return report
+}
+
+func probeDestination(dst *config.Destination, deadline time.Time) (ConnectionStatus, string, error) {
+	uri, err := url.Parse(dst.URI)
+	if err != nil {
+		return ConnectionUnreachable, "", err
+	}
+
+	var dial func() (net.Conn, error)
+	switch uri.Scheme {
+	case "unix":
+		dial = func() (net.Conn, error) {
+			return net.DialTimeout("unix", uri.Path, time.Until(deadline))
+		}
+	case "tcp":
+		dial = func() (net.Conn, error) {
+			return net.DialTimeout("tcp", uri.Host, time.Until(deadline))
+		}
+	case "ssh":
+		client, err := dialWithDeadline(dst, uri, deadline)
+		if err != nil {
+			return ConnectionUnreachable, "", err
+		}
+		defer client.Close()
+		dial = func() (net.Conn, error) {
+			return DialNet(client, "unix", uri)
+		}
+	default:
+		return ConnectionUnreachable, "", fmt.Errorf("unsupported connection scheme %q", uri.Scheme)
+	}
+
+	version, err := queryAPIVersion(dial, deadline)
+	if err != nil {
+		return ConnectionSocketDead, "", err
+	}
+	return ConnectionHealthy, version, nil
+}
+
+// dialWithDeadline establishes the ssh connection like Dial but makes sure
+// that neither the tcp connection nor the handshake outlive the deadline.
+func dialWithDeadline(dst *config.Destination, uri *url.URL, deadline time.Time) (*ssh.Client, error) {
+	_, uri, err := Validate(uri.User, uri.String(), 0, dst.Identity)
+	if err != nil {
+		return nil, err
+	}
+	if uri.User == nil {
+		if uri.User, err = GetUserInfo(uri); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := ValidateAndConfigure(uri, dst.Identity, dst.IsMachine)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Timeout = time.Until(deadline)
+
+	conn, err := net.DialTimeout("tcp", uri.Host, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	// The deadline stays in place for the lifetime of the probe, it covers
+	// the handshake and all traffic forwarded over this connection.
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, uri.Host, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// queryAPIVersion pings the service through the given dialer and returns the
+// API version it reports.
+func queryAPIVersion(dial func() (net.Conn, error), deadline time.Time) (string, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return dial()
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://d/_ping", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("service ping failed: %s", resp.Status)
+	}
+	version := resp.Header.Get(apiVersionHeader)
+	if version == "" {
+		version = resp.Header.Get(compatAPIVersionHeader)
+	}
+	return version, nil
+}
+
+// compareAPIVersions compares two dotted version strings numerically and
+// returns -1, 0 or 1. A leading "v" and missing components are ignored.
+func compareAPIVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
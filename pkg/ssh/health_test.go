@@ -0,0 +1,110 @@
+package ssh
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/common/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func startPingServer(t *testing.T, version string) string {
+	path := filepath.Join(t.TempDir(), "podman.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, version)
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { srv.Close() })
+	return path
+}
+
+func closedTCPAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestCheckConnection(t *testing.T) {
+	ResetConnectionCheckCache()
+	sock := startPingServer(t, "5.2.0")
+
+	conn := config.Connection{Name: "local", Destination: config.Destination{URI: "unix://" + sock}}
+	rep := CheckConnection(&conn, &ConnectionCheckOptions{Timeout: time.Second})
+	require.NoError(t, rep.Error)
+	require.Equal(t, ConnectionHealthy, rep.Status)
+	require.Equal(t, "5.2.0", rep.APIVersion)
+	require.Equal(t, "local", rep.Name)
+
+	rep = CheckConnection(&conn, &ConnectionCheckOptions{Timeout: time.Second, MinAPIVersion: "5.10"})
+	require.Error(t, rep.Error)
+	require.Equal(t, ConnectionAPIMismatch, rep.Status)
+
+	dead := config.Connection{Name: "dead", Destination: config.Destination{URI: "unix://" + filepath.Join(t.TempDir(), "none.sock")}}
+	rep = CheckConnection(&dead, &ConnectionCheckOptions{Timeout: time.Second})
+	require.Error(t, rep.Error)
+	require.Equal(t, ConnectionSocketDead, rep.Status)
+
+	unreachable := config.Connection{Name: "unreachable", Destination: config.Destination{URI: "ssh://root@" + closedTCPAddr(t) + "/run/podman/podman.sock"}}
+	rep = CheckConnection(&unreachable, &ConnectionCheckOptions{Timeout: time.Second})
+	require.Error(t, rep.Error)
+	require.Equal(t, ConnectionUnreachable, rep.Status)
+
+	bad := config.Connection{Name: "bad", Destination: config.Destination{URI: "http://example.com"}}
+	rep = CheckConnection(&bad, nil)
+	require.ErrorContains(t, rep.Error, "unsupported connection scheme")
+}
+
+func TestCheckConnectionCache(t *testing.T) {
+	ResetConnectionCheckCache()
+	sock := filepath.Join(t.TempDir(), "podman.sock")
+	conn := config.Connection{Name: "cached", Destination: config.Destination{URI: "unix://" + sock}}
+	opts := &ConnectionCheckOptions{Timeout: time.Second, CacheTTL: time.Hour}
+
+	rep := CheckConnection(&conn, opts)
+	require.Equal(t, ConnectionSocketDead, rep.Status)
+
+	// the service comes up but the cached result must still be returned
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer l.Close()
+	rep2 := CheckConnection(&conn, opts)
+	require.Equal(t, ConnectionSocketDead, rep2.Status)
+	require.Equal(t, rep.CheckedAt, rep2.CheckedAt)
+
+	ResetConnectionCheckCache()
+	rep3 := CheckConnection(&conn, &ConnectionCheckOptions{Timeout: 200 * time.Millisecond})
+	require.NotEqual(t, rep.CheckedAt, rep3.CheckedAt)
+}
+
+func TestFirstHealthyConnection(t *testing.T) {
+	ResetConnectionCheckCache()
+	sock1 := startPingServer(t, "5.0.0")
+	sock2 := startPingServer(t, "5.1.0")
+	conns := []config.Connection{
+		{Name: "dead", Destination: config.Destination{URI: "unix://" + filepath.Join(t.TempDir(), "none.sock")}},
+		{Name: "first", Destination: config.Destination{URI: "unix://" + sock1}},
+		{Name: "second", Destination: config.Destination{URI: "unix://" + sock2}},
+	}
+	conn, rep, err := FirstHealthyConnection(conns, &ConnectionCheckOptions{Timeout: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, "first", conn.Name)
+	require.Equal(t, "5.0.0", rep.APIVersion)
+
+	_, _, err = FirstHealthyConnection(conns[:1], &ConnectionCheckOptions{Timeout: time.Second})
+	require.ErrorIs(t, err, ErrNoHealthyConnection)
+	require.ErrorContains(t, err, "dead")
+}
+
+func TestCompareAPIVersions(t *testing.T) {
+	require.Equal(t, 0, compareAPIVersions("5.0.0", "5"))
+	require.Equal(t, -1, compareAPIVersions("4.9.3", "5.0.0"))
+	require.Equal(t, 1, compareAPIVersions("v5.10.0", "5.9.1"))
+}
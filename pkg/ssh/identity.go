@@ -0,0 +1,390 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containers/common/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Prefixes of identity references that are not plain key file paths.
+const (
+	// IdentityAgentPrefix selects keys held by the ssh-agent at SSH_AUTH_SOCK,
+	// optionally followed by a SHA256 fingerprint or key comment,
+	// e.g. "agent:" or "agent:SHA256:abc..." or "agent:me@laptop".
+	IdentityAgentPrefix = "agent:"
+	// IdentityPKCS11Prefix selects a key stored on a PKCS#11 token using a
+	// RFC 7512 URI, e.g. "pkcs11:token=farm;object=ssh?module-path=/usr/lib64/opensc-pkcs11.so&pin-source=/run/pin".
+	IdentityPKCS11Prefix = "pkcs11:"
+	// IdentitySecretPrefix selects a private key stored in the secrets
+	// manager set with SetIdentitySecretsManager, e.g. "secret:farm-key".
+	IdentitySecretPrefix = "secret:"
+)
+
+var (
+	identityCache     = make(map[string]*cachedIdentity)
+	identityCacheLock sync.Mutex
+
+	identitySecrets     *secrets.SecretsManager
+	identitySecretsLock sync.Mutex
+)
+
+// cachedIdentity holds the signers of an identity, its lock is held while
+// the identity is loaded so the user is only prompted once per identity
+// without blocking the connections using other identities.
+type cachedIdentity struct {
+	lock    sync.Mutex
+	signers []ssh.Signer
+}
+
+// SetIdentitySecretsManager sets the secrets manager used to look up
+// identities referenced with the "secret:" prefix.
+func SetIdentitySecretsManager(manager *secrets.SecretsManager) {
+	identitySecretsLock.Lock()
+	defer identitySecretsLock.Unlock()
+	identitySecrets = manager
+}
+
+// ClearIdentityCache drops all keys decrypted or loaded during this session.
+func ClearIdentityCache() {
+	identityCacheLock.Lock()
+	defer identityCacheLock.Unlock()
+	clear(identityCache)
+}
+
+// IsIdentityReference returns true if the identity refers to an ssh-agent,
+// PKCS#11 token or secret rather than to a key file.
+func IsIdentityReference(identity string) bool {
+	return strings.HasPrefix(identity, IdentityAgentPrefix) ||
+		strings.HasPrefix(identity, IdentityPKCS11Prefix) ||
+		strings.HasPrefix(identity, IdentitySecretPrefix)
+}
+
+// IdentitySigners returns the signers for the given identity, which is either a
+// key file path or a reference using one of the Identity*Prefix forms.
+// The signers are cached for the lifetime of the process so that passphrases
+// and PINs are only asked for once per identity, use ClearIdentityCache to
+// drop them.
+func IdentitySigners(identity string, passphrase []byte) ([]ssh.Signer, error) {
+	if strings.HasPrefix(identity, IdentityAgentPrefix) {
+		// agent keys are not cached, the agent may gain or lose keys at any time
+		return agentSigners(strings.TrimPrefix(identity, IdentityAgentPrefix))
+	}
+
+	identityCacheLock.Lock()
+	cached, ok := identityCache[identity]
+	if !ok {
+		cached = &cachedIdentity{}
+		identityCache[identity] = cached
+	}
+	identityCacheLock.Unlock()
+
+	// loading may prompt for a passphrase or PIN, only hold the lock of
+	// this identity while doing so
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+	if cached.signers != nil {
+		return cached.signers, nil
+	}
+
+	var (
+		signers []ssh.Signer
+		err     error
+	)
+	switch {
+	case strings.HasPrefix(identity, IdentityPKCS11Prefix):
+		signers, err = pkcs11Signers(identity)
+	case strings.HasPrefix(identity, IdentitySecretPrefix):
+		signers, err = secretSigners(strings.TrimPrefix(identity, IdentitySecretPrefix), passphrase)
+	default:
+		var s ssh.Signer
+		s, err = PublicKey(identity, passphrase)
+		signers = []ssh.Signer{s}
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached.signers = signers
+	return signers, nil
+}
+
+// agentSigners returns the keys of the ssh-agent, if filter is not empty
+// only the keys matching the fingerprint or comment are returned.
+func agentSigners(filter string) ([]ssh.Signer, error) {
+	sock, found := os.LookupEnv("SSH_AUTH_SOCK")
+	if !found {
+		return nil, errors.New("identity refers to an ssh-agent but SSH_AUTH_SOCK is not set")
+	}
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	keys, err := agent.NewClient(c).List()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []ssh.Signer
+	for _, key := range keys {
+		if filter != "" && key.Comment != filter && ssh.FingerprintSHA256(key) != filter {
+			continue
+		}
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			return nil, err
+		}
+		matched = append(matched, &agentSigner{sock: sock, pub: pub})
+	}
+	if filter != "" && len(matched) == 0 {
+		return nil, fmt.Errorf("no key matching %q found in ssh-agent", filter)
+	}
+	return matched, nil
+}
+
+// agentSigner signs with a key held by the ssh-agent. The agent is dialed
+// for every signature, so no connection is kept open once signing is done.
+type agentSigner struct {
+	sock string
+	pub  ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) Sign(r io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(r, data, "")
+}
+
+func (s *agentSigner) SignWithAlgorithm(r io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	c, err := net.Dial("unix", s.sock)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	signers, err := agent.NewClient(c).Signers()
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := ssh.FingerprintSHA256(s.pub)
+	for _, signer := range signers {
+		if ssh.FingerprintSHA256(signer.PublicKey()) != fingerprint {
+			continue
+		}
+		if algorithm == "" {
+			return signer.Sign(r, data)
+		}
+		as, ok := signer.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("ssh-agent key %s does not support algorithm %q", fingerprint, algorithm)
+		}
+		return as.SignWithAlgorithm(r, data, algorithm)
+	}
+	return nil, fmt.Errorf("key %s is no longer held by the ssh-agent", fingerprint)
+}
+
+// lookupIdentitySecret returns the data of the secret with the given name.
+func lookupIdentitySecret(name string) ([]byte, error) {
+	identitySecretsLock.Lock()
+	manager := identitySecrets
+	identitySecretsLock.Unlock()
+	if manager == nil {
+		return nil, fmt.Errorf("identity refers to secret %q but no secrets manager is configured", name)
+	}
+	_, key, err := manager.LookupSecretData(name)
+	return key, err
+}
+
+// secretSigners parses the private key stored in the secret with the given name.
+func secretSigners(name string, passphrase []byte) ([]ssh.Signer, error) {
+	key, err := lookupIdentitySecret(name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parsePrivateKey(key, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("secret %q: %w", name, err)
+	}
+	logrus.Debugf("SSH Secret Key %q %s %s", name, ssh.FingerprintSHA256(s.PublicKey()), s.PublicKey().Type())
+	return []ssh.Signer{s}, nil
+}
+
+// nativeIdentityArgs returns the ssh(1)/scp(1) arguments selecting the given
+// identity. Keys held by a PKCS#11 token or a secret are served to ssh by an
+// agent running in this process, so the private key is never written to
+// disk. The returned cleanup function stops the agent and must always be
+// called once the command finished.
+func nativeIdentityArgs(identity string) ([]string, func(), error) {
+	noop := func() {}
+	switch {
+	case identity == "":
+		return nil, noop, nil
+	case strings.HasPrefix(identity, IdentityAgentPrefix):
+		filter := strings.TrimPrefix(identity, IdentityAgentPrefix)
+		if filter == "" {
+			// ssh uses all agent keys by default
+			return nil, noop, nil
+		}
+		signers, err := agentSigners(filter)
+		if err != nil {
+			return nil, noop, err
+		}
+		// pointing IdentityFile at a public key makes ssh use the matching agent key
+		path, cleanup, err := writeIdentityFile(ssh.MarshalAuthorizedKey(signers[0].PublicKey()))
+		if err != nil {
+			return nil, noop, err
+		}
+		return []string{"-i", path, "-o", "IdentitiesOnly=yes"}, cleanup, nil
+	case strings.HasPrefix(identity, IdentityPKCS11Prefix), strings.HasPrefix(identity, IdentitySecretPrefix):
+		// the object, id and PIN of the PKCS#11 URI are handled when
+		// loading the key, ssh only talks to the agent
+		signers, err := IdentitySigners(identity, nil)
+		if err != nil {
+			return nil, noop, err
+		}
+		sock, cleanup, err := serveIdentityAgent(signers)
+		if err != nil {
+			return nil, noop, err
+		}
+		return []string{"-o", "IdentityAgent=" + sock}, cleanup, nil
+	default:
+		return []string{"-i", identity}, noop, nil
+	}
+}
+
+// writeIdentityFile writes data to a new file only readable by the current user.
+func writeIdentityFile(data []byte) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "podman-ssh-identity")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Errorf("Removing temporary identity: %v", err)
+		}
+	}
+	path := filepath.Join(dir, "identity")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// serveIdentityAgent serves the signers on a new agent socket only
+// accessible by the current user and returns the socket path.
+func serveIdentityAgent(signers []ssh.Signer) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "podman-ssh-agent")
+	if err != nil {
+		return "", nil, err
+	}
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+	a := &signersAgent{signers: signers}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_ = agent.ServeAgent(a, c)
+			}()
+		}
+	}()
+	cleanup := func() {
+		l.Close()
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Errorf("Removing temporary agent socket: %v", err)
+		}
+	}
+	return sock, cleanup, nil
+}
+
+// signersAgent is a read-only ssh-agent offering the given signers.
+type signersAgent struct {
+	signers []ssh.Signer
+}
+
+var errAgentReadOnly = errors.New("agent is read-only")
+
+func (a *signersAgent) List() ([]*agent.Key, error) {
+	keys := make([]*agent.Key, 0, len(a.signers))
+	for _, s := range a.signers {
+		pub := s.PublicKey()
+		keys = append(keys, &agent.Key{Format: pub.Type(), Blob: pub.Marshal()})
+	}
+	return keys, nil
+}
+
+func (a *signersAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *signersAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	for _, s := range a.signers {
+		if !bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+			continue
+		}
+		var algorithm string
+		switch {
+		case flags&agent.SignatureFlagRsaSha256 != 0:
+			algorithm = ssh.KeyAlgoRSASHA256
+		case flags&agent.SignatureFlagRsaSha512 != 0:
+			algorithm = ssh.KeyAlgoRSASHA512
+		default:
+			return s.Sign(rand.Reader, data)
+		}
+		as, ok := s.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("key does not support algorithm %q", algorithm)
+		}
+		return as.SignWithAlgorithm(rand.Reader, data, algorithm)
+	}
+	return nil, errors.New("key not found")
+}
+
+func (a *signersAgent) Signers() ([]ssh.Signer, error) {
+	return a.signers, nil
+}
+
+func (a *signersAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+func (a *signersAgent) Add(agent.AddedKey) error {
+	return errAgentReadOnly
+}
+
+func (a *signersAgent) Remove(ssh.PublicKey) error {
+	return errAgentReadOnly
+}
+
+func (a *signersAgent) RemoveAll() error {
+	return errAgentReadOnly
+}
+
+func (a *signersAgent) Lock([]byte) error {
+	return errAgentReadOnly
+}
+
+func (a *signersAgent) Unlock([]byte) error {
+	return errAgentReadOnly
+}
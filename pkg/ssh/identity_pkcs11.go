@@ -0,0 +1,321 @@
+//go:build cgo
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"sync"
+
+	ocipkcs11 "github.com/containers/ocicrypt/crypto/pkcs11"
+	"github.com/miekg/pkcs11"
+	"github.com/sirupsen/logrus"
+	pkcs11uri "github.com/stefanberger/go-pkcs11uri"
+	"golang.org/x/crypto/ssh"
+)
+
+// digestInfoPrefixes are the DER encoded DigestInfo prefixes which must be
+// prepended to the digest for RSA PKCS#1 v1.5 signatures with CKM_RSA_PKCS.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+var curveOIDs = map[string]elliptic.Curve{
+	"1.2.840.10045.3.1.7": elliptic.P256(),
+	"1.3.132.0.34":        elliptic.P384(),
+	"1.3.132.0.35":        elliptic.P521(),
+}
+
+// pkcs11Key is a crypto.Signer backed by a private key on a PKCS#11 token.
+// The session stays open for the lifetime of the process.
+type pkcs11Key struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	handle  pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+	// lock serializes operations on the session, PKCS#11 sessions
+	// must not be used concurrently
+	lock sync.Mutex
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	switch k.pub.(type) {
+	case *rsa.PublicKey:
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v for PKCS#11 RSA key", opts.HashFunc())
+		}
+		data := append(append([]byte{}, prefix...), digest...)
+		return k.sign(pkcs11.CKM_RSA_PKCS, data)
+	case *ecdsa.PublicKey:
+		sig, err := k.sign(pkcs11.CKM_ECDSA, digest)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS#11 returns r || s, crypto.Signer users expect ASN.1
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %T", k.pub)
+	}
+}
+
+func (k *pkcs11Key) sign(mechanism uint, data []byte) ([]byte, error) {
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, k.handle); err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign init: %w", err)
+	}
+	sig, err := k.ctx.Sign(k.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign: %w", err)
+	}
+	return sig, nil
+}
+
+// pkcs11Signers opens a session on the token referenced by the RFC 7512 URI
+// and returns a signer for the private key it selects. When the URI does not
+// carry a PIN the user is prompted for it.
+func pkcs11Signers(identity string) ([]ssh.Signer, error) {
+	uri := pkcs11uri.New()
+	uri.SetModuleDirectories(ocipkcs11.GetDefaultModuleDirectories())
+	// the identity is configured by the user for their own connections,
+	// any module they point to may be loaded
+	uri.SetAllowAnyModule(true)
+	if err := uri.Parse(identity); err != nil {
+		return nil, err
+	}
+	module, err := uri.GetModule()
+	if err != nil {
+		return nil, fmt.Errorf("no PKCS#11 module available: %w", err)
+	}
+	id, hasID := uri.GetPathAttribute("id", false)
+	label, hasLabel := uri.GetPathAttribute("object", false)
+	if !hasID && !hasLabel {
+		return nil, errors.New("PKCS#11 identity needs an \"id\" or \"object\" attribute")
+	}
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %q", module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		var p11Err pkcs11.Error
+		if !errors.As(err, &p11Err) || p11Err != pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+			ctx.Destroy()
+			return nil, fmt.Errorf("PKCS#11 initialize: %w", err)
+		}
+	}
+
+	slot, err := pkcs11Slot(ctx, uri)
+	if err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("PKCS#11 open session on slot %d: %w", slot, err)
+	}
+	cleanup := func() {
+		_ = ctx.CloseSession(session)
+		ctx.Destroy()
+	}
+
+	var pin string
+	if uri.HasPIN() {
+		if pin, err = uri.GetPIN(); err != nil {
+			cleanup()
+			return nil, err
+		}
+	} else {
+		p, err := ReadPassword("PIN for PKCS#11 token: ")
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		pin = string(p)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		var p11Err pkcs11.Error
+		if !errors.As(err, &p11Err) || p11Err != pkcs11.CKR_USER_ALREADY_LOGGED_IN {
+			cleanup()
+			return nil, fmt.Errorf("PKCS#11 login: %w", err)
+		}
+	}
+
+	handle, err := pkcs11FindObject(ctx, session, pkcs11.CKO_PRIVATE_KEY, id, label)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	pubHandle, err := pkcs11FindObject(ctx, session, pkcs11.CKO_PUBLIC_KEY, id, label)
+	if err != nil {
+		// some tokens expose the public attributes on the private key only
+		pubHandle = handle
+	}
+	pub, err := pkcs11PublicKey(ctx, session, pubHandle)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromSigner(&pkcs11Key{ctx: ctx, session: session, handle: handle, pub: pub})
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	logrus.Debugf("SSH PKCS#11 Key %s %s", ssh.FingerprintSHA256(signer.PublicKey()), signer.PublicKey().Type())
+	return []ssh.Signer{signer}, nil
+}
+
+// pkcs11Slot returns the slot selected by the slot-id or token attributes
+// of the URI, or the first slot with a token if neither is set.
+func pkcs11Slot(ctx *pkcs11.Ctx, uri *pkcs11uri.Pkcs11URI) (uint, error) {
+	if v, ok := uri.GetPathAttribute("slot-id", false); ok {
+		slot, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid PKCS#11 slot-id %q: %w", v, err)
+		}
+		return uint(slot), nil
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("PKCS#11 get slot list: %w", err)
+	}
+	token, hasToken := uri.GetPathAttribute("token", false)
+	for _, slot := range slots {
+		if !hasToken {
+			return slot, nil
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err == nil && info.Label == token {
+			return slot, nil
+		}
+	}
+	if hasToken {
+		return 0, fmt.Errorf("PKCS#11 token %q not found", token)
+	}
+	return 0, errors.New("no PKCS#11 token found")
+}
+
+func pkcs11FindObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, id, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if id != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	if label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("PKCS#11 find objects: %w", err)
+	}
+	objs, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("PKCS#11 find objects: %w", err)
+	}
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("no PKCS#11 key found with id %q and label %q", id, label)
+	case 1:
+		return objs[0], nil
+	default:
+		return 0, fmt.Errorf("more than one PKCS#11 key found with id %q and label %q", id, label)
+	}
+}
+
+func pkcs11PublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil || len(attrs) != 1 {
+		return nil, fmt.Errorf("PKCS#11 get key type: %w", err)
+	}
+	keyType := ulongAttribute(attrs[0].Value)
+
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil || len(attrs) != 2 {
+			return nil, fmt.Errorf("PKCS#11 get RSA public key: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	case pkcs11.CKK_EC:
+		attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil || len(attrs) != 2 {
+			return nil, fmt.Errorf("PKCS#11 get EC public key: %w", err)
+		}
+		return parseECPublicKey(attrs[0].Value, attrs[1].Value)
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %d", keyType)
+	}
+}
+
+// parseECPublicKey parses the DER encoded CKA_EC_PARAMS curve OID and the
+// DER encoded uncompressed CKA_EC_POINT.
+func parseECPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("parse PKCS#11 EC params: %w", err)
+	}
+	curve, ok := curveOIDs[oid.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PKCS#11 EC curve %s", oid)
+	}
+	var raw []byte
+	if _, err := asn1.Unmarshal(point, &raw); err != nil {
+		// some tokens return the point without the OCTET STRING wrapping
+		raw = point
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(raw) != 1+2*size || raw[0] != 4 {
+		return nil, errors.New("unsupported PKCS#11 EC point encoding")
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(raw[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(raw[1+size:]),
+	}, nil
+}
+
+// ulongAttribute decodes a CK_ULONG attribute value, which is stored in
+// host byte order.
+func ulongAttribute(b []byte) uint {
+	switch len(b) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	default:
+		return ^uint(0)
+	}
+}
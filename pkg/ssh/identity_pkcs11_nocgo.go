@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package ssh
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func pkcs11Signers(string) ([]ssh.Signer, error) {
+	return nil, errors.New("PKCS#11 identities are not supported in builds without cgo")
+}
@@ -0,0 +1,185 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/common/pkg/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newTestKey(t *testing.T) (ed25519.PrivateKey, []byte) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	return priv, pem.EncodeToMemory(block)
+}
+
+func TestIdentityFile(t *testing.T) {
+	ClearIdentityCache()
+	priv, data := newTestKey(t)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	signers, err := IdentitySigners(path, nil)
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+	pub, err := ssh.NewPublicKey(priv.Public())
+	require.NoError(t, err)
+	require.Equal(t, ssh.FingerprintSHA256(pub), ssh.FingerprintSHA256(signers[0].PublicKey()))
+
+	// the key is cached for the session, even if the file is gone
+	require.NoError(t, os.Remove(path))
+	cached, err := IdentitySigners(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, signers, cached)
+
+	ClearIdentityCache()
+	_, err = IdentitySigners(path, nil)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	args, cleanup, err := nativeIdentityArgs(path)
+	require.NoError(t, err)
+	defer cleanup()
+	require.Equal(t, []string{"-i", path}, args)
+}
+
+func TestIdentitySecret(t *testing.T) {
+	ClearIdentityCache()
+	SetIdentitySecretsManager(nil)
+	_, err := IdentitySigners("secret:farm", nil)
+	require.ErrorContains(t, err, "no secrets manager is configured")
+
+	manager, err := secrets.NewManager(t.TempDir())
+	require.NoError(t, err)
+	SetIdentitySecretsManager(manager)
+	defer SetIdentitySecretsManager(nil)
+
+	_, data := newTestKey(t)
+	_, err = manager.Store("farm", data, "file", secrets.StoreOptions{DriverOpts: map[string]string{"path": t.TempDir()}})
+	require.NoError(t, err)
+
+	require.True(t, IsIdentityReference("secret:farm"))
+	signers, err := IdentitySigners("secret:farm", nil)
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+
+	// the key is served by an agent instead of being written to disk
+	args, cleanup, err := nativeIdentityArgs("secret:farm")
+	require.NoError(t, err)
+	require.Len(t, args, 2)
+	sock, ok := strings.CutPrefix(args[1], "IdentityAgent=")
+	require.True(t, ok, args)
+	c, err := net.Dial("unix", sock)
+	require.NoError(t, err)
+	client := agent.NewClient(c)
+	keys, err := client.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, ssh.FingerprintSHA256(signers[0].PublicKey()), ssh.FingerprintSHA256(keys[0]))
+	sig, err := client.Sign(keys[0], []byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, signers[0].PublicKey().Verify([]byte("data"), sig))
+	require.Error(t, client.RemoveAll())
+	c.Close()
+
+	cleanup()
+	_, err = os.Stat(sock)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestIdentityAgent(t *testing.T) {
+	ClearIdentityCache()
+	keyring := agent.NewKeyring()
+	priv1, _ := newTestKey(t)
+	priv2, _ := newTestKey(t)
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: priv1, Comment: "first"}))
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: priv2, Comment: "second"}))
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = agent.ServeAgent(keyring, c) }()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	signers, err := IdentitySigners("agent:", nil)
+	require.NoError(t, err)
+	require.Len(t, signers, 2)
+
+	signers, err = IdentitySigners("agent:second", nil)
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+	pub2, err := ssh.NewPublicKey(priv2.Public())
+	require.NoError(t, err)
+	require.Equal(t, ssh.FingerprintSHA256(pub2), ssh.FingerprintSHA256(signers[0].PublicKey()))
+
+	signers, err = IdentitySigners("agent:"+ssh.FingerprintSHA256(pub2), nil)
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+
+	// the signature is made by the agent, the connection is closed afterwards
+	sig, err := signers[0].Sign(rand.Reader, []byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, pub2.Verify([]byte("data"), sig))
+
+	_, err = IdentitySigners("agent:unknown", nil)
+	require.ErrorContains(t, err, "no key matching")
+
+	args, cleanup, err := nativeIdentityArgs("agent:second")
+	require.NoError(t, err)
+	defer cleanup()
+	require.Equal(t, "IdentitiesOnly=yes", args[3])
+	content, err := os.ReadFile(args[1])
+	require.NoError(t, err)
+	require.Equal(t, ssh.MarshalAuthorizedKey(pub2), content)
+
+	args, _, err = nativeIdentityArgs("agent:")
+	require.NoError(t, err)
+	require.Empty(t, args)
+}
+
+func TestIdentitySignersConcurrent(t *testing.T) {
+	ClearIdentityCache()
+	_, data := newTestKey(t)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	// a slow identity must not block the loading of other identities
+	slow := &cachedIdentity{}
+	identityCacheLock.Lock()
+	identityCache["slow"] = slow
+	identityCacheLock.Unlock()
+	slow.lock.Lock()
+	defer slow.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := IdentitySigners(path, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
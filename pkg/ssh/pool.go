@@ -0,0 +1,320 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// defaultPoolIdleTimeout is used when PoolOptions.IdleTimeout is unset.
+	defaultPoolIdleTimeout = 5 * time.Minute
+	// defaultPoolKeepAlive is used when PoolOptions.KeepAliveInterval is unset.
+	defaultPoolKeepAlive = 30 * time.Second
+	// keepAliveRequest is the global request OpenSSH answers for keepalives.
+	keepAliveRequest = "keepalive@openssh.com"
+)
+
+// ErrPoolClosed is returned when a closed Pool is used.
+var ErrPoolClosed = errors.New("ssh connection pool is closed")
+
+type PoolOptions struct {
+	// IdleTimeout closes connections which were not used for this long.
+	// Defaults to 5 minutes.
+	IdleTimeout time.Duration
+	// KeepAliveInterval is the interval in which keepalives are sent on
+	// each connection, a connection not answering is dropped from the
+	// pool and dialed again on next use. Defaults to 30 seconds.
+	KeepAliveInterval time.Duration
+}
+
+// Pool keeps ssh connections open and shares them between Exec,
+// ExecWithInput, Scp and Pool.DialNet calls to the same destination,
+// so the ssh handshake only happens once per destination. Connections
+// are keyed by user, host, port and identity. A Pool is safe for
+// concurrent use.
+type Pool struct {
+	idleTimeout time.Duration
+	keepAlive   time.Duration
+
+	lock    sync.Mutex
+	clients map[poolKey]*pooledClient
+	// dialing holds a channel for every destination which is currently
+	// dialed, it is closed once the dial finished
+	dialing map[poolKey]chan struct{}
+	closed  bool
+}
+
+type poolKey struct {
+	user     string
+	host     string
+	identity string
+	insecure bool
+}
+
+type pooledClient struct {
+	key    poolKey
+	client *ssh.Client
+	// refs and lastUsed are protected by the pool lock
+	refs     int
+	lastUsed time.Time
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPool creates a new connection pool, Close must be called to release
+// the connections.
+func NewPool(options *PoolOptions) *Pool {
+	p := &Pool{
+		idleTimeout: defaultPoolIdleTimeout,
+		keepAlive:   defaultPoolKeepAlive,
+		clients:     make(map[poolKey]*pooledClient),
+		dialing:     make(map[poolKey]chan struct{}),
+	}
+	if options != nil {
+		if options.IdleTimeout > 0 {
+			p.idleTimeout = options.IdleTimeout
+		}
+		if options.KeepAliveInterval > 0 {
+			p.keepAlive = options.KeepAliveInterval
+		}
+	}
+	return p
+}
+
+// Client returns a connected client for the destination. The client is
+// shared, it must not be closed by the caller, instead the returned release
+// function must be called once the client is no longer used.
+func (p *Pool) Client(options *ConnectionDialOptions) (*ssh.Client, func(), error) {
+	host := options.Host
+	if !strings.HasPrefix(host, "ssh://") {
+		host = "ssh://" + host
+	}
+	_, uri, err := Validate(options.User, host, options.Port, options.Identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := p.acquire(uri, options.Identity, options.InsecureIsMachineConnection)
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	return pc.client, func() { once.Do(func() { p.release(pc) }) }, nil
+}
+
+// DialNet dials the socket in url.Path on the destination like DialNet but
+// uses a pooled connection. The connection is released when the returned
+// net.Conn is closed.
+func (p *Pool) DialNet(options *ConnectionDialOptions, mode string, url *url.URL) (net.Conn, error) {
+	client, release, err := p.Client(options)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := DialNet(client, mode, url)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &pooledConn{Conn: conn, release: release}, nil
+}
+
+// Len returns the number of open connections.
+func (p *Pool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.clients)
+}
+
+// Close closes all connections, also the ones still in use.
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	p.closed = true
+	clients := p.clients
+	p.clients = make(map[poolKey]*pooledClient)
+	p.lock.Unlock()
+
+	var errs []error
+	for _, pc := range clients {
+		if err := pc.stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Pool) acquire(uri *url.URL, identity string, insecure bool) (*pooledClient, error) {
+	key := poolKey{
+		user:     uri.User.Username(),
+		host:     uri.Host,
+		identity: identity,
+		insecure: insecure,
+	}
+
+	p.lock.Lock()
+	for {
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrPoolClosed
+		}
+		pc, ok := p.clients[key]
+		if ok {
+			pc.refs++
+			stale := pc.refs == 1 && time.Since(pc.lastUsed) > p.keepAlive
+			pc.lastUsed = time.Now()
+			p.lock.Unlock()
+			// the keepalive loop may not have noticed a dead connection yet
+			if !stale || pc.ping() == nil {
+				return pc, nil
+			}
+			logrus.Debugf("Pooled ssh connection to %s is dead, reconnecting", key.host)
+			p.lock.Lock()
+			pc.refs--
+			p.lock.Unlock()
+			p.remove(pc)
+			p.lock.Lock()
+			continue
+		}
+		// only dial once when several callers need the same destination
+		wait, ok := p.dialing[key]
+		if !ok {
+			break
+		}
+		p.lock.Unlock()
+		<-wait
+		p.lock.Lock()
+	}
+	done := make(chan struct{})
+	p.dialing[key] = done
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		delete(p.dialing, key)
+		p.lock.Unlock()
+		close(done)
+	}()
+
+	cfg, err := ValidateAndConfigure(uri, identity, insecure)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", uri.Host, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		client.Close()
+		return nil, ErrPoolClosed
+	}
+	pc := &pooledClient{
+		key:      key,
+		client:   client,
+		refs:     1,
+		lastUsed: time.Now(),
+		done:     make(chan struct{}),
+	}
+	p.clients[key] = pc
+	go p.keepAliveLoop(pc)
+	return pc, nil
+}
+
+func (p *Pool) release(pc *pooledClient) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pc.refs--
+	pc.lastUsed = time.Now()
+}
+
+// remove drops the client from the pool and closes it.
+func (p *Pool) remove(pc *pooledClient) {
+	p.lock.Lock()
+	if p.clients[pc.key] == pc {
+		delete(p.clients, pc.key)
+	}
+	p.lock.Unlock()
+	if err := pc.stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logrus.Debugf("Closing pooled ssh connection to %s: %v", pc.key.host, err)
+	}
+}
+
+func (p *Pool) keepAliveLoop(pc *pooledClient) {
+	interval := min(p.keepAlive, p.idleTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pc.done:
+			return
+		case <-ticker.C:
+		}
+
+		// the idle check and the removal must be atomic, acquire could
+		// hand out the client in between otherwise
+		p.lock.Lock()
+		idle := pc.refs == 0 && time.Since(pc.lastUsed) >= p.idleTimeout
+		if idle && p.clients[pc.key] == pc {
+			delete(p.clients, pc.key)
+		}
+		p.lock.Unlock()
+		if idle {
+			logrus.Debugf("Closing idle pooled ssh connection to %s", pc.key.host)
+			_ = pc.stop()
+			return
+		}
+		if err := pc.ping(); err != nil {
+			logrus.Debugf("Pooled ssh connection to %s failed keepalive: %v", pc.key.host, err)
+			p.remove(pc)
+			return
+		}
+	}
+}
+
+// ping sends a keepalive and waits for the answer. A connection which does
+// not answer in time is closed.
+func (pc *pooledClient) ping() error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := pc.client.SendRequest(keepAliveRequest, true, nil)
+		errCh <- err
+	}()
+	timer := time.NewTimer(defaultCheckTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		// closing the client unblocks SendRequest
+		_ = pc.stop()
+		return errors.New("keepalive timed out")
+	}
+}
+
+func (pc *pooledClient) stop() error {
+	var err error
+	pc.stopOnce.Do(func() {
+		close(pc.done)
+		err = pc.client.Close()
+	})
+	return err
+}
+
+// pooledConn releases its pooled client when closed.
+type pooledConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *pooledConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
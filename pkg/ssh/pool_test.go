@@ -0,0 +1,206 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type testSSHServer struct {
+	addr  string
+	dials atomic.Int32
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+// startTestSSHServer starts an ssh server without authentication which
+// answers every exec request with the command it was given.
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	srv := &testSSHServer{addr: l.Addr().String()}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv.dials.Add(1)
+			srv.lock.Lock()
+			srv.conns = append(srv.conns, c)
+			srv.lock.Unlock()
+			go srv.serve(c, cfg)
+		}
+	}()
+	t.Cleanup(srv.dropConnections)
+	return srv
+}
+
+func (s *testSSHServer) serve(c net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		return
+	}
+	go func() {
+		for req := range reqs {
+			_ = req.Reply(req.Type == keepAliveRequest, nil)
+		}
+	}()
+	for newCh := range chans {
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+				// the payload is the length prefixed command
+				_, _ = ch.Write(req.Payload[4:])
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, 0)
+				_, _ = ch.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) dropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+var (
+	poolTestHome     string
+	poolTestHomeOnce sync.Once
+)
+
+func setupPoolTest(t *testing.T) *testSSHServer {
+	// known_hosts is written to $HOME/.ssh, the home directory is cached by
+	// homedir.Get so all tests must use the same one
+	poolTestHomeOnce.Do(func() {
+		var err error
+		poolTestHome, err = os.MkdirTemp("", "ssh-pool-test")
+		require.NoError(t, err)
+	})
+	t.Setenv("HOME", poolTestHome)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	return startTestSSHServer(t)
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	srv := setupPoolTest(t)
+	pool := NewPool(nil)
+	defer pool.Close()
+
+	host, port, err := net.SplitHostPort(srv.addr)
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	options := ConnectionExecOptions{
+		Host: host,
+		Port: p,
+		User: url.User("test"),
+		Pool: pool,
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := options
+			opts.Args = []string{"echo", strconv.Itoa(i)}
+			out, err := Exec(&opts, GolangMode)
+			require.NoError(t, err)
+			require.Equal(t, "echo "+strconv.Itoa(i), out)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), srv.dials.Load())
+	require.Equal(t, 1, pool.Len())
+
+	options.Args = []string{"again"}
+	before := srv.dials.Load()
+	_, err = Exec(&options, GolangMode)
+	require.NoError(t, err)
+	require.Equal(t, before, srv.dials.Load())
+
+	require.NoError(t, pool.Close())
+	_, err = Exec(&options, GolangMode)
+	require.ErrorIs(t, err, ErrPoolClosed)
+}
+
+func TestPoolReconnect(t *testing.T) {
+	srv := setupPoolTest(t)
+	pool := NewPool(&PoolOptions{KeepAliveInterval: 50 * time.Millisecond, IdleTimeout: time.Hour})
+	defer pool.Close()
+
+	options := &ConnectionDialOptions{Host: srv.addr, User: url.User("test")}
+	client, release, err := pool.Client(options)
+	require.NoError(t, err)
+	_, err = ExecRemoteCommand(client, "first")
+	require.NoError(t, err)
+	release()
+	require.Equal(t, int32(1), srv.dials.Load())
+
+	// the keepalive notices the dead connection and drops it
+	srv.dropConnections()
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	client, release, err = pool.Client(options)
+	require.NoError(t, err)
+	defer release()
+	out, err := ExecRemoteCommand(client, "second")
+	require.NoError(t, err)
+	require.Equal(t, "second", string(out))
+	require.Equal(t, int32(2), srv.dials.Load())
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	srv := setupPoolTest(t)
+	pool := NewPool(&PoolOptions{IdleTimeout: 50 * time.Millisecond})
+	defer pool.Close()
+
+	options := &ConnectionDialOptions{Host: srv.addr, User: url.User("test")}
+	_, release, err := pool.Client(options)
+	require.NoError(t, err)
+
+	// in use connections are never closed
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, 1, pool.Len())
+
+	release()
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,576 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// CopyProgress is passed to CopyOptions.Progress while files are transferred.
+type CopyProgress struct {
+	// Path of the file being transferred, relative to the copy source
+	Path string
+	// Transferred is the number of bytes of the file written so far,
+	// including bytes skipped because of a resumed transfer
+	Transferred int64
+	// Total is the size of the file
+	Total int64
+}
+
+type CopyOptions struct {
+	// Resume continues partial transfers by appending to an existing
+	// destination file that is smaller than the source
+	Resume bool
+	// Checksum compares the SHA-256 of source and destination after each
+	// transfer. For Sync it is also used to detect changed files instead
+	// of comparing size and modification time. Note that checksumming a
+	// remote file reads it over the connection.
+	Checksum bool
+	// PreservePermissions copies the permission bits and modification
+	// times of files and directories
+	PreservePermissions bool
+	// Progress is called after every chunk written, optional
+	Progress func(CopyProgress)
+}
+
+type SyncOptions struct {
+	CopyOptions
+	// Delete removes files and directories in the destination which do
+	// not exist in the source
+	Delete bool
+	// Exclude lists path.Match patterns of source paths, relative to the
+	// source directory, which are neither copied nor deleted
+	Exclude []string
+}
+
+type SyncReport struct {
+	// Copied lists the files transferred, relative to the source
+	Copied []string
+	// Skipped lists the files which were already up to date
+	Skipped []string
+	// Deleted lists the destination paths removed because of SyncOptions.Delete
+	Deleted []string
+	// Bytes is the number of bytes transferred
+	Bytes int64
+}
+
+// ErrChecksumMismatch is returned when a transferred file does not match its source.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// copyChunkSize is the size of the buffer used to copy files, progress is reported once per chunk.
+const copyChunkSize = 1 << 20
+
+// fileWriter is an open destination file.
+type fileWriter interface {
+	io.WriteCloser
+	io.Seeker
+}
+
+// syncFS abstracts the local and the remote file system so that copies
+// in both directions share one implementation.
+type syncFS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	// OpenWriter opens name for writing, truncating it unless resume is set
+	OpenWriter(name string, resume bool) (fileWriter, error)
+	MkdirAll(name string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, mtime time.Time) error
+	RemoveAll(name string) error
+	Readlink(name string) (string, error)
+	Symlink(target, name string) error
+	Join(elem ...string) string
+	Dir(name string) string
+}
+
+type localFS struct{}
+
+func (localFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (localFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(name) }
+
+func (localFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+func (localFS) OpenWriter(name string, resume bool) (fileWriter, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	return os.OpenFile(name, flags, 0o644)
+}
+
+func (localFS) MkdirAll(name string) error { return os.MkdirAll(name, 0o755) }
+
+func (localFS) Chmod(name string, mode fs.FileMode) error { return os.Chmod(name, mode) }
+
+func (localFS) Chtimes(name string, mtime time.Time) error { return os.Chtimes(name, mtime, mtime) }
+
+func (localFS) RemoveAll(name string) error { return os.RemoveAll(name) }
+
+func (localFS) Readlink(name string) (string, error) { return os.Readlink(name) }
+
+func (localFS) Symlink(target, name string) error { return os.Symlink(target, name) }
+
+func (localFS) Join(elem ...string) string { return filepath.Join(elem...) }
+
+func (localFS) Dir(name string) string { return filepath.Dir(name) }
+
+type remoteFS struct {
+	client *sftp.Client
+}
+
+func (r remoteFS) Stat(name string) (fs.FileInfo, error) { return r.client.Stat(name) }
+
+func (r remoteFS) Lstat(name string) (fs.FileInfo, error) { return r.client.Lstat(name) }
+
+func (r remoteFS) ReadDir(name string) ([]fs.FileInfo, error) { return r.client.ReadDir(name) }
+
+func (r remoteFS) Open(name string) (io.ReadCloser, error) { return r.client.Open(name) }
+
+func (r remoteFS) OpenWriter(name string, resume bool) (fileWriter, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	return r.client.OpenFile(name, flags)
+}
+
+func (r remoteFS) MkdirAll(name string) error { return r.client.MkdirAll(name) }
+
+func (r remoteFS) Chmod(name string, mode fs.FileMode) error { return r.client.Chmod(name, mode) }
+
+func (r remoteFS) Chtimes(name string, mtime time.Time) error {
+	return r.client.Chtimes(name, mtime, mtime)
+}
+
+func (r remoteFS) RemoveAll(name string) error { return r.client.RemoveAll(name) }
+
+func (r remoteFS) Readlink(name string) (string, error) { return r.client.ReadLink(name) }
+
+func (r remoteFS) Symlink(target, name string) error { return r.client.Symlink(target, name) }
+
+func (r remoteFS) Join(elem ...string) string { return path.Join(elem...) }
+
+func (r remoteFS) Dir(name string) string { return path.Dir(name) }
+
+// CopyToRemote copies the local file or directory tree at localPath to
+// remotePath over the given sftp client. Missing parent directories of
+// remotePath are created.
+func CopyToRemote(client *sftp.Client, localPath, remotePath string, options *CopyOptions) (int64, error) {
+	return copyTree(localFS{}, remoteFS{client}, localPath, remotePath, options)
+}
+
+// CopyFromRemote copies the remote file or directory tree at remotePath to
+// localPath over the given sftp client. Missing parent directories of
+// localPath are created.
+func CopyFromRemote(client *sftp.Client, remotePath, localPath string, options *CopyOptions) (int64, error) {
+	return copyTree(remoteFS{client}, localFS{}, remotePath, localPath, options)
+}
+
+// SyncToRemote makes the remote directory remoteDir a copy of the local
+// directory localDir, only transferring files which are missing or changed.
+func SyncToRemote(client *sftp.Client, localDir, remoteDir string, options *SyncOptions) (*SyncReport, error) {
+	return syncTree(localFS{}, remoteFS{client}, localDir, remoteDir, options)
+}
+
+// SyncFromRemote makes the local directory localDir a copy of the remote
+// directory remoteDir, only transferring files which are missing or changed.
+func SyncFromRemote(client *sftp.Client, remoteDir, localDir string, options *SyncOptions) (*SyncReport, error) {
+	return syncTree(remoteFS{client}, localFS{}, remoteDir, localDir, options)
+}
+
+func copyTree(src, dst syncFS, srcPath, dstPath string, options *CopyOptions) (int64, error) {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+	info, err := src.Stat(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := dst.MkdirAll(dst.Dir(dstPath)); err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return copyFile(src, dst, srcPath, dstPath, info.Name(), info, options)
+	}
+	if err := copyDir(dst, dstPath, info, options); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	err = walk(src, srcPath, "", func(rel string, info fs.FileInfo) error {
+		s := joinRel(src, srcPath, rel)
+		d := joinRel(dst, dstPath, rel)
+		if info.IsDir() {
+			return copyDir(dst, d, info, options)
+		}
+		n, err := copyFile(src, dst, s, d, rel, info, options)
+		total += n
+		return err
+	})
+	if err != nil {
+		return total, err
+	}
+	return total, fixDirs(src, dst, srcPath, dstPath, nil, options)
+}
+
+func syncTree(src, dst syncFS, srcDir, dstDir string, options *SyncOptions) (*SyncReport, error) {
+	if options == nil {
+		options = &SyncOptions{}
+	}
+	// modification times are needed to detect changes on the next sync
+	copyOptions := options.CopyOptions
+	copyOptions.PreservePermissions = true
+
+	info, err := src.Stat(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("sync source %q is not a directory", srcDir)
+	}
+	if err := copyDir(dst, dstDir, info, &copyOptions); err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{}
+	seen := map[string]struct{}{"": {}}
+	err = walk(src, srcDir, "", func(rel string, info fs.FileInfo) error {
+		if excluded(rel, options.Exclude) {
+			return filepath.SkipDir
+		}
+		seen[rel] = struct{}{}
+		s := joinRel(src, srcDir, rel)
+		d := joinRel(dst, dstDir, rel)
+		dstInfo, err := dst.Lstat(d)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if dstInfo != nil && dstInfo.Mode().Type() != info.Mode().Type() {
+			// never write through a symlink or over a directory
+			if err := dst.RemoveAll(d); err != nil {
+				return err
+			}
+			dstInfo = nil
+		}
+		if info.IsDir() {
+			return copyDir(dst, d, info, &copyOptions)
+		}
+		if dstInfo != nil {
+			same, err := sameFile(src, dst, s, d, info, dstInfo, options.Checksum)
+			if err != nil {
+				return err
+			}
+			if same {
+				report.Skipped = append(report.Skipped, rel)
+				return nil
+			}
+		}
+		n, err := copyFile(src, dst, s, d, rel, info, &copyOptions)
+		report.Bytes += n
+		if err != nil {
+			return err
+		}
+		report.Copied = append(report.Copied, rel)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if options.Delete {
+		var remove []string
+		err := walk(dst, dstDir, "", func(rel string, info fs.FileInfo) error {
+			if _, ok := seen[rel]; ok {
+				return nil
+			}
+			if excluded(rel, options.Exclude) {
+				return filepath.SkipDir
+			}
+			remove = append(remove, rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+		for _, rel := range remove {
+			if err := dst.RemoveAll(joinRel(dst, dstDir, rel)); err != nil {
+				return report, err
+			}
+			report.Deleted = append(report.Deleted, rel)
+		}
+	}
+	return report, fixDirs(src, dst, srcDir, dstDir, options.Exclude, &copyOptions)
+}
+
+// walk calls fn for every entry below root in lexical order, rel is the
+// slash separated path relative to root. If fn returns filepath.SkipDir for
+// a directory its content is skipped, for a file the entry is skipped.
+func walk(fsys syncFS, root, rel string, fn func(rel string, info fs.FileInfo) error) error {
+	infos, err := fsys.ReadDir(joinRel(fsys, root, rel))
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	for _, info := range infos {
+		child := path.Join(rel, info.Name())
+		if err := fn(child, info); err != nil {
+			if errors.Is(err, filepath.SkipDir) {
+				continue
+			}
+			return err
+		}
+		if info.IsDir() {
+			if err := walk(fsys, root, child, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func excluded(rel string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// joinRel joins the slash separated relative path to root using the path
+// separator of the file system.
+func joinRel(fsys syncFS, root, rel string) string {
+	if rel == "" {
+		return root
+	}
+	return fsys.Join(append([]string{root}, strings.Split(rel, "/")...)...)
+}
+
+// copyDir creates the directory. With PreservePermissions it is kept
+// writable while its content is copied, fixDirs applies the mode of the
+// source afterwards.
+func copyDir(dst syncFS, name string, info fs.FileInfo, options *CopyOptions) error {
+	if err := dst.MkdirAll(name); err != nil {
+		return err
+	}
+	if options.PreservePermissions {
+		// a previous copy may have left a read-only directory behind
+		return dst.Chmod(name, info.Mode().Perm()|0o700)
+	}
+	return nil
+}
+
+// fixDirs sets the mode and modification time of the copied directories,
+// both change when entries are added so they must be set last. Children
+// are fixed before their parents as the parents may become read-only.
+func fixDirs(src, dst syncFS, srcDir, dstDir string, exclude []string, options *CopyOptions) error {
+	if !options.PreservePermissions {
+		return nil
+	}
+	var dirs []string
+	err := walk(src, srcDir, "", func(rel string, info fs.FileInfo) error {
+		if excluded(rel, exclude) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			dirs = append(dirs, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slices.Reverse(dirs)
+	for _, rel := range append(dirs, "") {
+		info, err := src.Stat(joinRel(src, srcDir, rel))
+		if err != nil {
+			return err
+		}
+		d := joinRel(dst, dstDir, rel)
+		if err := dst.Chmod(d, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := dst.Chtimes(d, info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a single regular file and returns the number of bytes written.
+func copyFile(src, dst syncFS, srcPath, dstPath, rel string, info fs.FileInfo, options *CopyOptions) (int64, error) {
+	if info.Mode().Type() == fs.ModeSymlink {
+		return 0, copySymlink(src, dst, srcPath, dstPath)
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("%q: copying %s is not supported", srcPath, info.Mode().Type())
+	}
+
+	var offset int64
+	if options.Resume {
+		if dstInfo, err := dst.Stat(dstPath); err == nil && dstInfo.Mode().IsRegular() && dstInfo.Size() <= info.Size() {
+			offset = dstInfo.Size()
+		}
+	}
+
+	in, err := src.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := dst.OpenWriter(dstPath, offset > 0)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	if offset > 0 {
+		if seeker, ok := in.(io.Seeker); ok {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+		} else if _, err := io.CopyN(io.Discard, in, offset); err != nil {
+			return 0, err
+		}
+		if _, err := out.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	var written int64
+	buf := make([]byte, copyChunkSize)
+	for {
+		n, rerr := in.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			if options.Progress != nil {
+				options.Progress(CopyProgress{Path: rel, Transferred: offset + written, Total: info.Size()})
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+	if err := out.Close(); err != nil {
+		return written, err
+	}
+
+	if options.PreservePermissions {
+		if err := dst.Chmod(dstPath, info.Mode().Perm()); err != nil {
+			return written, err
+		}
+		if err := dst.Chtimes(dstPath, info.ModTime()); err != nil {
+			return written, err
+		}
+	}
+	if options.Checksum {
+		same, err := sameChecksum(src, dst, srcPath, dstPath)
+		if err != nil {
+			return written, err
+		}
+		if !same {
+			return written, fmt.Errorf("%q: %w", dstPath, ErrChecksumMismatch)
+		}
+	}
+	return written, nil
+}
+
+// copySymlink recreates the symlink srcPath at dstPath, the target is copied as is.
+func copySymlink(src, dst syncFS, srcPath, dstPath string) error {
+	target, err := src.Readlink(srcPath)
+	if err != nil {
+		return err
+	}
+	if err := dst.RemoveAll(dstPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return dst.Symlink(target, dstPath)
+}
+
+// sameFile reports whether the destination is up to date, either by
+// comparing size and modification time or, if checksum is set, the content.
+// Symlinks are up to date if they point to the same target.
+func sameFile(src, dst syncFS, srcPath, dstPath string, srcInfo, dstInfo fs.FileInfo, checksum bool) (bool, error) {
+	if srcInfo.Mode().Type() == fs.ModeSymlink {
+		a, err := src.Readlink(srcPath)
+		if err != nil {
+			return false, err
+		}
+		b, err := dst.Readlink(dstPath)
+		return a == b, err
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+	if !checksum {
+		// sftp only transfers whole seconds
+		return srcInfo.ModTime().Truncate(time.Second).Equal(dstInfo.ModTime().Truncate(time.Second)), nil
+	}
+	return sameChecksum(src, dst, srcPath, dstPath)
+}
+
+func sameChecksum(src, dst syncFS, srcPath, dstPath string) (bool, error) {
+	a, err := fileChecksum(src, srcPath)
+	if err != nil {
+		return false, err
+	}
+	b, err := fileChecksum(dst, dstPath)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
+
+func fileChecksum(fsys syncFS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,228 @@
+package ssh
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSftpClient returns a client connected to an in-process sftp server
+// serving the local file system.
+func newTestSftpClient(t *testing.T) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		// closing the server ends the client receive loop
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+}
+
+func TestCopyToRemoteDirectory(t *testing.T) {
+	client := newTestSftpClient(t)
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "a", "b")
+	writeTree(t, src, map[string]string{
+		"file":         "hello",
+		"dir/nested":   "world",
+		"dir/sub/deep": "!",
+	})
+	require.NoError(t, os.Chmod(filepath.Join(src, "file"), 0o600))
+	require.NoError(t, os.Symlink("file", filepath.Join(src, "link")))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "dir", "nested"), mtime, mtime))
+
+	var progress []CopyProgress
+	n, err := CopyToRemote(client, src, dst, &CopyOptions{
+		Checksum:            true,
+		PreservePermissions: true,
+		Progress:            func(p CopyProgress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(11), n)
+	require.Len(t, progress, 3)
+	require.Equal(t, CopyProgress{Path: "dir/nested", Transferred: 5, Total: 5}, progress[0])
+
+	data, err := os.ReadFile(filepath.Join(dst, "dir", "sub", "deep"))
+	require.NoError(t, err)
+	require.Equal(t, "!", string(data))
+	st, err := os.Stat(filepath.Join(dst, "file"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+	st, err = os.Stat(filepath.Join(dst, "dir", "nested"))
+	require.NoError(t, err)
+	require.True(t, mtime.Equal(st.ModTime()))
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "file", target)
+}
+
+func TestCopyFromRemoteResume(t *testing.T) {
+	client := newTestSftpClient(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "remote")
+	dst := filepath.Join(dir, "local")
+	require.NoError(t, os.WriteFile(src, []byte("0123456789"), 0o644))
+	require.NoError(t, os.WriteFile(dst, []byte("01234"), 0o644))
+
+	n, err := CopyFromRemote(client, src, dst, &CopyOptions{Resume: true, Checksum: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(data))
+
+	// a corrupted partial file is detected by the checksum
+	require.NoError(t, os.WriteFile(dst, []byte("xxxxx"), 0o644))
+	_, err = CopyFromRemote(client, src, dst, &CopyOptions{Resume: true, Checksum: true})
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// without resume the file is replaced
+	n, err = CopyFromRemote(client, src, dst, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), n)
+	data, err = os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(data))
+}
+
+func TestSyncToRemote(t *testing.T) {
+	client := newTestSftpClient(t)
+	src := t.TempDir()
+	dst := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"keep":      "same",
+		"change":    "old",
+		"dir/file":  "x",
+		"cache/tmp": "ignored",
+	})
+	writeTree(t, dst, map[string]string{
+		"stale":     "remove me",
+		"olddir/f":  "remove me",
+		"cache/own": "excluded from delete",
+	})
+
+	opts := &SyncOptions{Delete: true, Exclude: []string{"cache"}}
+	report, err := SyncToRemote(client, src, dst, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"change", "dir/file", "keep"}, report.Copied)
+	require.Empty(t, report.Skipped)
+	require.Equal(t, []string{"olddir", "stale"}, report.Deleted)
+	require.Equal(t, int64(8), report.Bytes)
+
+	report, err = SyncToRemote(client, src, dst, opts)
+	require.NoError(t, err)
+	require.Empty(t, report.Copied)
+	require.Equal(t, []string{"change", "dir/file", "keep"}, report.Skipped)
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "change"), []byte("new"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "change"), later, later))
+	report, err = SyncToRemote(client, src, dst, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"change"}, report.Copied)
+
+	data, err := os.ReadFile(filepath.Join(dst, "change"))
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+	_, err = os.Stat(filepath.Join(dst, "cache", "own"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dst, "cache", "tmp"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// with checksums a content change with identical size and mtime is found
+	st, err := os.Stat(filepath.Join(dst, "change"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "change"), []byte("bad"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(dst, "change"), st.ModTime(), st.ModTime()))
+	report, err = SyncToRemote(client, src, dst, &SyncOptions{CopyOptions: CopyOptions{Checksum: true}, Exclude: opts.Exclude})
+	require.NoError(t, err)
+	require.Equal(t, []string{"change"}, report.Copied)
+}
+
+// permFS enforces the owner write permission of directories, tests usually
+// run as root where the kernel does not.
+type permFS struct {
+	localFS
+}
+
+func (p permFS) checkWritable(name string) error {
+	st, err := os.Stat(filepath.Dir(name))
+	if err == nil && st.Mode().Perm()&0o200 == 0 {
+		return &os.PathError{Op: "write", Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (p permFS) OpenWriter(name string, resume bool) (fileWriter, error) {
+	if err := p.checkWritable(name); err != nil {
+		return nil, err
+	}
+	return p.localFS.OpenWriter(name, resume)
+}
+
+func (p permFS) MkdirAll(name string) error {
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+	if err := p.checkWritable(name); err != nil {
+		return err
+	}
+	return p.localFS.MkdirAll(name)
+}
+
+func TestSyncReadOnlyDirectory(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "dst")
+	writeTree(t, src, map[string]string{"ro/sub/file": "x"})
+	require.NoError(t, os.Chmod(filepath.Join(src, "ro", "sub"), 0o555))
+	require.NoError(t, os.Chmod(filepath.Join(src, "ro"), 0o555))
+	defer func() {
+		_ = os.Chmod(filepath.Join(src, "ro"), 0o755)
+		_ = os.Chmod(filepath.Join(src, "ro", "sub"), 0o755)
+		_ = os.Chmod(filepath.Join(dst, "ro"), 0o755)
+		_ = os.Chmod(filepath.Join(dst, "ro", "sub"), 0o755)
+	}()
+
+	report, err := syncTree(localFS{}, permFS{}, src, dst, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ro/sub/file"}, report.Copied)
+	for _, dir := range []string{"ro", "ro/sub"} {
+		st, err := os.Stat(filepath.Join(dst, dir))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o555), st.Mode().Perm(), dir)
+	}
+
+	// the read-only destination is writable again while syncing
+	require.NoError(t, os.Chmod(filepath.Join(src, "ro", "sub"), 0o755))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "ro", "sub", "file"), []byte("y"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "ro", "sub", "file"), later, later))
+	require.NoError(t, os.Chmod(filepath.Join(src, "ro", "sub"), 0o555))
+	report, err = syncTree(localFS{}, permFS{}, src, dst, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ro/sub/file"}, report.Copied)
+}
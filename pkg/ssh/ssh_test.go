@@ -114,4 +114,8 @@ func TestScp(t *testing.T) {
 
 	_, err = Scp(&options, GolangMode)
 	require.Error(t, err, "failed to connect: ssh: handshake failed: ssh: disconnect, reason 2: Too many authentication failures")
+
+	options.CopyOptions = &CopyOptions{Resume: true}
+	_, err = Scp(&options, NativeMode)
+	require.EqualError(t, err, "resume, checksum and progress copy options are only supported in golang mode")
 }
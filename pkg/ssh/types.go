@@ -49,6 +49,8 @@ type ConnectionExecOptions struct {
 	Auth     []string
 	Args     []string
 	Timeout  time.Duration
+	// Pool to take the connection from in golang mode, optional
+	Pool *Pool
 }
 
 type ConnectionExecReport struct {
@@ -61,6 +63,12 @@ type ConnectionScpOptions struct {
 	Destination string
 	Identity    string
 	Port        int
+	// CopyOptions tune the transfer, optional. Resume, Checksum and
+	// Progress are only supported in golang mode, native mode returns an
+	// error when they are set.
+	CopyOptions *CopyOptions
+	// Pool to take the connection from in golang mode, optional
+	Pool *Pool
 }
 
 type ConnectionScpReport struct {
@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -100,7 +101,12 @@ func PublicKey(path string, passphrase []byte) (ssh.Signer, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parsePrivateKey(key, passphrase)
+}
 
+// parsePrivateKey parses a PEM encoded private key, if the key is encrypted
+// and no passphrase is given the user is prompted for it.
+func parsePrivateKey(key []byte, passphrase []byte) (ssh.Signer, error) {
 	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
 		if _, ok := err.(*ssh.PassphraseMissingError); !ok {
@@ -191,3 +197,39 @@ func DefineMode(flag string) EngineMode {
 		return InvalidMode
 	}
 }
+
+// GetRemoteInfo runs "podman info" on the remote host and returns the parsed result.
+func GetRemoteInfo(options *ConnectionExecOptions, kind EngineMode) (*Info, error) {
+	// Override podman binary for testing etc
+	podman := "podman"
+	if v, found := os.LookupEnv("PODMAN_BINARY"); found {
+		podman = v
+	}
+	opts := *options
+	opts.Args = []string{podman, "info", "--format=json"}
+	out, err := Exec(&opts, kind)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	if err := json.Unmarshal([]byte(out), info); err != nil {
+		return nil, fmt.Errorf("failed to parse 'podman info' results: %w", err)
+	}
+	return info, nil
+}
+
+// FarmNodeLoad converts the info reported by a farm node into the load
+// used by config.SelectFarmNodes. Running containers count as running jobs.
+func FarmNodeLoad(info *Info) config.FarmNodeLoad {
+	var load config.FarmNodeLoad
+	if info.Host != nil {
+		load.CPUs = info.Host.CPUs
+		if info.Host.CPUUtilization != nil {
+			load.CPUUtilization = 100 - info.Host.CPUUtilization.IdlePercent
+		}
+	}
+	if info.Store != nil {
+		load.RunningJobs = info.Store.ContainerStore.Running
+	}
+	return load
+}
@@ -35,3 +35,19 @@ func TestValidate(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, dst.URI, "ssh://testhost:22/var/run/podman/podman.sock")
 }
+
+func TestFarmNodeLoad(t *testing.T) {
+	load := FarmNodeLoad(&Info{})
+	require.Zero(t, load)
+
+	load = FarmNodeLoad(&Info{
+		Host: &HostInfo{
+			CPUs:           8,
+			CPUUtilization: &CPUUsage{IdlePercent: 75},
+		},
+		Store: &StoreInfo{ContainerStore: ContainerStore{Running: 3}},
+	})
+	require.Equal(t, 8, load.CPUs)
+	require.Equal(t, 3, load.RunningJobs)
+	require.InDelta(t, 25, load.CPUUtilization, 0.001)
+}